@@ -1,44 +1,143 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
+	"net/http"
 	"os"
 
+	"central-auth/internal/audit"
+	"central-auth/internal/clock"
 	"central-auth/internal/config"
 	"central-auth/internal/http/handler"
 	"central-auth/internal/http/middleware"
+	"central-auth/internal/ids"
+	"central-auth/internal/migrate"
 	"central-auth/internal/repository"
 	"central-auth/internal/service"
+	"central-auth/internal/sms"
+	"central-auth/internal/token"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	// Redis
-	rdb := config.NewRedisClient()
-	if _, err := rdb.Ping(config.Ctx).Result(); err != nil {
-		panic(err)
+	// `server config check [file]` validates the configuration and prints it
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:], os.Stdout))
 	}
-	fmt.Println("Redis connected")
 
-	//Postgres
-	pgPool, err := config.NewPostgresConn()
+	// defaults, CONFIG_FILE, then the environment; an invalid or insecure
+	// configuration refuses to start
+	cfg, err := config.Load("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	token.Secret = []byte(cfg.JWT.Secret.Value)
+
+	// `server migrate up|down|status` only touches postgres
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+	// `server migrate-redis-keys` moves sessions to the hash tagged key layout
+	if len(os.Args) > 1 && os.Args[1] == "migrate-redis-keys" {
+		os.Exit(runMigrateRedisKeys(cfg))
+	}
+	// `server service-clients ...` registers calling backends and their keys
+	if len(os.Args) > 1 && os.Args[1] == "service-clients" {
+		os.Exit(runServiceClients(cfg, os.Args[2:], os.Stdout))
+	}
+
+	ctx := context.Background()
+	clk := clock.Real{}
+	timeouts := cfg.Timeouts
+
+	// Session store: redis (default) or memory, the default with sqlite
+	var sessionStore repository.SessionStore
+	switch cfg.Storage.SessionStore {
+	case config.SessionStoreRedis:
+		rdb, err := config.NewRedisClient(cfg.Redis)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := rdb.Ping(ctx).Result(); err != nil {
+			panic(err)
+		}
+		fmt.Println("Redis connected")
+		sessionStore = repository.WithSessionStoreTimeout(repository.NewRedisRepository(rdb, clk), "redis", timeouts.Redis.Std())
+	default:
+		fmt.Println("Using in-memory session store")
+		sessionStore = repository.NewMemorySessionStore(clk)
+	}
+
+	// repo: postgres (default) or sqlite, sqlite runs as a single binary
+	authUserRepo, closeRepo, err := openAuthUserRepository(ctx, cfg, clk, cfg.Storage.MigrateOnStart)
 	if err != nil {
 		panic(err)
 	}
-	defer pgPool.Close()
-	fmt.Println("Postgres connected")
+	defer closeRepo()
 
-	// repo
-	redisRepo := repository.NewRedisRepository(rdb)
-	authUserRepo := repository.NewPostgresAuthUserRepository(pgPool)
+	// WebAuthn
+	webAuthn, err := config.NewWebAuthn(cfg.WebAuthn)
+	if err != nil {
+		panic(err)
+	}
+	// Device and session policies, token TTLs per calling service, service
+	// authentication and tenants
+	policies, err := config.LoadPolicies(cfg)
+	if err != nil {
+		panic(err)
+	}
+	token.TenantSecrets = policies.TenantKeys
+	// Janitor
+	retention, err := cfg.Janitor.RetentionPolicy()
+	if err != nil {
+		panic(err)
+	}
+	// Service authentication: API keys and/or TLS client certificates
+	tlsConfig, err := config.NewTLSConfig(cfg.Server.TLS)
+	if err != nil {
+		panic(err)
+	}
+	// SMS
+	smsSender, err := config.NewSMSSender(cfg.SMS)
+	if err != nil {
+		panic(err)
+	}
 	// Service
-	authService := service.NewAuthService(redisRepo, authUserRepo)
+	auditor := audit.NewLogRecorder()
+	authService := service.NewAuthService(sessionStore, authUserRepo, auditor, policies.Devices, policies.Sessions, policies.TokenLifetimes, policies.Tenants, clk, ids.UUID{})
+	webauthnService := service.NewWebAuthnService(webAuthn, sessionStore, authUserRepo, authService)
+	otpService := service.NewOTPService(sessionStore, authUserRepo, authService, sms.WithTimeout(smsSender, timeouts.SMS.Std()))
+	// applies revocations to redis that failed inline
+	outboxWorker := service.NewSessionOutboxWorker(sessionStore, authUserRepo, clk)
+	go outboxWorker.Run(ctx)
+	// rebuilds redis sessions from postgres (startup and periodic)
+	reconciler := service.NewSessionReconciler(sessionStore, authUserRepo, policies.Sessions, clk)
+	go reconciler.Run(ctx)
+	// archives finished sessions, one replica at a time
+	janitor := service.NewSessionJanitor(authUserRepo, retention, clk)
+	go janitor.Run(ctx)
+	// calling backends, their API keys and certificates
+	sealer, err := token.NewSealer(cfg.ServiceKeySealSecret())
+	if err != nil {
+		panic(err)
+	}
+	serviceClients := service.NewServiceClientService(authUserRepo, sessionStore, sealer, clk, ids.UUID{})
+	serviceAuth := middleware.ServiceAuthMiddleware(serviceClients, policies.ServiceAuth)
 	// Handler
-	authHandler := handler.NewAuthHandler(authService)
+	authHandler := handler.NewAuthHandler(authService, cfg.Google.ClientID)
+	webauthnHandler := handler.NewWebAuthnHandler(webauthnService)
+	otpHandler := handler.NewOTPHandler(otpService)
 
 	// Start server
 	r := gin.Default()
+	// client addresses feed the service IP allowlists
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
 	// log
 	r.Use(gin.LoggerWithWriter(os.Stdout))
 	r.Use(gin.RecoveryWithWriter(os.Stderr))
@@ -49,8 +148,11 @@ func main() {
 		})
 	})
 
+	// metrics (session reconciler, janitor)
+	r.GET("/debug/vars", serviceAuth, gin.WrapH(expvar.Handler()))
+
 	auth := r.Group("/auth")
-	auth.Use(middleware.ServiceAuthMiddleware())
+	auth.Use(serviceAuth)
 	{
 		auth.POST("/login", authHandler.Login)
 		auth.POST("/oauth/login", authHandler.OAuthLogin)
@@ -58,8 +160,120 @@ func main() {
 
 		auth.POST("/logout", authHandler.Logout)
 		auth.POST("/logout-all", authHandler.LogoutAll)
+		auth.POST("/logout-others", authHandler.LogoutOthers)
 		auth.POST("/verify", authHandler.Verify)
+		auth.POST("/reauth", authHandler.Reauth)
+		auth.GET("/sessions", authHandler.ListSessions)
+		auth.DELETE("/sessions/:device_id", authHandler.RevokeSession)
+
+		// WebAuthn / passkey
+		auth.POST("/webauthn/register/begin", webauthnHandler.RegisterBegin)
+		auth.POST("/webauthn/register/finish", webauthnHandler.RegisterFinish)
+		auth.POST("/webauthn/login/begin", webauthnHandler.LoginBegin)
+		auth.POST("/webauthn/login/finish", webauthnHandler.LoginFinish)
+		auth.POST("/webauthn/reauth/finish", webauthnHandler.ReauthFinish)
+
+		// SMS one-time passcode
+		auth.POST("/otp/sms/send", otpHandler.SendSMS)
+		auth.POST("/otp/sms/verify", otpHandler.VerifySMS)
+	}
+	if tlsConfig == nil {
+		fmt.Println("Central-Auth server running on " + cfg.Server.Addr)
+		r.Run(cfg.Server.Addr)
+		return
+	}
+	server := &http.Server{
+		Addr:      cfg.Server.Addr,
+		Handler:   r,
+		TLSConfig: tlsConfig,
+	}
+	fmt.Println("Central-Auth server running on " + cfg.Server.Addr + " (TLS)")
+	// the certificate is already in TLSConfig
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		panic(err)
+	}
+}
+
+// openAuthUserRepository connects the repository selected by
+// cfg.Storage.Backend, postgres (default) or sqlite. closeRepo releases its
+// connections.
+func openAuthUserRepository(
+	ctx context.Context,
+	cfg *config.Config,
+	clk clock.Clock,
+	runMigrations bool,
+) (repo repository.AuthUserRepository, closeRepo func(), err error) {
+	timeout := cfg.Timeouts.Database.Std()
+	switch cfg.Storage.Backend {
+	case config.StoragePostgres:
+		pgPool, err := config.NewPostgresConn(cfg.Postgres)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Println("Postgres connected")
+
+		// Migrations
+		if runMigrations {
+			migrator, err := migrate.New(pgPool)
+			if err == nil {
+				_, err = migrator.Up(ctx)
+			}
+			if err != nil {
+				pgPool.Close()
+				return nil, nil, err
+			}
+		}
+		repo = repository.NewPostgresAuthUserRepository(pgPool)
+		return repository.WithAuthUserRepositoryTimeout(repo, "postgres", timeout), pgPool.Close, nil
+	case config.StorageSQLite:
+		db, err := config.NewSQLiteConn(cfg.SQLite.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		repo, err = repository.NewSQLiteAuthUserRepository(db, clk)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		fmt.Println("SQLite opened")
+		return repository.WithAuthUserRepositoryTimeout(repo, "sqlite", timeout), func() { db.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend %s", cfg.Storage.Backend)
+	}
+}
+
+func runMigrate(cfg *config.Config, args []string) int {
+	pgPool, err := config.NewPostgresConn(cfg.Postgres)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer pgPool.Close()
+
+	migrator, err := migrate.New(pgPool)
+	if err == nil {
+		err = migrator.Run(context.Background(), args, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func runMigrateRedisKeys(cfg *config.Config) int {
+	rdb, err := config.NewRedisClient(cfg.Redis)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer rdb.Close()
+
+	moved, err := repository.MigrateRedisKeys(context.Background(), rdb)
+	fmt.Printf("moved %d keys\n", moved)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
-	fmt.Println("Central-Auth server running on :8081")
-	r.Run(":8081")
+	return 0
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/config"
+	"central-auth/internal/domain"
+	"central-auth/internal/ids"
+	"central-auth/internal/service"
+	"central-auth/internal/token"
+)
+
+const serviceClientsUsage = `usage:
+  server service-clients register <name> [-tenant acme] [-endpoints "POST /auth/login,/auth/refresh"] [-ips 10.0.0.0/8] [-cert-identities cn:shop,dns:shop.internal] [-disabled]
+  server service-clients issue-key <name> [-ttl 2160h]
+  server service-clients revoke-key <key_id>
+  server service-clients list`
+
+// runServiceClients manages the service_clients registry from the command line.
+func runServiceClients(cfg *config.Config, args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, serviceClientsUsage)
+		return 2
+	}
+
+	ctx := context.Background()
+	clk := clock.Real{}
+	repo, closeRepo, err := openAuthUserRepository(ctx, cfg, clk, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer closeRepo()
+	// issue-key seals the signing key with the server's key
+	sealer, err := token.NewSealer(cfg.ServiceKeySealSecret())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	clients := service.NewServiceClientService(repo, nil, sealer, clk, ids.UUID{})
+
+	switch args[0] {
+	case "register":
+		err = registerServiceClient(ctx, clients, args[1:], out)
+	case "issue-key":
+		err = issueServiceKey(ctx, clients, args[1:], out)
+	case "revoke-key":
+		if len(args) != 2 {
+			err = errors.New(serviceClientsUsage)
+			break
+		}
+		if err = clients.RevokeKey(ctx, args[1]); err == nil {
+			fmt.Fprintf(out, "revoked %s\n", args[1])
+		}
+	case "list":
+		err = listServiceClients(ctx, clients, out)
+	default:
+		err = errors.New(serviceClientsUsage)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func registerServiceClient(ctx context.Context, clients *service.ServiceClientService, args []string, out io.Writer) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New(serviceClientsUsage)
+	}
+	fs := flag.NewFlagSet("register", flag.ContinueOnError)
+	tenant := fs.String("tenant", "", "tenant whose users the service logs in, default when empty")
+	endpoints := fs.String("endpoints", "", "comma separated [METHOD ]PATH, a trailing * matches any suffix")
+	ips := fs.String("ips", "", "comma separated addresses or CIDRs")
+	certIdentities := fs.String("cert-identities", "", "comma separated cn:, dns:, uri: or email: names of its TLS client certificate")
+	disabled := fs.Bool("disabled", false, "reject every key and certificate of the service")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	client := &domain.ServiceClient{
+		Name:             args[0],
+		TenantID:         *tenant,
+		AllowedEndpoints: splitList(*endpoints),
+		AllowedIPs:       splitList(*ips),
+		CertIdentities:   splitList(*certIdentities),
+		Disabled:         *disabled,
+	}
+	if err := clients.Register(ctx, client); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "registered %s tenant=%s\n", client.Name, client.TenantID)
+	return nil
+}
+
+func issueServiceKey(ctx context.Context, clients *service.ServiceClientService, args []string, out io.Writer) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New(serviceClientsUsage)
+	}
+	fs := flag.NewFlagSet("issue-key", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 0, "lifetime of the key, 0 never expires")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	apiKey, key, err := clients.IssueKey(ctx, args[0], *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "key_id:  %s\n", key.KeyID)
+	if key.ExpiresAt != nil {
+		fmt.Fprintf(out, "expires: %s\n", key.ExpiresAt.Format(time.RFC3339))
+	}
+	// the only time the secret is shown
+	fmt.Fprintf(out, "X-Service-Key: %s\n", apiKey)
+	return nil
+}
+
+func listServiceClients(ctx context.Context, clients *service.ServiceClientService, out io.Writer) error {
+	list, err := clients.List(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tKEY\tSTATE\tEXPIRES\tLAST USED")
+	for _, c := range list {
+		keys, err := clients.Keys(ctx, c.Name)
+		if err != nil {
+			return err
+		}
+		state := "enabled"
+		if c.Disabled {
+			state = "disabled"
+		}
+		fmt.Fprintf(w, "%s\t\t%s\ttenant=%s\tendpoints=%s\tips=%s\n", c.Name, state, c.TenantID, listOrAll(c.AllowedEndpoints), listOrAll(c.AllowedIPs))
+		if len(c.CertIdentities) > 0 {
+			fmt.Fprintf(w, "\tcert\t\t%s\t\n", strings.Join(c.CertIdentities, ","))
+		}
+		for _, k := range keys {
+			keyState := "active"
+			switch {
+			case k.Revoked:
+				keyState = "revoked"
+			case k.ExpiresAt != nil && !time.Now().Before(*k.ExpiresAt):
+				keyState = "expired"
+			}
+			fmt.Fprintf(w, "\t%s\t%s\t%s\t%s\n", k.KeyID, keyState, formatTime(k.ExpiresAt), formatTime(k.LastUsedAt))
+		}
+	}
+	return w.Flush()
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func listOrAll(l []string) string {
+	if len(l) == 0 {
+		return "*"
+	}
+	return strings.Join(l, ",")
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Security event types
+const (
+	EventSessionRevoked       = "session_revoked"
+	EventOtherSessionsRevoked = "other_sessions_revoked"
+	EventDeviceEvicted        = "device_evicted"
+)
+
+type Event struct {
+	Type          string         `json:"type"`
+	UserID        string         `json:"user_id"`
+	DeviceID      string         `json:"device_id,omitempty"` // device the event is about
+	ActorDeviceID string         `json:"actor_device_id,omitempty"`
+	IP            string         `json:"ip,omitempty"`
+	Time          time.Time      `json:"time"`
+	Details       map[string]any `json:"details,omitempty"`
+}
+
+// Recorder receives security events. Recording must never fail the request,
+// implementations handle their own errors.
+type Recorder interface {
+	Record(ev Event)
+}
+
+// LogRecorder writes events as JSON lines to the standard logger.
+type LogRecorder struct{}
+
+func NewLogRecorder() *LogRecorder {
+	return &LogRecorder{}
+}
+
+func (LogRecorder) Record(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[ERROR] audit marshal failed: %+v", err)
+		return
+	}
+	log.Printf("[AUDIT] %s", b)
+}
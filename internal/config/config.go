@@ -0,0 +1,271 @@
+// Package config loads the server configuration: defaults, then the file
+// in CONFIG_FILE (YAML, TOML or JSON), then the environment. Every setting
+// has an environment variable, so deployments without a file keep working.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+
+	"central-auth/internal/policy"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// insecureSecret is the placeholder JWT secret the server used to ship with.
+const insecureSecret = "CHANGE_THIS_SECRET"
+
+// minSecretLen is the HS256 key size.
+const minSecretLen = 32
+
+// Storage backends and session stores
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+
+	SessionStoreRedis  = "redis"
+	SessionStoreMemory = "memory"
+)
+
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	JWT      JWTConfig      `json:"jwt"`
+	Storage  StorageConfig  `json:"storage"`
+	Postgres PostgresConfig `json:"postgres"`
+	SQLite   SQLiteConfig   `json:"sqlite"`
+	Redis    RedisConfig    `json:"redis"`
+	Timeouts Timeouts       `json:"timeouts"`
+	Janitor  JanitorConfig  `json:"janitor"`
+	SMS      SMSConfig      `json:"sms"`
+	WebAuthn WebAuthnConfig `json:"webauthn"`
+	Google   GoogleConfig   `json:"google"`
+	Policies PolicyFiles    `json:"policies"`
+
+	ServiceKeys ServiceKeysConfig `json:"service_keys"`
+}
+
+type ServerConfig struct {
+	Addr string `json:"addr" env:"HTTP_ADDR"`
+	// addresses or CIDRs whose X-Forwarded-For is believed, empty trusts
+	// none and the client address is the peer of the connection
+	TrustedProxies []string  `json:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	TLS            TLSConfig `json:"tls"`
+}
+
+type JWTConfig struct {
+	// signs the tokens of tenants without their own key
+	Secret Secret `json:"secret" env:"JWT_SECRET"`
+}
+
+type ServiceKeysConfig struct {
+	// seals the servicesign keys of API keys in the database, the JWT secret
+	// when empty. Changing it invalidates the signing keys, not the API keys.
+	SealKey Secret `json:"seal_key" env:"SERVICE_KEY_SEAL_KEY"`
+}
+
+// ServiceKeySealSecret is the secret the signing keys of API keys are
+// sealed with.
+func (c *Config) ServiceKeySealSecret() []byte {
+	if c.ServiceKeys.SealKey.Value != "" {
+		return []byte(c.ServiceKeys.SealKey.Value)
+	}
+	return []byte(c.JWT.Secret.Value)
+}
+
+type StorageConfig struct {
+	// postgres or sqlite, sqlite runs as a single binary
+	Backend string `json:"backend" env:"STORAGE"`
+	// redis or memory, memory by default with sqlite
+	SessionStore   string `json:"session_store" env:"SESSION_STORE"`
+	MigrateOnStart bool   `json:"migrate_on_start" env:"MIGRATE_ON_START"`
+}
+
+type GoogleConfig struct {
+	// audience of Google ID tokens, tenants may have their own
+	ClientID string `json:"client_id" env:"GOOGLE_CLIENT_ID"`
+}
+
+// PolicyFiles are the JSON policy files, see LoadPolicies.
+type PolicyFiles struct {
+	Devices        string `json:"devices_file" env:"DEVICE_POLICIES_FILE"`
+	Sessions       string `json:"sessions_file" env:"SESSION_POLICIES_FILE"`
+	TokenLifetimes string `json:"token_lifetimes_file" env:"TOKEN_LIFETIMES_FILE"`
+	ServiceAuth    string `json:"service_auth_file" env:"SERVICE_AUTH_POLICY_FILE"`
+	Tenants        string `json:"tenants_file" env:"TENANTS_FILE"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{Addr: ":8081"},
+		Storage: StorageConfig{
+			Backend:        StoragePostgres,
+			MigrateOnStart: true,
+		},
+		Postgres: PostgresConfig{Host: "localhost", Port: 5432, SSLMode: "disable"},
+		SQLite:   SQLiteConfig{Path: "central-auth.db"},
+		Redis:    RedisConfig{Mode: RedisSingle, Addr: "localhost:6379"},
+		Timeouts: Timeouts{
+			Redis:    policy.Duration(2 * time.Second),
+			Database: policy.Duration(5 * time.Second),
+			SMS:      policy.Duration(10 * time.Second),
+		},
+		Janitor: DefaultJanitorConfig(),
+		SMS:     SMSConfig{Sender: SMSConsole, FilePath: "sms.log"},
+		WebAuthn: WebAuthnConfig{
+			RPID:      "localhost",
+			RPName:    "Central-Auth",
+			RPOrigins: []string{"http://localhost:3000"},
+		},
+	}
+}
+
+// Load reads the configuration file at path, CONFIG_FILE when path is
+// empty, applies the environment, reads file backed secrets and validates
+// the result. Without a file only defaults and the environment apply.
+func Load(path string) (*Config, error) {
+	return load(path, os.LookupEnv)
+}
+
+func load(path string, lookup func(string) (string, bool)) (*Config, error) {
+	if path == "" {
+		path, _ = lookup("CONFIG_FILE")
+	}
+
+	c := Default()
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(c).Elem(), lookup); err != nil {
+		return nil, err
+	}
+	if c.Storage.SessionStore == "" {
+		c.Storage.SessionStore = SessionStoreRedis
+		if c.Storage.Backend == StorageSQLite {
+			c.Storage.SessionStore = SessionStoreMemory
+		}
+	}
+
+	err := eachSecret(reflect.ValueOf(c).Elem(), "", func(key string, s *Secret) error {
+		if err := s.resolve(); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readFile decodes the file by its extension. YAML and TOML go through the
+// JSON decoder so every format accepts the same keys and values.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return fmt.Errorf("unknown config format %q, want .yaml, .toml or .json", ext)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// a misspelt key would silently keep the default
+	dec.DisallowUnknownFields()
+	return dec.Decode(c)
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	check("server.addr", validateAddr(c.Server.Addr))
+	check("server.tls", c.Server.TLS.Validate())
+	check("jwt.secret", validateSecret(c.JWT.Secret))
+	if c.ServiceKeys.SealKey.Value != "" {
+		check("service_keys.seal_key", validateSecret(c.ServiceKeys.SealKey))
+	}
+
+	switch c.Storage.Backend {
+	case StoragePostgres:
+		check("postgres", c.Postgres.Validate())
+	case StorageSQLite:
+		if c.SQLite.Path == "" {
+			check("sqlite.path", errors.New("required"))
+		}
+	default:
+		check("storage.backend", fmt.Errorf("unknown backend %q, want postgres or sqlite", c.Storage.Backend))
+	}
+	switch c.Storage.SessionStore {
+	case SessionStoreRedis:
+		check("redis", c.Redis.Validate())
+	case SessionStoreMemory:
+	default:
+		check("storage.session_store", fmt.Errorf("unknown session store %q, want redis or memory", c.Storage.SessionStore))
+	}
+
+	check("timeouts", c.Timeouts.Validate())
+	_, err := c.Janitor.RetentionPolicy()
+	check("janitor", err)
+	check("sms", c.SMS.Validate())
+	check("webauthn", c.WebAuthn.Validate())
+	return errors.Join(errs...)
+}
+
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// validateSecret refuses missing, placeholder and short JWT secrets.
+func validateSecret(s Secret) error {
+	switch {
+	case s.Value == "":
+		return errors.New("required, set JWT_SECRET or JWT_SECRET_FILE")
+	case s.Value == insecureSecret:
+		return errors.New("still the CHANGE_THIS_SECRET placeholder")
+	case len(s.Value) < minSecretLen:
+		return fmt.Errorf("must be at least %d bytes", minSecretLen)
+	}
+	return nil
+}
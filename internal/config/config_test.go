@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+server:
+  addr: ":9000"
+storage:
+  backend: sqlite
+timeouts:
+  database: 3s
+webauthn:
+  rp_origins: ["https://a.example", "https://b.example"]
+`,
+		"config.toml": `
+[server]
+addr = ":9000"
+[storage]
+backend = "sqlite"
+[timeouts]
+database = "3s"
+[webauthn]
+rp_origins = ["https://a.example", "https://b.example"]
+`,
+	}
+	for name, data := range files {
+		path := writeFile(t, name, data)
+		c, err := load(path, lookupMap(map[string]string{
+			"JWT_SECRET":  testSecret,
+			"SQLITE_PATH": "/tmp/auth.db",
+			"HTTP_ADDR":   ":9001",
+		}))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// the environment wins over the file
+		if c.Server.Addr != ":9001" || c.SQLite.Path != "/tmp/auth.db" {
+			t.Fatalf("%s: env not applied: %+v", name, c)
+		}
+		if c.Storage.SessionStore != SessionStoreMemory || c.Timeouts.Database.Std() != 3*time.Second || c.Timeouts.Redis.Std() != 2*time.Second {
+			t.Fatalf("%s: storage %+v, timeouts %+v", name, c.Storage, c.Timeouts)
+		}
+		if len(c.WebAuthn.RPOrigins) != 2 {
+			t.Fatalf("%s: rp_origins %v", name, c.WebAuthn.RPOrigins)
+		}
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	secret := writeFile(t, "jwt", testSecret+"\n")
+	path := writeFile(t, "config.yaml", "jwt:\n  secret: {file: "+secret+"}\n")
+
+	c, err := load(path, lookupMap(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.JWT.Secret.Value != testSecret {
+		t.Fatalf("secret = %q", c.JWT.Secret.Value)
+	}
+
+	c, err = load("", lookupMap(map[string]string{"JWT_SECRET_FILE": secret, "POSTGRES_PASSWORD": "pw"}))
+	if err != nil || c.JWT.Secret.Value != testSecret || c.Postgres.Password.Value != "pw" {
+		t.Fatalf("JWT_SECRET_FILE: %+v, %v", c, err)
+	}
+
+	_, err = load("", lookupMap(map[string]string{"JWT_SECRET": testSecret, "JWT_SECRET_FILE": secret}))
+	if err == nil {
+		t.Fatal("JWT_SECRET and JWT_SECRET_FILE both accepted")
+	}
+}
+
+func TestLoadRefusesInsecureConfig(t *testing.T) {
+	cases := map[string]map[string]string{
+		"no secret":   {},
+		"placeholder": {"JWT_SECRET": insecureSecret},
+		"short":       {"JWT_SECRET": "too-short"},
+		"webhook":     {"JWT_SECRET": testSecret, "SMS_SENDER": "webhook"},
+		"tls":         {"JWT_SECRET": testSecret, "TLS_CLIENT_CA_FILE": "ca.pem"},
+		"sentinel":    {"JWT_SECRET": testSecret, "REDIS_MODE": "sentinel"},
+	}
+	for name, env := range cases {
+		if _, err := load("", lookupMap(env)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	path := writeFile(t, "config.yaml", "jwt:\n  secret: CHANGE_THIS_SECRET\nserver:\n  adr: \":80\"\n")
+	_, err := load(path, lookupMap(nil))
+	if err == nil || !strings.Contains(err.Error(), "adr") {
+		t.Fatalf("unknown key: %v", err)
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	c := Default()
+	c.JWT.Secret = Secret{Value: testSecret}
+	c.Redis.Password = Secret{File: "/run/secrets/redis", Value: "pw"}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(b)
+	if strings.Contains(data, testSecret) || strings.Contains(data, `"pw"`) || !strings.Contains(data, "/run/secrets/redis") {
+		t.Fatalf("secrets leaked: %s", data)
+	}
+}
@@ -2,41 +2,46 @@ package config
 
 import (
 	"context"
-	"os"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
 
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
 )
 
-var Ctx = context.Background()
+type PostgresConfig struct {
+	Host     string `json:"host" env:"POSTGRES_HOST"`
+	Port     int    `json:"port" env:"POSTGRES_PORT"`
+	User     string `json:"user" env:"POSTGRES_USER"`
+	Password Secret `json:"password" env:"POSTGRES_PASSWORD"`
+	Database string `json:"database" env:"POSTGRES_DB"`
+	SSLMode  string `json:"sslmode" env:"POSTGRES_SSLMODE"`
+}
 
-func NewRedisClient() *redis.Client {
-	addr := os.Getenv("REDIS_ADDR")
-	if addr == "" {
-		// Local
-		addr = "localhost:6379"
+func (c PostgresConfig) Validate() error {
+	switch {
+	case c.Host == "":
+		return errors.New("host is required")
+	case c.Port <= 0 || c.Port > 65535:
+		return fmt.Errorf("invalid port %d", c.Port)
 	}
-
-	return redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+	return nil
 }
 
-func NewPostgresConn() (*pgxpool.Pool, error) {
-	host := os.Getenv("POSTGRES_HOST")
-	port := os.Getenv("POSTGRES_PORT")
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	db := os.Getenv("POSTGRES_DB")
-
-	if host == "" {
-		// Local fallback
-		host = "localhost"
+// DSN is the connection URL, user and password escaped.
+func (c PostgresConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password.Value),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Database,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
 	}
+	return u.String()
+}
 
-	dsn := "postgres://" + user + ":" + password +
-		"@" + host + ":" + port + "/" + db +
-		"?sslmode=disable"
-
-	return pgxpool.New(context.Background(), dsn)
+func NewPostgresConn(cfg PostgresConfig) (*pgxpool.Pool, error) {
+	return pgxpool.New(context.Background(), cfg.DSN())
 }
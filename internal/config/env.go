@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"central-auth/internal/policy"
+)
+
+var (
+	secretType   = reflect.TypeOf(Secret{})
+	durationType = reflect.TypeOf(policy.Duration(0))
+)
+
+// applyEnv overrides the fields tagged env:"NAME" with the variables that
+// are set. Lists are comma separated, durations like "15m". Secrets also
+// take NAME_FILE.
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, f := t.Field(i), v.Field(i)
+		name := field.Tag.Get("env")
+		if name == "" {
+			if f.Kind() == reflect.Struct && f.Type() != secretType {
+				if err := applyEnv(f, lookup); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if f.Type() == secretType {
+			value, hasValue := lookup(name)
+			file, hasFile := lookup(name + "_FILE")
+			switch {
+			case hasValue && hasFile:
+				return fmt.Errorf("set either %s or %s_FILE", name, name)
+			case hasValue:
+				f.Set(reflect.ValueOf(Secret{Value: value}))
+			case hasFile:
+				f.Set(reflect.ValueOf(Secret{File: file}))
+			}
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(f, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, value string) error {
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case reflect.Slice:
+		f.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma separated value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// eachSecret calls fn with every Secret field of v and its config file key.
+func eachSecret(v reflect.Value, prefix string, fn func(key string, s *Secret) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, f := t.Field(i), v.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch {
+		case f.Type() == secretType:
+			if err := fn(key, f.Addr().Interface().(*Secret)); err != nil {
+				return err
+			}
+		case f.Kind() == reflect.Struct:
+			if err := eachSecret(f, key, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
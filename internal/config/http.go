@@ -0,0 +1,68 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig holds the server certificate and ClientCAFile, the PEM bundle
+// calling services' certificates are verified against.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile      string `json:"key_file" env:"TLS_KEY_FILE"`
+	ClientCAFile string `json:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+func (c TLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("cert_file and key_file go together")
+	}
+	if c.ClientCAFile != "" && !c.Enabled() {
+		return errors.New("client_ca_file requires cert_file and key_file")
+	}
+	return nil
+}
+
+// NewTLSConfig loads the certificates. A client certificate stays optional
+// at the handshake so routes taking an X-Service-Key keep working;
+// ServiceAuthMiddleware only trusts verified chains. Nil when TLS is not
+// configured.
+func NewTLSConfig(c TLSConfig) (*tls.Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	if !c.Enabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in client CA file %s", c.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
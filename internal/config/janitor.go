@@ -0,0 +1,37 @@
+package config
+
+import (
+	"central-auth/internal/policy"
+)
+
+// JanitorConfig are the cleanup settings of finished sessions, see
+// policy.RetentionPolicy. A zero HistoryRetention keeps the history forever.
+type JanitorConfig struct {
+	Mode             string          `json:"mode" env:"JANITOR_MODE"`
+	Interval         policy.Duration `json:"interval" env:"JANITOR_INTERVAL"`
+	BatchSize        int             `json:"batch_size" env:"JANITOR_BATCH_SIZE"`
+	Grace            policy.Duration `json:"grace" env:"JANITOR_GRACE"`
+	HistoryRetention policy.Duration `json:"history_retention" env:"SESSION_HISTORY_RETENTION"`
+}
+
+func DefaultJanitorConfig() JanitorConfig {
+	p := policy.DefaultRetentionPolicy()
+	return JanitorConfig{
+		Mode:             p.Mode,
+		Interval:         policy.Duration(p.Interval),
+		BatchSize:        p.BatchSize,
+		Grace:            policy.Duration(p.Grace),
+		HistoryRetention: policy.Duration(p.HistoryRetention),
+	}
+}
+
+func (c JanitorConfig) RetentionPolicy() (policy.RetentionPolicy, error) {
+	p := policy.RetentionPolicy{
+		Mode:             c.Mode,
+		Interval:         c.Interval.Std(),
+		BatchSize:        c.BatchSize,
+		Grace:            c.Grace.Std(),
+		HistoryRetention: c.HistoryRetention.Std(),
+	}
+	return p, p.Validate()
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"central-auth/internal/policy"
+)
+
+// LoadDevicePolicies reads the JSON file at path, falling back to 5 devices
+// / evict oldest when empty.
+func LoadDevicePolicies(path string) (*policy.DevicePolicies, error) {
+	if path == "" {
+		return policy.DefaultDevicePolicies(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := policy.DefaultDevicePolicies()
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadSessionPolicies reads the JSON file at path. Without it sessions only
+// end at the refresh token TTL.
+func LoadSessionPolicies(path string) (*policy.SessionPolicies, error) {
+	if path == "" {
+		return policy.DefaultSessionPolicies(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := policy.DefaultSessionPolicies()
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadTokenLifetimes reads the JSON file at path. Without it every service
+// gets 15m access tokens and 7 day / 30 day (remember me) sessions.
+func LoadTokenLifetimes(path string) (*policy.TokenLifetimes, error) {
+	if path == "" {
+		return policy.DefaultTokenLifetimes(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := policy.DefaultTokenLifetimes()
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadServiceAuthPolicy reads the JSON policy.ServiceAuthPolicy at path.
+// Without it every route takes an X-Service-Key.
+func LoadServiceAuthPolicy(path string) (*policy.ServiceAuthPolicy, error) {
+	if path == "" {
+		return policy.DefaultServiceAuthPolicy(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := policy.DefaultServiceAuthPolicy()
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Policies are the policy files of a Config, loaded and validated.
+type Policies struct {
+	Devices        *policy.DevicePolicies
+	Sessions       *policy.SessionPolicies
+	TokenLifetimes *policy.TokenLifetimes
+	ServiceAuth    *policy.ServiceAuthPolicy
+	Tenants        policy.TenantPolicies
+	// signing keys of the tenants with their own
+	TenantKeys map[string][]byte
+}
+
+// LoadPolicies loads every policy file of c.
+func LoadPolicies(c *Config) (*Policies, error) {
+	var (
+		p   Policies
+		err error
+	)
+	if p.Devices, err = LoadDevicePolicies(c.Policies.Devices); err != nil {
+		return nil, fmt.Errorf("policies.devices_file: %w", err)
+	}
+	if p.Sessions, err = LoadSessionPolicies(c.Policies.Sessions); err != nil {
+		return nil, fmt.Errorf("policies.sessions_file: %w", err)
+	}
+	if p.TokenLifetimes, err = LoadTokenLifetimes(c.Policies.TokenLifetimes); err != nil {
+		return nil, fmt.Errorf("policies.token_lifetimes_file: %w", err)
+	}
+	if p.ServiceAuth, err = LoadServiceAuthPolicy(c.Policies.ServiceAuth); err != nil {
+		return nil, fmt.Errorf("policies.service_auth_file: %w", err)
+	}
+	if p.ServiceAuth.Uses(policy.ServiceAuthClientCert) && c.Server.TLS.ClientCAFile == "" {
+		return nil, errors.New("policies.service_auth_file accepts mtls but server.tls.client_ca_file is not set")
+	}
+	if p.Tenants, err = LoadTenantPolicies(c.Policies.Tenants, p.TokenLifetimes); err != nil {
+		return nil, fmt.Errorf("policies.tenants_file: %w", err)
+	}
+	if p.TenantKeys, err = LoadTenantSigningKeys(p.Tenants); err != nil {
+		return nil, fmt.Errorf("policies.tenants_file: %w", err)
+	}
+	return &p, nil
+}
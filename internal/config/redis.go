@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis modes
+const (
+	RedisSingle   = "single"
+	RedisSentinel = "sentinel"
+	RedisCluster  = "cluster"
+)
+
+// RedisConfig selects the session store deployment:
+//
+//	single   (default) Addr, default localhost:6379
+//	sentinel Addrs lists the sentinels, MasterName the master set
+//	cluster  Addrs lists some of the cluster nodes
+//
+// Username and Password authenticate against the data nodes,
+// SentinelPassword against the sentinels.
+type RedisConfig struct {
+	Mode             string   `json:"mode" env:"REDIS_MODE"`
+	Addr             string   `json:"addr" env:"REDIS_ADDR"`
+	Addrs            []string `json:"addrs" env:"REDIS_ADDRS"`
+	MasterName       string   `json:"master_name" env:"REDIS_MASTER_NAME"`
+	Username         string   `json:"username" env:"REDIS_USERNAME"`
+	Password         Secret   `json:"password" env:"REDIS_PASSWORD"`
+	SentinelPassword Secret   `json:"sentinel_password" env:"REDIS_SENTINEL_PASSWORD"`
+}
+
+func (c RedisConfig) Validate() error {
+	switch c.Mode {
+	case RedisSingle:
+		if c.Addr == "" {
+			return errors.New("addr is required")
+		}
+	case RedisSentinel:
+		if len(c.Addrs) == 0 || c.MasterName == "" {
+			return errors.New("sentinel mode needs addrs and master_name")
+		}
+	case RedisCluster:
+		if len(c.Addrs) == 0 {
+			return errors.New("cluster mode needs addrs")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q, want single, sentinel or cluster", c.Mode)
+	}
+	return nil
+}
+
+// NewRedisClient builds the session store client.
+func NewRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	switch cfg.Mode {
+	case RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword.Value,
+			Username:         cfg.Username,
+			Password:         cfg.Password.Value,
+		}), nil
+	case RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.Addrs,
+			Username: cfg.Username,
+			Password: cfg.Password.Value,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Username: cfg.Username,
+			Password: cfg.Password.Value,
+		}), nil
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Secret is a credential given inline or read from a file. In a config
+// file it is a string or {"file": "/run/secrets/name"}, in the environment
+// NAME or NAME_FILE. Load reads the file, surrounding whitespace is dropped.
+type Secret struct {
+	Value string
+	File  string
+}
+
+func (s *Secret) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err == nil {
+		*s = Secret{Value: value}
+		return nil
+	}
+	var ref struct {
+		File string `json:"file"`
+	}
+	if err := json.Unmarshal(b, &ref); err != nil || ref.File == "" {
+		return errors.New(`secret must be a string or {"file": path}`)
+	}
+	*s = Secret{File: ref.File}
+	return nil
+}
+
+// MarshalJSON never writes the value, configurations are printed by
+// `config check`.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	switch {
+	case s.File != "":
+		return json.Marshal(map[string]string{"file": s.File})
+	case s.Value != "":
+		return json.Marshal("<redacted>")
+	}
+	return json.Marshal("")
+}
+
+func (s Secret) String() string {
+	if s.Value == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+// resolve reads the value of a file backed secret.
+func (s *Secret) resolve() error {
+	if s.File == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.File)
+	if err != nil {
+		return err
+	}
+	s.Value = strings.TrimSpace(string(data))
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"central-auth/internal/sms"
+)
+
+// SMS senders
+const (
+	SMSConsole = "console"
+	SMSFile    = "file"
+	SMSWebhook = "webhook"
+)
+
+type SMSConfig struct {
+	Sender       string `json:"sender" env:"SMS_SENDER"`
+	FilePath     string `json:"file_path" env:"SMS_FILE_PATH"`
+	WebhookURL   string `json:"webhook_url" env:"SMS_WEBHOOK_URL"`
+	WebhookToken Secret `json:"webhook_token" env:"SMS_WEBHOOK_TOKEN"`
+}
+
+func (c SMSConfig) Validate() error {
+	switch c.Sender {
+	case SMSConsole:
+	case SMSFile:
+		if c.FilePath == "" {
+			return errors.New("file sender needs file_path")
+		}
+	case SMSWebhook:
+		if c.WebhookURL == "" {
+			return errors.New("webhook sender needs webhook_url")
+		}
+	default:
+		return fmt.Errorf("unknown sender %q, want console, file or webhook", c.Sender)
+	}
+	return nil
+}
+
+// NewSMSSender picks the SMS backend.
+func NewSMSSender(cfg SMSConfig) (sms.Sender, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("sms: %w", err)
+	}
+
+	switch cfg.Sender {
+	case SMSFile:
+		return sms.NewFileSender(cfg.FilePath)
+	case SMSWebhook:
+		return sms.NewWebhookSender(cfg.WebhookURL, cfg.WebhookToken.Value), nil
+	default:
+		return sms.NewConsoleSender(os.Stdout), nil
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type SQLiteConfig struct {
+	Path string `json:"path" env:"SQLITE_PATH"`
+}
+
+// NewSQLiteConn opens the data file of the single-binary mode.
+func NewSQLiteConn(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	// one writer at a time is all SQLite does anyway, a single connection
+	// also keeps ":memory:" databases shared
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"central-auth/internal/policy"
+)
+
+// minSigningKeyLen is the HS256 key size.
+const minSigningKeyLen = 32
+
+// LoadTenantPolicies reads the JSON object of tenant id to
+// policy.TenantPolicy at path. Without it every tenant uses the global
+// configuration. The token lifetimes are checked against lifetimes, the
+// global ones.
+func LoadTenantPolicies(path string, lifetimes *policy.TokenLifetimes) (policy.TenantPolicies, error) {
+	if path == "" {
+		return policy.TenantPolicies{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := policy.TenantPolicies{}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if err := p.ValidateLifetimes(lifetimes); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadTenantSigningKeys reads the signing_key_file of every tenant that has
+// one. Surrounding whitespace of the files is ignored.
+func LoadTenantSigningKeys(p policy.TenantPolicies) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for id, tp := range p {
+		if tp.SigningKeyFile == "" {
+			continue
+		}
+		data, err := os.ReadFile(tp.SigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", id, err)
+		}
+		key := bytes.TrimSpace(data)
+		if len(key) < minSigningKeyLen {
+			return nil, fmt.Errorf("tenant %s: signing key must be at least %d bytes", id, minSigningKeyLen)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"errors"
+
+	"central-auth/internal/policy"
+)
+
+// Timeouts bound a single call to each backing service. The request
+// deadline still applies when it is shorter.
+type Timeouts struct {
+	Redis    policy.Duration `json:"redis" env:"REDIS_TIMEOUT"`
+	Database policy.Duration `json:"database" env:"DB_TIMEOUT"` // postgres or sqlite
+	SMS      policy.Duration `json:"sms" env:"SMS_TIMEOUT"`
+}
+
+func (t Timeouts) Validate() error {
+	if t.Redis <= 0 || t.Database <= 0 || t.SMS <= 0 {
+		return errors.New("timeouts must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"errors"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+type WebAuthnConfig struct {
+	RPID      string   `json:"rp_id" env:"WEBAUTHN_RP_ID"`
+	RPName    string   `json:"rp_name" env:"WEBAUTHN_RP_NAME"`
+	RPOrigins []string `json:"rp_origins" env:"WEBAUTHN_RP_ORIGINS"`
+}
+
+func (c WebAuthnConfig) Validate() error {
+	if c.RPID == "" || len(c.RPOrigins) == 0 {
+		return errors.New("rp_id and rp_origins are required")
+	}
+	return nil
+}
+
+func NewWebAuthn(cfg WebAuthnConfig) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.RPOrigins,
+	})
+}
@@ -1,8 +1,10 @@
 package domain
 
 type AuthUser struct {
-	UserID     string
-	Provider   string
-	ProviderID string
-	Email      string
+	TenantID    string
+	UserID      string
+	Provider    string
+	ProviderID  string
+	Email       string
+	PhoneNumber *string // E.164, nullable
 }
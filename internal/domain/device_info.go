@@ -4,10 +4,19 @@ import "time"
 
 type LoginDeviceInfo struct {
 	DeviceID   string
-	UserAgent  string
-	IPAddress  string
+	UserAgent  *string // nullable
+	IPAddress  *string // nullable
 	IssuedAt   time.Time
 	ExpiresAt  time.Time
 	LastUsedAt *time.Time
 	Revoked    bool
 }
+
+// SessionInfo is a stored device merged with the live Redis session state.
+type SessionInfo struct {
+	LoginDeviceInfo
+	Active        bool       // refresh session still present in Redis
+	Current       bool       // device of the requesting token
+	LoginAt       *time.Time // score in auth:devices, nil when not in Redis
+	IdleExpiresAt *time.Time // end of the session without a refresh, nil when not active
+}
@@ -0,0 +1,11 @@
+package domain
+
+type OTPChallenge struct {
+	ID       string
+	TenantID string
+	Purpose  string
+	Phone    string
+	UserID   string // empty for primary login
+	CodeHash string
+	Attempts int64
+}
@@ -3,6 +3,7 @@ package domain
 import "time"
 
 type RefreshToken struct {
+	TenantID   string
 	UserID     string
 	DeviceID   string
 	TokenHash  string
@@ -12,4 +13,5 @@ type RefreshToken struct {
 	UserAgent  *string
 	IP         *string
 	Revoked    bool
+	Service    string // calling service at login, selects the session policy
 }
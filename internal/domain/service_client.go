@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// ServiceClient is a backend allowed to call the API. Its name selects
+// policies and is put into the tokens issued through it. Every request it
+// makes acts within its tenant.
+type ServiceClient struct {
+	Name             string
+	TenantID         string
+	AllowedEndpoints []string // "/auth/login", "POST /auth/login", "/auth/*"; empty allows all
+	AllowedIPs       []string // addresses or CIDRs; empty allows all
+	// client certificate subjects / SANs that identify the service:
+	// "cn:shop", "dns:shop.mesh.local", "uri:spiffe://mesh/shop", "email:..."
+	CertIdentities []string
+	Disabled       bool
+	CreatedAt      time.Time
+}
+
+// ServiceClientKey is one API key of a ServiceClient. A service can hold
+// several active keys at once to rotate them without downtime.
+type ServiceClientKey struct {
+	KeyID       string // public part of the key
+	ServiceName string
+	KeyHash     string // sha256 of the secret part
+	// servicesign key of the secret, sealed with the server key; nil for keys
+	// issued before signed requests verified against it
+	SigningKey []byte
+	ExpiresAt  *time.Time
+	Revoked    bool
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
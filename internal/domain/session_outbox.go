@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+// SessionOutboxEvent is a Redis change still to be applied for a session
+// that was revoked in Postgres.
+type SessionOutboxEvent struct {
+	ID        int64
+	TenantID  string
+	UserID    string
+	DeviceID  string
+	Attempts  int
+	CreatedAt time.Time
+	// Revoked is the current state of the device in refresh_tokens. A device
+	// that logged in again after the event was written must not be evicted.
+	Revoked bool
+}
@@ -0,0 +1,5 @@
+package domain
+
+// DefaultTenant owns the users and sessions of service clients registered
+// without a tenant, and everything stored before tenants existed.
+const DefaultTenant = "default"
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+type WebAuthnCredential struct {
+	TenantID        string
+	UserID          string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	SignCount       uint32
+	CloneWarning    bool
+	Transports      []string
+	Flags           uint8 // raw authenticator flags (UP/UV/BE/BS)
+	CreatedAt       time.Time
+	LastUsedAt      *time.Time // nullable
+}
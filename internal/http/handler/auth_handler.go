@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"central-auth/internal/http/middleware"
 	"central-auth/internal/model"
+	"central-auth/internal/policy"
 	"central-auth/internal/service"
 	"central-auth/internal/token"
 
@@ -13,10 +19,12 @@ import (
 
 type AuthHandler struct {
 	authService *service.AuthService
+	// audience of Google ID tokens for tenants without their own
+	googleClientID string
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
-	return &AuthHandler{authService: authService}
+func NewAuthHandler(authService *service.AuthService, googleClientID string) *AuthHandler {
+	return &AuthHandler{authService: authService, googleClientID: googleClientID}
 }
 
 func bearerToken(c *gin.Context) (string, bool) {
@@ -31,6 +39,88 @@ func bearerToken(c *gin.Context) (string, bool) {
 	return parts[1], true
 }
 
+// loginOptions collects the tenant and device policy inputs of a login
+// request. Login methods other than the service's own set Provider.
+func loginOptions(c *gin.Context, f model.DevicePolicyFields) service.LoginOptions {
+	return service.LoginOptions{
+		Tenant:          middleware.TenantID(c),
+		Provider:        policy.ProviderService,
+		Service:         middleware.ServiceName(c),
+		Tier:            f.Tier,
+		ReplaceDeviceID: f.ReplaceDeviceID,
+	}
+}
+
+// writeLogin answers a login attempt: tokens, or 409 when the device policy
+// refused the new device.
+func writeLogin(c *gin.Context, res *service.LoginResult, err error) {
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		var limitErr *service.DeviceLimitError
+		if errors.As(err, &limitErr) {
+			resp := model.DeviceLimitResponse{
+				Error:      "device_limit_reached",
+				Strategy:   limitErr.Strategy,
+				MaxDevices: limitErr.MaxDevices,
+			}
+			if limitErr.Strategy == policy.RequireChoice {
+				resp.Error = "device_choice_required"
+				for _, d := range limitErr.Devices {
+					resp.Devices = append(resp.Devices, model.SessionResponse{
+						DeviceID:   d.DeviceID,
+						UserAgent:  d.UserAgent,
+						IPAddress:  d.IPAddress,
+						IssuedAt:   d.IssuedAt,
+						LastUsedAt: d.LastUsedAt,
+						ExpiresAt:  d.ExpiresAt,
+						Active:     true,
+					})
+				}
+			}
+			c.JSON(http.StatusConflict, resp)
+			return
+		}
+		if errors.Is(err, service.ErrReplaceDeviceNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrProviderNotAllowed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "provider_not_allowed"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.LoginResponse{
+		AccessToken:    res.AccessToken,
+		RefreshToken:   res.RefreshToken,
+		EvictedDevices: res.EvictedDevices,
+	})
+}
+
+func isDeviceLimit(err error) bool {
+	var limitErr *service.DeviceLimitError
+	return errors.As(err, &limitErr)
+}
+
+// writeDependencyError answers 504 when Redis, the database or the SMS
+// gateway timed out and 503 when it could not be reached. It returns false
+// and writes nothing for any other error.
+func writeDependencyError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrDependencyTimeout), errors.Is(err, context.DeadlineExceeded):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "dependency_timeout"})
+	case errors.Is(err, service.ErrDependencyUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "dependency_unavailable"})
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req model.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -42,6 +132,11 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id is required"})
 		return
 	}
+	auth, err := h.authService.AssertedAuthInfo(req.AMR)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_amr", "reason": err.Error()})
+		return
+	}
 
 	userAgent := c.GetHeader("User-Agent")
 	ip := c.ClientIP()
@@ -55,22 +150,17 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		ipPtr = &ip
 	}
 
-	access, refresh, err := h.authService.Login(
+	res, err := h.authService.Login(
+		c.Request.Context(),
 		req.UserID,
 		req.DeviceID,
 		req.RememberMe,
+		auth,
+		loginOptions(c, req.DevicePolicyFields),
 		uaPtr,
 		ipPtr,
 	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, model.LoginResponse{
-		AccessToken:  access,
-		RefreshToken: refresh,
-	})
+	writeLogin(c, res, err)
 }
 
 func (h *AuthHandler) Logout(c *gin.Context) {
@@ -80,7 +170,10 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		return
 	}
 
-	if err := h.authService.Logout(accessToken); err != nil {
+	if err := h.authService.Logout(c.Request.Context(), middleware.TenantID(c), accessToken); err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "logout_failed", "reason": err.Error()})
 		return
 	}
@@ -94,7 +187,10 @@ func (h *AuthHandler) LogoutAll(c *gin.Context) {
 		return
 	}
 
-	if err := h.authService.LogoutAll(accessToken); err != nil {
+	if err := h.authService.LogoutAll(c.Request.Context(), middleware.TenantID(c), accessToken); err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "logout_all_failed", "reason": err.Error()})
 		return
 	}
@@ -109,25 +205,131 @@ func (h *AuthHandler) Verify(c *gin.Context) {
 		return
 	}
 
-	claims, err := token.Parse(tokenStr)
+	// optional step-up requirements
+	var req model.VerifyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.ACR != "" && !token.ValidACR(req.ACR) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown acr"})
+		return
+	}
+
+	// tokens of other tenants fail here like forged ones
+	claims, err := h.authService.VerifyToken(middleware.TenantID(c), tokenStr)
 	if err != nil {
 		c.JSON(401, gin.H{"error": "invalid token"})
 		return
 	}
 
 	// Redis에 refresh token이 살아있는지 확인 (세션 존재 확인)
-	exists, err := h.authService.ExistsSession(
+	idleExpiresAt, exists, err := h.authService.IdleExpiry(
+		c.Request.Context(),
+		claims.Tenant(),
 		claims.UserID,
 		claims.DeviceID,
 	)
+	if err != nil && writeDependencyError(c, err) {
+		return
+	}
 	if err != nil || !exists {
 		c.JSON(401, gin.H{"error": "session expired"})
 		return
 	}
 
+	var maxAge *time.Duration
+	if req.MaxAge != nil {
+		d := time.Duration(*req.MaxAge) * time.Second
+		maxAge = &d
+	}
+	if err := h.authService.CheckAuthLevel(claims, maxAge, req.ACR); err != nil {
+		var stepUp *service.StepUpRequiredError
+		if errors.As(err, &stepUp) {
+			stepUpRequired(c, stepUp)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(200, gin.H{
 		"user_id":   claims.UserID,
 		"device_id": claims.DeviceID,
 		"exp":       claims.ExpiresAt.Time.Unix(),
+		"auth_time": claims.AuthTime,
+		"acr":       claims.ACR,
+		"amr":       claims.AMR,
+		"azp":       claims.AuthorizedParty,
+		"tid":       claims.Tenant(),
+		// absolute end of the session and end without activity
+		"session_exp":     claims.SessionExp,
+		"idle_expires_at": idleExpiresAt.Unix(),
+	})
+}
+
+// stepUpRequired answers like RFC 9470 so callers can trigger re-authentication.
+func stepUpRequired(c *gin.Context, e *service.StepUpRequiredError) {
+	challenge := `Bearer error="insufficient_user_authentication"`
+	body := gin.H{
+		"error":  "step_up_required",
+		"reason": e.Reason,
+		"acr":    e.ACR,
+	}
+	if e.RequiredACR != "" {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, e.RequiredACR)
+		body["required_acr"] = e.RequiredACR
+	}
+	if e.MaxAge != nil {
+		challenge += fmt.Sprintf(`, max_age=%d`, int64(e.MaxAge.Seconds()))
+		body["max_age"] = int64(e.MaxAge.Seconds())
+	}
+	if !e.AuthTime.IsZero() {
+		body["auth_time"] = e.AuthTime.Unix()
+	}
+
+	c.Header("WWW-Authenticate", challenge)
+	c.JSON(http.StatusUnauthorized, body)
+}
+
+// Reauth upgrades the current session (identified by its refresh token) after
+// the calling backend re-verified the user. No new device is created.
+func (h *AuthHandler) Reauth(c *gin.Context) {
+	refreshToken, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		return
+	}
+
+	var req model.ReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	auth, err := h.authService.AssertedAuthInfo(req.AMR)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_amr", "reason": err.Error()})
+		return
+	}
+
+	access, refresh, err := h.authService.Reauthenticate(
+		c.Request.Context(),
+		middleware.TenantID(c),
+		refreshToken,
+		auth,
+	)
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "reauth_failed", "reason": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.LoginResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
 	})
 }
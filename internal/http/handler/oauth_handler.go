@@ -1,10 +1,10 @@
 package handler
 
 import (
+	"central-auth/internal/http/middleware"
 	"central-auth/internal/model"
 	"central-auth/internal/token"
 	"net/http"
-	"os"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,10 +21,15 @@ func (h *AuthHandler) OAuthLogin(c *gin.Context) {
 		return
 	}
 
-	// 1. Check Google Token
+	// 1. Check Google Token, tenants may have their own Google client
+	clientID := h.authService.Tenant(middleware.TenantID(c)).GoogleClientID
+	if clientID == "" {
+		clientID = h.googleClientID
+	}
 	claims, err := token.VerifyGoogleIDToken(
+		c.Request.Context(),
 		req.IdToken,
-		os.Getenv("GOOGLE_CLIENT_ID"),
+		clientID,
 	)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid google token"})
@@ -44,22 +49,16 @@ func (h *AuthHandler) OAuthLogin(c *gin.Context) {
 		ipPtr = &ip
 	}
 
-	access, refresh, err := h.authService.OAuthLogin(
+	res, err := h.authService.OAuthLogin(
+		c.Request.Context(),
 		"google",
 		claims.Subject,
 		claims.Email,
 		req.DeviceID,
 		req.RememberMe,
+		loginOptions(c, req.DevicePolicyFields),
 		uaPtr,
 		ipPtr,
 	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"access_token":  access,
-		"refresh_token": refresh,
-	})
+	writeLogin(c, res, err)
 }
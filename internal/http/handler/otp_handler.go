@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"central-auth/internal/http/middleware"
+	"central-auth/internal/model"
+	"central-auth/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OTPHandler struct {
+	otpService *service.OTPService
+}
+
+func NewOTPHandler(otpService *service.OTPService) *OTPHandler {
+	return &OTPHandler{otpService: otpService}
+}
+
+func (h *OTPHandler) SendSMS(c *gin.Context) {
+	var req model.SMSSendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// only needed for enroll
+	accessToken, _ := bearerToken(c)
+
+	otpID, destination, err := h.otpService.SendSMS(c.Request.Context(), middleware.TenantID(c), req.Purpose, req.PhoneNumber, req.UserID, accessToken)
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrOTPRateLimited):
+			status = http.StatusTooManyRequests
+		case errors.Is(err, service.ErrInvalidPhone),
+			errors.Is(err, service.ErrOTPNoPhone),
+			errors.Is(err, service.ErrOTPUnsupportedUsage):
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrProviderNotAllowed):
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": "otp_send_failed", "reason": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.SMSSendResponse{
+		OTPID:       otpID,
+		Destination: destination,
+	})
+}
+
+func (h *OTPHandler) VerifySMS(c *gin.Context) {
+	var req model.SMSVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userAgent := c.GetHeader("User-Agent")
+	ip := c.ClientIP()
+
+	var uaPtr *string
+	var ipPtr *string
+	if userAgent != "" {
+		uaPtr = &userAgent
+	}
+	if ip != "" {
+		ipPtr = &ip
+	}
+
+	res, err := h.otpService.VerifySMS(
+		c.Request.Context(),
+		req.OTPID,
+		req.Code,
+		req.DeviceID,
+		req.RememberMe,
+		loginOptions(c, req.DevicePolicyFields),
+		uaPtr,
+		ipPtr,
+	)
+	// device limits and refused providers are answered by writeLogin
+	if err != nil && !isDeviceLimit(err) && !errors.Is(err, service.ErrProviderNotAllowed) {
+		if writeDependencyError(c, err) {
+			return
+		}
+		status := http.StatusUnauthorized
+		switch {
+		case errors.Is(err, service.ErrOTPTooManyAttempts):
+			status = http.StatusTooManyRequests
+		case errors.Is(err, service.ErrOTPDeviceRequired):
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": "otp_verify_failed", "reason": err.Error()})
+		return
+	}
+
+	// enroll: nothing to issue
+	if err == nil && res == nil {
+		c.JSON(http.StatusOK, gin.H{"result": "phone_verified"})
+		return
+	}
+
+	writeLogin(c, res, err)
+}
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
+	"central-auth/internal/http/middleware"
+	"central-auth/internal/service"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -22,7 +26,17 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 
 	refreshToken := parts[1]
 
-	accessToken, err := h.authService.Refresh(refreshToken)
+	accessToken, err := h.authService.Refresh(c.Request.Context(), middleware.TenantID(c), refreshToken)
+	if err != nil && writeDependencyError(c, err) {
+		return
+	}
+	if errors.Is(err, service.ErrReauthRequired) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":  "reauth_required",
+			"reason": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":   "refresh_failed",
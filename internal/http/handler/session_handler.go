@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"central-auth/internal/http/middleware"
+	"central-auth/internal/model"
+	"central-auth/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSessions: GET /auth/sessions?limit=20&offset=0
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	accessToken, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(service.SessionsDefaultLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	if limit > service.SessionsMaxLimit {
+		limit = service.SessionsMaxLimit
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	sessions, activeCount, hasMore, err := h.authService.ListSessions(c.Request.Context(), middleware.TenantID(c), accessToken, limit, offset)
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "list_sessions_failed", "reason": err.Error()})
+		return
+	}
+
+	resp := model.SessionListResponse{
+		Sessions:      make([]model.SessionResponse, 0, len(sessions)),
+		ActiveDevices: activeCount,
+		Limit:         limit,
+		Offset:        offset,
+		HasMore:       hasMore,
+	}
+	for _, s := range sessions {
+		resp.Sessions = append(resp.Sessions, model.SessionResponse{
+			DeviceID:      s.DeviceID,
+			UserAgent:     s.UserAgent,
+			IPAddress:     s.IPAddress,
+			IssuedAt:      s.IssuedAt,
+			LastUsedAt:    s.LastUsedAt,
+			ExpiresAt:     s.ExpiresAt,
+			IdleExpiresAt: s.IdleExpiresAt,
+			LastLoginAt:   s.LoginAt,
+			Revoked:       s.Revoked,
+			Active:        s.Active,
+			Current:       s.Current,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeSession: DELETE /auth/sessions/:device_id
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	accessToken, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		return
+	}
+
+	deviceID := c.Param("device_id")
+	if deviceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id is required"})
+		return
+	}
+
+	err := h.authService.RevokeSession(c.Request.Context(), middleware.TenantID(c), accessToken, deviceID, c.ClientIP())
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"result": "session_revoked", "device_id": deviceID})
+	case errors.Is(err, service.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "session_not_found"})
+	case errors.Is(err, service.ErrCannotRevokeCurrent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revoke_failed", "reason": err.Error()})
+	case writeDependencyError(c, err):
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "revoke_failed", "reason": err.Error()})
+	}
+}
+
+// LogoutOthers: POST /auth/logout-others, keeps only the calling device
+func (h *AuthHandler) LogoutOthers(c *gin.Context) {
+	accessToken, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		return
+	}
+
+	count, err := h.authService.RevokeOtherSessions(c.Request.Context(), middleware.TenantID(c), accessToken, c.ClientIP())
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "logout_others_failed", "reason": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": "logged_out_others", "revoked": count})
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"central-auth/internal/http/middleware"
+	"central-auth/internal/model"
+	"central-auth/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnHandler struct {
+	webauthnService *service.WebAuthnService
+}
+
+func NewWebAuthnHandler(webauthnService *service.WebAuthnService) *WebAuthnHandler {
+	return &WebAuthnHandler{webauthnService: webauthnService}
+}
+
+func (h *WebAuthnHandler) RegisterBegin(c *gin.Context) {
+	accessToken, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		return
+	}
+
+	sessionID, options, err := h.webauthnService.BeginRegistration(c.Request.Context(), middleware.TenantID(c), accessToken)
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "webauthn_register_failed", "reason": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.WebAuthnBeginResponse{
+		SessionID: sessionID,
+		Options:   options,
+	})
+}
+
+func (h *WebAuthnHandler) RegisterFinish(c *gin.Context) {
+	accessToken, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		return
+	}
+
+	var req model.WebAuthnRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.webauthnService.FinishRegistration(c.Request.Context(), middleware.TenantID(c), accessToken, req.SessionID, req.Credential); err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "webauthn_register_failed", "reason": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": "registered"})
+}
+
+func (h *WebAuthnHandler) LoginBegin(c *gin.Context) {
+	var req model.WebAuthnLoginBeginRequest
+	// empty body is allowed (passwordless)
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	sessionID, options, err := h.webauthnService.BeginLogin(c.Request.Context(), middleware.TenantID(c), req.UserID)
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrWebAuthnNoCredentials) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": "webauthn_login_failed", "reason": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.WebAuthnBeginResponse{
+		SessionID: sessionID,
+		Options:   options,
+	})
+}
+
+func (h *WebAuthnHandler) LoginFinish(c *gin.Context) {
+	var req model.WebAuthnLoginFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userAgent := c.GetHeader("User-Agent")
+	ip := c.ClientIP()
+
+	var uaPtr *string
+	var ipPtr *string
+	if userAgent != "" {
+		uaPtr = &userAgent
+	}
+	if ip != "" {
+		ipPtr = &ip
+	}
+
+	res, err := h.webauthnService.FinishLogin(
+		c.Request.Context(),
+		req.SessionID,
+		req.Credential,
+		req.DeviceID,
+		req.RememberMe,
+		loginOptions(c, req.DevicePolicyFields),
+		uaPtr,
+		ipPtr,
+	)
+	if err != nil && !isDeviceLimit(err) && !errors.Is(err, service.ErrProviderNotAllowed) {
+		if writeDependencyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "webauthn_login_failed", "reason": err.Error()})
+		return
+	}
+	writeLogin(c, res, err)
+}
+
+// ReauthFinish upgrades the session of the Bearer refresh token with a passkey
+// assertion started by LoginBegin with the session's user_id.
+func (h *WebAuthnHandler) ReauthFinish(c *gin.Context) {
+	refreshToken, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid Authorization header"})
+		return
+	}
+
+	var req model.WebAuthnReauthFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	access, refresh, err := h.webauthnService.FinishReauth(c.Request.Context(), middleware.TenantID(c), refreshToken, req.SessionID, req.Credential)
+	if err != nil {
+		if writeDependencyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "reauth_failed", "reason": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.LoginResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+	})
+}
@@ -1,29 +1,156 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net/http"
-	"os"
+	"slices"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+	"central-auth/internal/service"
+	"central-auth/pkg/servicesign"
 
 	"github.com/gin-gonic/gin"
 )
 
-func ServiceAuthMiddleware() gin.HandlerFunc {
+// ServiceAuthMiddleware authenticates the calling backend and checks that
+// its service client may call the route from the client address. authPolicy
+// decides per route which of a verified TLS client certificate, a signed
+// request (servicesign) and an X-Service-Key are accepted, tried in that
+// order.
+func ServiceAuthMiddleware(clients *service.ServiceClientService, authPolicy *policy.ServiceAuthPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		path := routePath(c)
+		accepted := authPolicy.Resolve(c.Request.Method, path)
 		serviceKey := c.GetHeader("X-Service-Key")
-		if serviceKey == "" {
+
+		var (
+			client *domain.ServiceClient
+			err    error
+		)
+		switch {
+		case slices.Contains(accepted, policy.ServiceAuthClientCert) && hasVerifiedCert(c.Request):
+			cert := c.Request.TLS.VerifiedChains[0][0]
+			client, err = clients.AuthenticateCertificate(c.Request.Context(), cert, c.ClientIP(), c.Request.Method, path)
+		case slices.Contains(accepted, policy.ServiceAuthSignature) && c.GetHeader(servicesign.HeaderSignature) != "":
+			var req *service.SignedRequest
+			if req, err = signedRequest(c); err != nil {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large to sign"})
+				return
+			}
+			client, err = clients.AuthenticateSignature(c.Request.Context(), req, authPolicy.MaxClockSkew.Std(), c.ClientIP(), c.Request.Method, path)
+		case slices.Contains(accepted, policy.ServiceAuthAPIKey) && serviceKey != "":
+			client, err = clients.Authenticate(c.Request.Context(), serviceKey, c.ClientIP(), c.Request.Method, path)
+		default:
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "missing service key",
+				"error":    "missing service credentials",
+				"accepted": accepted,
 			})
 			return
 		}
-
-		if serviceKey != os.Getenv("SERVICE_API_KEY") {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid service key",
-			})
+		if err != nil {
+			abortServiceAuth(c, err)
 			return
 		}
 
+		c.Set(ServiceNameKey, client.Name)
+		c.Set(ServiceClientKey, client)
+		c.Set(TenantIDKey, client.TenantID)
+
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// maxSignedBody bounds what is buffered to hash the body of a signed request.
+const maxSignedBody = 1 << 20
+
+// signedRequest collects the signature headers and hashes the body, which is
+// put back for the handler.
+func signedRequest(c *gin.Context) (*service.SignedRequest, error) {
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBody))
+		if err != nil {
+			return nil, err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return &service.SignedRequest{
+		KeyID:     c.GetHeader(servicesign.HeaderKeyID),
+		Timestamp: c.GetHeader(servicesign.HeaderTimestamp),
+		Nonce:     c.GetHeader(servicesign.HeaderNonce),
+		Signature: c.GetHeader(servicesign.HeaderSignature),
+		Method:    c.Request.Method,
+		URI:       c.Request.URL.RequestURI(),
+		BodyHash:  servicesign.BodyHash(body),
+	}, nil
+}
+
+// hasVerifiedCert reports whether the TLS handshake verified a client
+// certificate against the client CA bundle.
+func hasVerifiedCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0
+}
+
+// routePath is the matched route pattern (/auth/sessions/:device_id), the
+// raw path when no route matched.
+func routePath(c *gin.Context) string {
+	if p := c.FullPath(); p != "" {
+		return p
+	}
+	return c.Request.URL.Path
+}
+
+func abortServiceAuth(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidServiceKey):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid service key"})
+	case errors.Is(err, service.ErrInvalidServiceCertificate):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid service certificate"})
+	case errors.Is(err, service.ErrInvalidServiceSignature):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid service signature"})
+	case errors.Is(err, service.ErrReplayedServiceRequest):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "replayed service request"})
+	case errors.Is(err, service.ErrServiceIPNotAllowed),
+		errors.Is(err, service.ErrServiceEndpointNotAllowed):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "service_not_allowed", "reason": err.Error()})
+	case errors.Is(err, service.ErrDependencyTimeout), errors.Is(err, context.DeadlineExceeded):
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "dependency_timeout"})
+	case errors.Is(err, service.ErrDependencyUnavailable):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "dependency_unavailable"})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "service_auth_failed"})
+	}
+}
+
+const (
+	ServiceNameKey   = "service_name"
+	ServiceClientKey = "service_client"
+	TenantIDKey      = "tenant_id"
+)
+
+// ServiceName returns the calling service set by ServiceAuthMiddleware.
+func ServiceName(c *gin.Context) string {
+	return c.GetString(ServiceNameKey)
+}
+
+// TenantID returns the tenant of the calling service, the default tenant
+// outside ServiceAuthMiddleware.
+func TenantID(c *gin.Context) string {
+	if id := c.GetString(TenantIDKey); id != "" {
+		return id
+	}
+	return domain.DefaultTenant
+}
+
+// ServiceClient returns the authenticated calling service, nil outside
+// ServiceAuthMiddleware.
+func ServiceClient(c *gin.Context) *domain.ServiceClient {
+	v, _ := c.Get(ServiceClientKey)
+	client, _ := v.(*domain.ServiceClient)
+	return client
+}
@@ -0,0 +1,30 @@
+// Package ids abstracts how user, session and challenge ids are generated.
+package ids
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+type Generator interface {
+	NewID() string
+}
+
+// UUID generates random (version 4) UUIDs.
+type UUID struct{}
+
+func (UUID) NewID() string {
+	return uuid.NewString()
+}
+
+// Sequence generates prefix-1, prefix-2, ... for tests.
+type Sequence struct {
+	Prefix string
+	n      atomic.Int64
+}
+
+func (s *Sequence) NewID() string {
+	return fmt.Sprintf("%s-%d", s.Prefix, s.n.Add(1))
+}
@@ -0,0 +1,20 @@
+-- IF NOT EXISTS: databases set up from the old scripts/schema.sql already
+-- have some of these objects
+CREATE TABLE IF NOT EXISTS auth_users (
+    user_id VARCHAR(64) PRIMARY KEY,
+
+    provider VARCHAR(32) NOT NULL,
+    provider_user_id VARCHAR(255) NOT NULL,
+    email VARCHAR(320) NOT NULL DEFAULT '',
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT uq_auth_users_provider UNIQUE (provider, provider_user_id)
+);
+
+ALTER TABLE auth_users
+ADD COLUMN IF NOT EXISTS phone_number VARCHAR(20) NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_auth_users_phone
+ON auth_users(phone_number)
+WHERE phone_number IS NOT NULL;
@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id BIGSERIAL PRIMARY KEY,
+
+    user_id VARCHAR(64) NOT NULL,
+    device_id VARCHAR(128) NOT NULL,
+    token_hash TEXT NOT NULL,
+
+    issued_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ NULL,
+
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+
+    user_agent TEXT NULL,
+    ip_address VARCHAR(64) NULL,
+
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+
+    CONSTRAINT uq_refresh_tokens_user_device UNIQUE (user_id, device_id)
+);
+
+ALTER TABLE refresh_tokens
+ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ NULL;
+
+ALTER TABLE refresh_tokens
+ADD COLUMN IF NOT EXISTS service VARCHAR(64) NOT NULL DEFAULT 'default';
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user
+ON refresh_tokens(user_id);
+
+-- index predicates must be immutable, so expiry is a key column instead of
+-- an expires_at > NOW() condition
+DROP INDEX IF EXISTS idx_refresh_tokens_active;
+CREATE INDEX idx_refresh_tokens_active
+ON refresh_tokens(user_id, expires_at)
+WHERE revoked = false;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_revoked
+ON refresh_tokens(revoked_at)
+WHERE revoked = true;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires
+ON refresh_tokens(expires_at);
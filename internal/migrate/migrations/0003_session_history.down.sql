@@ -0,0 +1,2 @@
+-- drops the monthly partitions too
+DROP TABLE IF EXISTS session_history;
@@ -0,0 +1,33 @@
+-- finished sessions: replaced on re-login or archived by the janitor.
+-- Partitioned by month on ended_at, the janitor creates upcoming partitions
+-- and drops the ones past SESSION_HISTORY_RETENTION.
+CREATE TABLE IF NOT EXISTS session_history (
+    id BIGSERIAL,
+
+    user_id VARCHAR(64) NOT NULL,
+    device_id VARCHAR(128) NOT NULL,
+    token_hash TEXT NOT NULL,
+
+    issued_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ NULL,
+
+    revoked BOOLEAN NOT NULL,
+
+    user_agent TEXT NULL,
+    ip_address VARCHAR(64) NULL,
+
+    service VARCHAR(64) NOT NULL DEFAULT 'default',
+
+    ended_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    end_reason VARCHAR(16) NOT NULL, -- replaced | revoked | expired
+
+    PRIMARY KEY (id, ended_at)
+) PARTITION BY RANGE (ended_at);
+
+-- catches rows when the janitor has not created the month yet
+CREATE TABLE IF NOT EXISTS session_history_default
+PARTITION OF session_history DEFAULT;
+
+CREATE INDEX IF NOT EXISTS idx_session_history_user_device
+ON session_history(user_id, device_id, ended_at DESC);
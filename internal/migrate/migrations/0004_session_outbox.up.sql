@@ -0,0 +1,23 @@
+-- Redis evictions of revoked sessions, written in the same statement as the
+-- refresh_tokens update and applied by the outbox worker
+CREATE TABLE IF NOT EXISTS session_outbox (
+    id BIGSERIAL PRIMARY KEY,
+
+    user_id VARCHAR(64) NOT NULL,
+    device_id VARCHAR(128) NOT NULL,
+
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_outbox_pending
+ON session_outbox(next_attempt_at)
+WHERE processed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_session_outbox_user_device
+ON session_outbox(user_id, device_id)
+WHERE processed_at IS NULL;
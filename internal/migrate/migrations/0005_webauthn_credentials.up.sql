@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id BIGSERIAL PRIMARY KEY,
+
+    user_id VARCHAR(64) NOT NULL,
+    credential_id BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL DEFAULT 'none',
+    aaguid BYTEA NULL,
+
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    clone_warning BOOLEAN NOT NULL DEFAULT FALSE,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    flags SMALLINT NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NULL,
+
+    CONSTRAINT uq_webauthn_credentials_id UNIQUE (credential_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user
+ON webauthn_credentials(user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS service_client_keys;
+DROP TABLE IF EXISTS service_clients;
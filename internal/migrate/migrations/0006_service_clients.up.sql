@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS service_clients (
+    name VARCHAR(64) PRIMARY KEY,
+
+    allowed_endpoints TEXT[] NOT NULL DEFAULT '{}',
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS service_client_keys (
+    key_id VARCHAR(64) PRIMARY KEY,
+
+    service_name VARCHAR(64) NOT NULL REFERENCES service_clients(name) ON DELETE CASCADE,
+    key_hash VARCHAR(64) NOT NULL,
+
+    expires_at TIMESTAMPTZ NULL,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    revoked_at TIMESTAMPTZ NULL,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_client_keys_service
+ON service_client_keys(service_name);
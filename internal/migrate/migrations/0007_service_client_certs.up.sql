@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS service_client_certs (
+    identity VARCHAR(255) PRIMARY KEY,
+
+    service_name VARCHAR(64) NOT NULL REFERENCES service_clients(name) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_client_certs_service
+ON service_client_certs(service_name);
@@ -0,0 +1,41 @@
+-- fails when two tenants hold the same user id, provider id, phone number
+-- or user/device pair; such rows have to be removed first
+ALTER TABLE service_clients DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS idx_webauthn_credentials_user;
+ALTER TABLE webauthn_credentials DROP COLUMN IF EXISTS tenant_id;
+CREATE INDEX idx_webauthn_credentials_user
+ON webauthn_credentials(user_id);
+
+DROP INDEX IF EXISTS idx_session_outbox_user_device;
+ALTER TABLE session_outbox DROP COLUMN IF EXISTS tenant_id;
+CREATE INDEX idx_session_outbox_user_device
+ON session_outbox(user_id, device_id)
+WHERE processed_at IS NULL;
+
+DROP INDEX IF EXISTS idx_session_history_user_device;
+ALTER TABLE session_history DROP COLUMN IF EXISTS tenant_id;
+CREATE INDEX idx_session_history_user_device
+ON session_history(user_id, device_id, ended_at DESC);
+
+DROP INDEX IF EXISTS idx_refresh_tokens_active;
+DROP INDEX IF EXISTS idx_refresh_tokens_user;
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS uq_refresh_tokens_user_device;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE refresh_tokens
+ADD CONSTRAINT uq_refresh_tokens_user_device UNIQUE (user_id, device_id);
+CREATE INDEX idx_refresh_tokens_user
+ON refresh_tokens(user_id);
+CREATE INDEX idx_refresh_tokens_active
+ON refresh_tokens(user_id, expires_at)
+WHERE revoked = false;
+
+DROP INDEX IF EXISTS uq_auth_users_phone;
+ALTER TABLE auth_users DROP CONSTRAINT IF EXISTS uq_auth_users_provider;
+ALTER TABLE auth_users DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE auth_users ADD PRIMARY KEY (user_id);
+ALTER TABLE auth_users
+ADD CONSTRAINT uq_auth_users_provider UNIQUE (provider, provider_user_id);
+CREATE UNIQUE INDEX uq_auth_users_phone
+ON auth_users(phone_number)
+WHERE phone_number IS NOT NULL;
@@ -0,0 +1,58 @@
+-- every user, session and service client belongs to a tenant; rows written
+-- before tenants existed belong to 'default'
+ALTER TABLE auth_users
+ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+
+ALTER TABLE auth_users DROP CONSTRAINT IF EXISTS auth_users_pkey;
+ALTER TABLE auth_users ADD PRIMARY KEY (tenant_id, user_id);
+
+ALTER TABLE auth_users DROP CONSTRAINT IF EXISTS uq_auth_users_provider;
+ALTER TABLE auth_users
+ADD CONSTRAINT uq_auth_users_provider UNIQUE (tenant_id, provider, provider_user_id);
+
+DROP INDEX IF EXISTS uq_auth_users_phone;
+CREATE UNIQUE INDEX uq_auth_users_phone
+ON auth_users(tenant_id, phone_number)
+WHERE phone_number IS NOT NULL;
+
+-- user ids asserted by calling services are only unique within a tenant
+ALTER TABLE refresh_tokens
+ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS uq_refresh_tokens_user_device;
+ALTER TABLE refresh_tokens
+ADD CONSTRAINT uq_refresh_tokens_user_device UNIQUE (tenant_id, user_id, device_id);
+
+DROP INDEX IF EXISTS idx_refresh_tokens_user;
+CREATE INDEX idx_refresh_tokens_user
+ON refresh_tokens(tenant_id, user_id);
+
+DROP INDEX IF EXISTS idx_refresh_tokens_active;
+CREATE INDEX idx_refresh_tokens_active
+ON refresh_tokens(tenant_id, user_id, expires_at)
+WHERE revoked = false;
+
+ALTER TABLE session_history
+ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+
+DROP INDEX IF EXISTS idx_session_history_user_device;
+CREATE INDEX idx_session_history_user_device
+ON session_history(tenant_id, user_id, device_id, ended_at DESC);
+
+ALTER TABLE session_outbox
+ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+
+DROP INDEX IF EXISTS idx_session_outbox_user_device;
+CREATE INDEX idx_session_outbox_user_device
+ON session_outbox(tenant_id, user_id, device_id)
+WHERE processed_at IS NULL;
+
+ALTER TABLE webauthn_credentials
+ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+
+DROP INDEX IF EXISTS idx_webauthn_credentials_user;
+CREATE INDEX idx_webauthn_credentials_user
+ON webauthn_credentials(tenant_id, user_id);
+
+ALTER TABLE service_clients
+ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
@@ -0,0 +1 @@
+ALTER TABLE service_client_keys DROP COLUMN IF EXISTS signing_key;
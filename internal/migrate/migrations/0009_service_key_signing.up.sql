@@ -0,0 +1,4 @@
+-- servicesign key of each API key, sealed with the server key. Keys issued
+-- before have none and can not sign requests until they are reissued.
+ALTER TABLE service_client_keys
+ADD COLUMN IF NOT EXISTS signing_key BYTEA NULL;
@@ -0,0 +1 @@
+UPDATE auth_users SET provider = 'phone' WHERE provider = 'sms';
@@ -0,0 +1,3 @@
+-- users created by SMS login were stored with provider 'phone', the login
+-- method and its policies are named 'sms'
+UPDATE auth_users SET provider = 'sms' WHERE provider = 'phone';
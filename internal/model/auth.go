@@ -1,9 +1,20 @@
 package model
 
+// DevicePolicyFields are accepted by every login request.
+type DevicePolicyFields struct {
+	// user tier asserted by the calling backend, selects the device policy
+	Tier string `json:"tier"`
+	// device to log out when the policy asks the user to choose
+	ReplaceDeviceID string `json:"replace_device_id"`
+}
+
 type LoginRequest struct {
 	UserID string `json:"user_id" binding:"required"`
 	DeviceID string `json:"device_id" binding:"required"`
 	RememberMe bool `json:"remember_me"`
+	// methods the calling backend used to authenticate the user (pwd, otp, ...)
+	AMR []string `json:"amr"`
+	DevicePolicyFields
 }
 
 type OAuthLoginRequest struct {
@@ -11,9 +22,28 @@ type OAuthLoginRequest struct {
 	IdToken    string `json:"id_token" binding:"required"`
 	DeviceID   string `json:"device_id" binding:"required"`
 	RememberMe bool   `json:"remember_me"`
+	DevicePolicyFields
 }
 
 type LoginResponse struct {
 	AccessToken string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
-}
\ No newline at end of file
+	EvictedDevices []string `json:"evicted_devices,omitempty"`
+}
+
+type DeviceLimitResponse struct {
+	Error string `json:"error"`
+	Strategy string `json:"strategy"`
+	MaxDevices int64 `json:"max_devices"`
+	Devices []SessionResponse `json:"devices,omitempty"`
+}
+
+type VerifyRequest struct {
+	// seconds since auth_time the operation accepts
+	MaxAge *int64 `json:"max_age"`
+	ACR string `json:"acr"`
+}
+
+type ReauthRequest struct {
+	AMR []string `json:"amr" binding:"required"`
+}
@@ -0,0 +1,20 @@
+package model
+
+type SMSSendRequest struct {
+	Purpose     string `json:"purpose" binding:"required"` // login, mfa, enroll
+	PhoneNumber string `json:"phone_number"`
+	UserID      string `json:"user_id"`
+}
+
+type SMSSendResponse struct {
+	OTPID       string `json:"otp_id"`
+	Destination string `json:"destination"`
+}
+
+type SMSVerifyRequest struct {
+	OTPID      string `json:"otp_id" binding:"required"`
+	Code       string `json:"code" binding:"required"`
+	DeviceID   string `json:"device_id"`
+	RememberMe bool   `json:"remember_me"`
+	DevicePolicyFields
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type SessionResponse struct {
+	DeviceID   string     `json:"device_id"`
+	UserAgent  *string    `json:"user_agent"`
+	IPAddress  *string    `json:"ip_address"`
+	IssuedAt   time.Time  `json:"issued_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	// end of the session if it is not refreshed (sliding idle timeout)
+	IdleExpiresAt *time.Time `json:"idle_expires_at"`
+	LastLoginAt   *time.Time `json:"last_login_at"`
+	Revoked       bool       `json:"revoked"`
+	Active        bool       `json:"active"`
+	Current       bool       `json:"current"`
+}
+
+type SessionListResponse struct {
+	Sessions      []SessionResponse `json:"sessions"`
+	ActiveDevices int               `json:"active_devices"`
+	Limit         int               `json:"limit"`
+	Offset        int               `json:"offset"`
+	HasMore       bool              `json:"has_more"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import "encoding/json"
+
+type WebAuthnRegisterFinishRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+type WebAuthnLoginBeginRequest struct {
+	// empty: passwordless passkey login, set: passkey as second factor
+	UserID string `json:"user_id"`
+}
+
+type WebAuthnLoginFinishRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+	DeviceID   string          `json:"device_id" binding:"required"`
+	RememberMe bool            `json:"remember_me"`
+	DevicePolicyFields
+}
+
+type WebAuthnReauthFinishRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+type WebAuthnBeginResponse struct {
+	SessionID string `json:"session_id"`
+	Options   any    `json:"options"`
+}
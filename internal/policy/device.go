@@ -0,0 +1,92 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// What happens when a new device logs in and the user is at the limit.
+const (
+	EvictOldest   = "evict_oldest"   // drop the device that logged in first
+	EvictLRU      = "evict_lru"      // drop the device that refreshed least recently
+	Reject        = "reject"         // refuse the new login
+	RequireChoice = "require_choice" // refuse and let the user pick a device to replace
+)
+
+const DefaultMaxDevices = 5
+
+type DevicePolicy struct {
+	MaxDevices int64  `json:"max_devices"`
+	Strategy   string `json:"strategy"`
+}
+
+func (p DevicePolicy) Validate() error {
+	if p.MaxDevices <= 0 {
+		return errors.New("max_devices must be positive")
+	}
+	switch p.Strategy {
+	case EvictOldest, EvictLRU, Reject, RequireChoice:
+		return nil
+	}
+	return fmt.Errorf("unknown device strategy %q", p.Strategy)
+}
+
+// ServiceDevicePolicy is the policy of one calling service, optionally
+// refined per user tier.
+type ServiceDevicePolicy struct {
+	DevicePolicy
+	Tiers map[string]DevicePolicy `json:"tiers"`
+}
+
+// DevicePolicies resolves the policy for a login, most specific first:
+// service+tier, tier, service, default.
+type DevicePolicies struct {
+	Default  DevicePolicy                   `json:"default"`
+	Tiers    map[string]DevicePolicy        `json:"tiers"`
+	Services map[string]ServiceDevicePolicy `json:"services"`
+}
+
+func DefaultDevicePolicies() *DevicePolicies {
+	return &DevicePolicies{
+		Default: DevicePolicy{MaxDevices: DefaultMaxDevices, Strategy: EvictOldest},
+	}
+}
+
+func (p *DevicePolicies) Resolve(service, tier string) DevicePolicy {
+	if svc, ok := p.Services[service]; ok {
+		if tp, ok := svc.Tiers[tier]; ok && tier != "" {
+			return tp
+		}
+	}
+	if tp, ok := p.Tiers[tier]; ok && tier != "" {
+		return tp
+	}
+	if svc, ok := p.Services[service]; ok && svc.Strategy != "" {
+		return svc.DevicePolicy
+	}
+	return p.Default
+}
+
+func (p *DevicePolicies) Validate() error {
+	if err := p.Default.Validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for name, tp := range p.Tiers {
+		if err := tp.Validate(); err != nil {
+			return fmt.Errorf("tier %s: %w", name, err)
+		}
+	}
+	for name, svc := range p.Services {
+		if svc.Strategy != "" || svc.MaxDevices != 0 {
+			if err := svc.DevicePolicy.Validate(); err != nil {
+				return fmt.Errorf("service %s: %w", name, err)
+			}
+		}
+		for tier, tp := range svc.Tiers {
+			if err := tp.Validate(); err != nil {
+				return fmt.Errorf("service %s tier %s: %w", name, tier, err)
+			}
+		}
+	}
+	return nil
+}
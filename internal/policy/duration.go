@@ -0,0 +1,38 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Duration reads "15m" / "720h" strings or plain seconds from JSON.
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(val) * time.Second)
+		return nil
+	case string:
+		parsed, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+	return errors.New("invalid duration")
+}
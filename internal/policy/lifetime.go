@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultAccessTTL     = time.Minute * 15
+	DefaultRefreshTTL    = time.Hour * 24 * 7
+	DefaultRememberMeTTL = time.Hour * 24 * 30
+)
+
+// TokenLifetime sets the token TTLs. In an override zero and nil keep the
+// inherited value.
+type TokenLifetime struct {
+	AccessTTL     Duration `json:"access_ttl"`
+	RefreshTTL    Duration `json:"refresh_ttl"`     // without remember me
+	RememberMeTTL Duration `json:"remember_me_ttl"` // with remember me
+	// false ignores remember me, every session gets refresh_ttl
+	RememberMe *bool `json:"remember_me"`
+}
+
+// Override returns l with the fields set in o replaced.
+func (l TokenLifetime) Override(o TokenLifetime) TokenLifetime {
+	if o.AccessTTL != 0 {
+		l.AccessTTL = o.AccessTTL
+	}
+	if o.RefreshTTL != 0 {
+		l.RefreshTTL = o.RefreshTTL
+	}
+	if o.RememberMeTTL != 0 {
+		l.RememberMeTTL = o.RememberMeTTL
+	}
+	if o.RememberMe != nil {
+		l.RememberMe = o.RememberMe
+	}
+	return l
+}
+
+func (l TokenLifetime) AllowsRememberMe() bool {
+	return l.RememberMe == nil || *l.RememberMe
+}
+
+// SessionTTL is the refresh token TTL of a login.
+func (l TokenLifetime) SessionTTL(rememberMe bool) time.Duration {
+	if rememberMe && l.AllowsRememberMe() {
+		return l.RememberMeTTL.Std()
+	}
+	return l.RefreshTTL.Std()
+}
+
+// Validate checks an override, only the signs can be told apart from an
+// inherited value.
+func (l TokenLifetime) Validate() error {
+	if l.AccessTTL < 0 || l.RefreshTTL < 0 || l.RememberMeTTL < 0 {
+		return errors.New("token ttls must not be negative")
+	}
+	return nil
+}
+
+// ValidateResolved checks a lifetime after all overrides were applied.
+func (l TokenLifetime) ValidateResolved() error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	if l.AccessTTL == 0 || l.RefreshTTL == 0 || l.RememberMeTTL == 0 {
+		return errors.New("token ttls must be positive")
+	}
+	if l.AccessTTL > l.RefreshTTL {
+		return errors.New("access_ttl must not exceed refresh_ttl")
+	}
+	if l.AllowsRememberMe() && l.RememberMeTTL < l.RefreshTTL {
+		return errors.New("remember_me_ttl must not be shorter than refresh_ttl")
+	}
+	return nil
+}
+
+// TokenLifetimes resolves the TTLs of a calling service: its entry in
+// Services over Default.
+type TokenLifetimes struct {
+	Default  TokenLifetime            `json:"default"`
+	Services map[string]TokenLifetime `json:"services"`
+}
+
+func DefaultTokenLifetimes() *TokenLifetimes {
+	return &TokenLifetimes{
+		Default: TokenLifetime{
+			AccessTTL:     Duration(DefaultAccessTTL),
+			RefreshTTL:    Duration(DefaultRefreshTTL),
+			RememberMeTTL: Duration(DefaultRememberMeTTL),
+		},
+	}
+}
+
+func (p *TokenLifetimes) Resolve(service string) TokenLifetime {
+	return p.Default.Override(p.Services[service])
+}
+
+func (p *TokenLifetimes) Validate() error {
+	if err := p.Default.ValidateResolved(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for name, l := range p.Services {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		if err := p.Resolve(name).ValidateResolved(); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package policy
+
+import (
+	"errors"
+	"time"
+)
+
+// Janitor modes
+const (
+	RetentionArchive = "archive" // move finished sessions to session_history
+	RetentionDelete  = "delete"  // drop them
+)
+
+// RetentionPolicy controls the cleanup of finished sessions.
+type RetentionPolicy struct {
+	Mode string
+	// how often the janitor runs
+	Interval time.Duration
+	// rows moved per statement
+	BatchSize int
+	// expired / revoked rows stay in refresh_tokens this long, so they still
+	// show up in session lists
+	Grace time.Duration
+	// session_history partitions older than this are dropped, zero keeps them
+	HistoryRetention time.Duration
+}
+
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		Mode:             RetentionArchive,
+		Interval:         time.Hour,
+		BatchSize:        1000,
+		Grace:            time.Hour * 24 * 7,
+		HistoryRetention: time.Hour * 24 * 180,
+	}
+}
+
+func (p RetentionPolicy) Validate() error {
+	if p.Mode != RetentionArchive && p.Mode != RetentionDelete {
+		return errors.New("mode must be archive or delete")
+	}
+	if p.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if p.BatchSize <= 0 {
+		return errors.New("batch size must be positive")
+	}
+	if p.Grace < 0 || p.HistoryRetention < 0 {
+		return errors.New("durations must not be negative")
+	}
+	return nil
+}
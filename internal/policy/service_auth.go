@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ways a calling service can authenticate.
+const (
+	ServiceAuthAPIKey     = "api_key" // X-Service-Key
+	ServiceAuthClientCert = "mtls"    // verified TLS client certificate
+	ServiceAuthSignature  = "hmac"    // request signed with the key secret
+)
+
+// ServiceAuthRoute sets the accepted methods of the routes matching Endpoint.
+type ServiceAuthRoute struct {
+	Endpoint string   `json:"endpoint"` // "[METHOD ]PATH", a trailing * matches any suffix
+	Methods  []string `json:"methods"`
+}
+
+// ServiceAuthPolicy decides per route how a calling service must
+// authenticate. The first matching route wins, Default applies otherwise.
+type ServiceAuthPolicy struct {
+	Default []string           `json:"default"`
+	Routes  []ServiceAuthRoute `json:"routes"`
+	// how far the timestamp of a signed request may be off the server clock
+	MaxClockSkew Duration `json:"max_clock_skew"`
+}
+
+func DefaultServiceAuthPolicy() *ServiceAuthPolicy {
+	return &ServiceAuthPolicy{
+		Default:      []string{ServiceAuthAPIKey},
+		MaxClockSkew: Duration(5 * time.Minute),
+	}
+}
+
+func (p *ServiceAuthPolicy) Resolve(method, path string) []string {
+	for _, r := range p.Routes {
+		if MatchEndpoint(r.Endpoint, method, path) {
+			return r.Methods
+		}
+	}
+	return p.Default
+}
+
+// Uses reports whether any route accepts the method.
+func (p *ServiceAuthPolicy) Uses(authMethod string) bool {
+	if slices.Contains(p.Default, authMethod) {
+		return true
+	}
+	for _, r := range p.Routes {
+		if slices.Contains(r.Methods, authMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ServiceAuthPolicy) Validate() error {
+	if p.MaxClockSkew.Std() < time.Second || p.MaxClockSkew.Std() > time.Hour {
+		return errors.New("max_clock_skew must be between 1s and 1h")
+	}
+	if err := validateAuthMethods(p.Default); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for _, r := range p.Routes {
+		if _, _, err := ParseEndpoint(r.Endpoint); err != nil {
+			return err
+		}
+		if err := validateAuthMethods(r.Methods); err != nil {
+			return fmt.Errorf("route %s: %w", r.Endpoint, err)
+		}
+	}
+	return nil
+}
+
+func validateAuthMethods(methods []string) error {
+	if len(methods) == 0 {
+		return errors.New("at least one method is required")
+	}
+	for _, m := range methods {
+		if m != ServiceAuthAPIKey && m != ServiceAuthClientCert && m != ServiceAuthSignature {
+			return fmt.Errorf("unknown method %q", m)
+		}
+	}
+	return nil
+}
+
+// ParseEndpoint splits an endpoint pattern "[METHOD ]PATH".
+func ParseEndpoint(e string) (string, string, error) {
+	method, path, ok := strings.Cut(strings.TrimSpace(e), " ")
+	if !ok {
+		method, path = "", method
+	}
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("invalid endpoint %q: path must start with /", e)
+	}
+	return strings.ToUpper(method), path, nil
+}
+
+// MatchEndpoint reports whether the request method and route path match
+// the endpoint pattern. A pattern without method matches every method.
+func MatchEndpoint(pattern, method, path string) bool {
+	m, p, err := ParseEndpoint(pattern)
+	if err != nil || (m != "" && m != method) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(p, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return p == path
+}
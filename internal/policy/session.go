@@ -0,0 +1,52 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SessionPolicy limits how long a session lives. Zero disables a limit.
+type SessionPolicy struct {
+	// session ends after this long without a refresh, extended by every refresh
+	IdleTimeout Duration `json:"idle_timeout"`
+	// session ends this long after login no matter how active it is
+	AbsoluteLifetime Duration `json:"absolute_lifetime"`
+}
+
+func (p SessionPolicy) Validate() error {
+	if p.IdleTimeout < 0 || p.AbsoluteLifetime < 0 {
+		return errors.New("durations must not be negative")
+	}
+	if p.IdleTimeout > 0 && p.AbsoluteLifetime > 0 && p.IdleTimeout > p.AbsoluteLifetime {
+		return errors.New("idle_timeout must not exceed absolute_lifetime")
+	}
+	return nil
+}
+
+type SessionPolicies struct {
+	Default  SessionPolicy            `json:"default"`
+	Services map[string]SessionPolicy `json:"services"`
+}
+
+func DefaultSessionPolicies() *SessionPolicies {
+	return &SessionPolicies{}
+}
+
+func (p *SessionPolicies) Resolve(service string) SessionPolicy {
+	if sp, ok := p.Services[service]; ok {
+		return sp
+	}
+	return p.Default
+}
+
+func (p *SessionPolicies) Validate() error {
+	if err := p.Default.Validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for name, sp := range p.Services {
+		if err := sp.Validate(); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	return nil
+}
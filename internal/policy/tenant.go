@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Login methods a tenant can allow.
+const (
+	ProviderService  = "service" // POST /auth/login, the calling service vouches for the user
+	ProviderGoogle   = "google"
+	ProviderSMS      = "sms"
+	ProviderWebAuthn = "webauthn"
+)
+
+var providers = []string{ProviderService, ProviderGoogle, ProviderSMS, ProviderWebAuthn}
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidTenantID reports whether id can name a tenant. Tenant ids are part
+// of Redis keys, so they are restricted like service names.
+func ValidTenantID(id string) bool {
+	return tenantIDPattern.MatchString(id)
+}
+
+// TenantPolicy is the configuration of one tenant. Unset parts fall back to
+// the global configuration.
+type TenantPolicy struct {
+	// overrides the token lifetimes of every service
+	TokenLifetime
+	// overrides per calling service, over the tenant wide ones
+	ServiceLifetimes map[string]TokenLifetime `json:"service_lifetimes"`
+	// replaces the global device policies for the tenant's logins
+	Devices *DevicePolicies `json:"devices"`
+	// login methods the tenant accepts, empty allows all
+	AllowedProviders []string `json:"allowed_providers"`
+	// audience of Google ID tokens, GOOGLE_CLIENT_ID when empty
+	GoogleClientID string `json:"google_client_id"`
+	// file holding the HMAC key of the tenant's tokens, the global secret
+	// when empty
+	SigningKeyFile string `json:"signing_key_file"`
+}
+
+func (p TenantPolicy) AllowsProvider(provider string) bool {
+	return len(p.AllowedProviders) == 0 || slices.Contains(p.AllowedProviders, provider)
+}
+
+// Lifetime returns the tenant's overrides of the token lifetime of service.
+func (p TenantPolicy) Lifetime(service string) TokenLifetime {
+	return p.TokenLifetime.Override(p.ServiceLifetimes[service])
+}
+
+func (p TenantPolicy) Validate() error {
+	if err := p.TokenLifetime.Validate(); err != nil {
+		return err
+	}
+	for name, l := range p.ServiceLifetimes {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	if p.Devices != nil {
+		if err := p.Devices.Validate(); err != nil {
+			return fmt.Errorf("devices: %w", err)
+		}
+	}
+	for _, provider := range p.AllowedProviders {
+		if !slices.Contains(providers, provider) {
+			return fmt.Errorf("unknown provider %q", provider)
+		}
+	}
+	return nil
+}
+
+// TenantPolicies maps tenant ids to their configuration. Tenants without an
+// entry use the global configuration.
+type TenantPolicies map[string]TenantPolicy
+
+func (p TenantPolicies) Resolve(tenantID string) TenantPolicy {
+	return p[tenantID]
+}
+
+// ValidateLifetimes checks the token lifetimes every tenant ends up with
+// for every service either side configures.
+func (p TenantPolicies) ValidateLifetimes(global *TokenLifetimes) error {
+	for id, tp := range p {
+		services := []string{""}
+		for name := range global.Services {
+			services = append(services, name)
+		}
+		for name := range tp.ServiceLifetimes {
+			services = append(services, name)
+		}
+		for _, service := range services {
+			if err := global.Resolve(service).Override(tp.Lifetime(service)).ValidateResolved(); err != nil {
+				if service == "" {
+					return fmt.Errorf("tenant %s: %w", id, err)
+				}
+				return fmt.Errorf("tenant %s service %s: %w", id, service, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p TenantPolicies) Validate() error {
+	for id, tp := range p {
+		if !ValidTenantID(id) {
+			return fmt.Errorf("invalid tenant id %q", id)
+		}
+		if err := tp.Validate(); err != nil {
+			return fmt.Errorf("tenant %s: %w", id, err)
+		}
+	}
+	return nil
+}
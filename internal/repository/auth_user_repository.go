@@ -2,21 +2,60 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"central-auth/internal/domain"
 )
 
+// AuthUserRepository stores users and sessions. User ids are unique per
+// tenant only, every lookup is scoped to one.
 type AuthUserRepository interface {
 	// AuthUser
-	FindByProvider(provider, providerID string) (*domain.AuthUser, error)
-	Save(user *domain.AuthUser) error
+	FindByProvider(ctx context.Context, tenantID, provider, providerID string) (*domain.AuthUser, error)
+	Save(ctx context.Context, user *domain.AuthUser) error
+	FindByUserID(ctx context.Context, tenantID, userID string) (*domain.AuthUser, error)
+	FindByPhone(ctx context.Context, tenantID, phone string) (*domain.AuthUser, error)
+	SetPhoneNumber(ctx context.Context, tenantID, userID string, phone string) error
 
 	// Refresh Token
 	SaveRefreshToken(ctx context.Context, token *domain.RefreshToken) error
-	UpdateLastUsedAt(ctx context.Context, userID string, deviceID string) error
-	RevokeDevice(ctx context.Context, userID string, deviceID string) error
-	RevokeAllDevices(ctx context.Context, userID string) error
+	UpdateLastUsedAt(ctx context.Context, tenantID, userID string, deviceID string) (bool, error)
+	UpdateRefreshTokenHash(ctx context.Context, tenantID, userID string, deviceID string, oldHash, newHash string) (bool, error)
+	RevokeDevice(ctx context.Context, tenantID, userID string, deviceID string) error
+	RevokeAllDevices(ctx context.Context, tenantID, userID string) ([]string, error)
+	RevokeOtherDevices(ctx context.Context, tenantID, userID string, keepDeviceID string) ([]string, error)
 
-	GetLoginDevices(ctx context.Context, userID string) ([]domain.LoginDeviceInfo, error)
-	CountActiveDevices(ctx context.Context, userID string) (int, error)
+	GetLoginDevices(ctx context.Context, tenantID, userID string, limit int, offset int) ([]domain.LoginDeviceInfo, error)
+	CountActiveDevices(ctx context.Context, tenantID, userID string) (int, error)
+	ListActiveSessions(ctx context.Context, afterTenantID, afterUserID string, afterDeviceID string, limit int) ([]domain.RefreshToken, error)
+	ActiveDeviceIDs(ctx context.Context, tenantID, userID string) ([]string, error)
+
+	// Session Outbox
+	ClaimSessionOutbox(ctx context.Context, limit int, lease time.Duration) ([]domain.SessionOutboxEvent, error)
+	CompleteSessionOutbox(ctx context.Context, tenantID, userID string, deviceIDs []string) error
+	FailSessionOutbox(ctx context.Context, id int64, lastError string, retryAt time.Time) error
+
+	// Janitor
+	ArchiveFinishedSessions(ctx context.Context, now, before time.Time, limit int, archive bool) (int64, error)
+	PruneSessionOutbox(ctx context.Context, before time.Time, limit int) (int64, error)
+	EnsureHistoryPartitions(ctx context.Context, from time.Time, months int) error
+	DropHistoryPartitions(ctx context.Context, before time.Time) ([]string, error)
+	RunExclusive(ctx context.Context, lockID int64, fn func(ctx context.Context) error) (bool, error)
+
+	// WebAuthn
+	SaveWebAuthnCredential(ctx context.Context, cred *domain.WebAuthnCredential) error
+	GetWebAuthnCredentials(ctx context.Context, tenantID, userID string) ([]domain.WebAuthnCredential, error)
+	FindWebAuthnCredential(ctx context.Context, tenantID string, credentialID []byte) (*domain.WebAuthnCredential, error)
+	UpdateWebAuthnSignCount(ctx context.Context, credentialID []byte, signCount uint32, cloneWarning bool) error
+
+	// Service Clients
+	SaveServiceClient(ctx context.Context, client *domain.ServiceClient) error
+	FindServiceClient(ctx context.Context, name string) (*domain.ServiceClient, error)
+	ListServiceClients(ctx context.Context) ([]domain.ServiceClient, error)
+	ServiceNamesByCertIdentity(ctx context.Context, identities []string) ([]string, error)
+	SaveServiceClientKey(ctx context.Context, key *domain.ServiceClientKey) error
+	FindServiceClientKey(ctx context.Context, keyID string) (*domain.ServiceClientKey, error)
+	ListServiceClientKeys(ctx context.Context, serviceName string) ([]domain.ServiceClientKey, error)
+	RevokeServiceClientKey(ctx context.Context, keyID string) (bool, error)
+	TouchServiceClientKey(ctx context.Context, keyID string, at time.Time) error
 }
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+)
+
+// Kinds of DependencyError, handlers answer 504 and 503 for them.
+var (
+	ErrTimeout     = errors.New("timed out")
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// DependencyError is a call to Redis or the database that timed out or
+// could not reach the server. errors.Is matches Kind and the cause.
+type DependencyError struct {
+	Dependency string // redis, postgres, sqlite
+	Kind       error  // ErrTimeout or ErrUnavailable
+	Err        error
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("%s %v: %v", e.Dependency, e.Kind, e.Err)
+}
+
+func (e *DependencyError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// classify wraps timeouts and connection failures of dependency in a
+// DependencyError and returns every other error unchanged. A canceled
+// context means the client went away, that is not the dependency's fault.
+func classify(dependency string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var depErr *DependencyError
+	if errors.As(err, &depErr) {
+		return err
+	}
+
+	var netErr net.Error
+	var connectErr *pgconn.ConnectError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, redis.ErrPoolTimeout),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return &DependencyError{Dependency: dependency, Kind: ErrTimeout, Err: err}
+	case errors.As(err, &connectErr),
+		errors.As(err, &netErr),
+		errors.Is(err, redis.ErrClosed),
+		errors.Is(err, sql.ErrConnDone):
+		return &DependencyError{Dependency: dependency, Kind: ErrUnavailable, Err: err}
+	}
+	return err
+}
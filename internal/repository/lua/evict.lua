@@ -0,0 +1,48 @@
+-- Removes device sessions of one user atomically.
+--
+-- KEYS[1] auth:devices:{user}
+-- KEYS[2] auth:devices:seen:{user}
+-- ARGV[1] refresh key prefix (auth:refresh:{user}:)
+--          refresh keys are built from it; the {user} hash tag keeps them
+--          in the slot of KEYS[1] on a cluster
+-- ARGV[2] mode: "only" removes ARGV[3..], "except" removes all but ARGV[3..]
+-- ARGV[3..] device ids
+--
+-- Returns the device ids that had a session.
+
+local devices = KEYS[1]
+local seen = KEYS[2]
+local prefix = ARGV[1]
+local mode = ARGV[2]
+
+local listed = {}
+for i = 3, #ARGV do
+  listed[ARGV[i]] = true
+end
+
+local targets = {}
+if mode == 'only' then
+  for i = 3, #ARGV do
+    table.insert(targets, ARGV[i])
+  end
+else
+  for _, id in ipairs(redis.call('ZRANGE', devices, 0, -1)) do
+    if not listed[id] then
+      table.insert(targets, id)
+    end
+  end
+end
+
+local removed = {}
+for _, id in ipairs(targets) do
+  local had = redis.call('ZREM', devices, id) + redis.call('DEL', prefix .. id)
+  redis.call('ZREM', seen, id)
+  if had > 0 then
+    table.insert(removed, id)
+  end
+end
+
+if redis.call('ZCARD', devices) == 0 then
+  redis.call('DEL', devices, seen)
+end
+return removed
@@ -0,0 +1,12 @@
+-- Counts a verification attempt of an OTP challenge. The challenge may
+-- expire between reading and counting, a plain HINCRBY would then start a
+-- new hash without TTL.
+--
+-- KEYS[1] auth:otp:<id>
+--
+-- Returns the attempts so far, or -1 when the challenge is gone.
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return -1
+end
+return redis.call('HINCRBY', KEYS[1], 'attempts', 1)
@@ -0,0 +1,28 @@
+-- Validates a refresh token hash against the stored one, slides the idle timeout
+-- and marks the device as used (LRU eviction) in one step.
+--
+-- KEYS[1] auth:refresh:{user}:<device>
+-- KEYS[2] auth:devices:{user}
+-- KEYS[3] auth:devices:seen:{user}
+-- ARGV[1] device id
+-- ARGV[2] hash of the presented refresh token
+-- ARGV[3] now (unix seconds)
+-- ARGV[4] new ttl of the session in milliseconds
+--
+-- Returns 1 when the session is valid, 0 otherwise.
+
+local stored = redis.call('GET', KEYS[1])
+if not stored or stored ~= ARGV[2] then
+  return 0
+end
+
+local ttl = tonumber(ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ttl)
+redis.call('ZADD', KEYS[3], 'XX', ARGV[3], ARGV[1])
+
+for _, key in ipairs({KEYS[2], KEYS[3]}) do
+  if redis.call('PTTL', key) < ttl then
+    redis.call('PEXPIRE', key, ttl)
+  end
+end
+return 1
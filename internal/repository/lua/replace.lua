@@ -0,0 +1,16 @@
+-- Swaps the refresh token hash of a session if it is still the expected one,
+-- keeping the TTL.
+--
+-- KEYS[1] auth:refresh:{user}:<device>
+-- ARGV[1] hash of the current refresh token
+-- ARGV[2] hash of the new refresh token
+--
+-- Returns 1 when swapped, 0 when the session is gone or holds another token.
+
+local stored = redis.call('GET', KEYS[1])
+if not stored or stored ~= ARGV[1] then
+  return 0
+end
+
+redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+return 1
@@ -0,0 +1,45 @@
+-- Rebuilds the session of one device from Postgres without touching
+-- sessions that are already correct.
+--
+-- KEYS[1] auth:refresh:{user}:<device>
+-- KEYS[2] auth:devices:{user}
+-- KEYS[3] auth:devices:seen:{user}
+-- ARGV[1] device id
+-- ARGV[2] refresh token hash (postgres wins)
+-- ARGV[3] login time (unix seconds)
+-- ARGV[4] last use (unix seconds)
+-- ARGV[5] ttl in milliseconds, used only when the key is missing
+-- ARGV[6] grace start (unix seconds): a device that logged in after it may
+--         not have reached postgres yet, its hash is left alone
+--
+-- Returns 0 nothing changed, 1 session restored, 2 token hash replaced,
+-- 3 device index repaired.
+
+local stored = redis.call('GET', KEYS[1])
+local result = 0
+
+if not stored then
+  redis.call('SET', KEYS[1], ARGV[2], 'PX', tonumber(ARGV[5]))
+  result = 1
+elseif stored ~= ARGV[2] then
+  local loginAt = redis.call('ZSCORE', KEYS[2], ARGV[1])
+  if loginAt and tonumber(loginAt) >= tonumber(ARGV[6]) then
+    return 0
+  end
+  redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+  result = 2
+end
+
+local added = redis.call('ZADD', KEYS[2], 'NX', ARGV[3], ARGV[1])
+redis.call('ZADD', KEYS[3], 'NX', ARGV[4], ARGV[1])
+if added == 1 and result == 0 then
+  result = 3
+end
+
+local ttl = redis.call('PTTL', KEYS[1])
+for _, key in ipairs({KEYS[2], KEYS[3]}) do
+  if redis.call('PTTL', key) < ttl then
+    redis.call('PEXPIRE', key, ttl)
+  end
+end
+return result
@@ -0,0 +1,97 @@
+-- Creates or replaces the session of one device and enforces the device limit
+-- in a single step, so concurrent logins can never exceed it.
+--
+-- KEYS[1] auth:devices:{user}       (score: login time)
+-- KEYS[2] auth:devices:seen:{user}  (score: last login or refresh)
+-- ARGV[1] refresh key prefix        (auth:refresh:{user}:)
+--          refresh keys are built from it; the {user} hash tag keeps them
+--          in the slot of KEYS[1] on a cluster
+-- ARGV[2] device id
+-- ARGV[3] refresh token hash
+-- ARGV[4] ttl in milliseconds
+-- ARGV[5] now (unix seconds)
+-- ARGV[6] max devices
+-- ARGV[7] strategy (evict_oldest, evict_lru, reject, require_choice)
+-- ARGV[8] device the user chose to replace, may be empty
+--
+-- Returns {1, evicted...} on success or {0, current devices...} when the
+-- policy refuses the new device.
+
+local devices = KEYS[1]
+local seen = KEYS[2]
+local prefix = ARGV[1]
+local device = ARGV[2]
+local ttl = tonumber(ARGV[4])
+local now = tonumber(ARGV[5])
+local max = tonumber(ARGV[6])
+local strategy = ARGV[7]
+local replace = ARGV[8]
+
+local function drop(id)
+  redis.call('ZREM', devices, id)
+  redis.call('ZREM', seen, id)
+  redis.call('DEL', prefix .. id)
+end
+
+-- devices whose refresh key already expired do not count
+for _, id in ipairs(redis.call('ZRANGE', devices, 0, -1)) do
+  if redis.call('EXISTS', prefix .. id) == 0 then
+    redis.call('ZREM', devices, id)
+    redis.call('ZREM', seen, id)
+  end
+end
+
+local evicted = {}
+
+if not redis.call('ZSCORE', devices, device) then
+  local count = redis.call('ZCARD', devices)
+  if replace ~= '' then
+    if not redis.call('ZSCORE', devices, replace) then
+      return redis.error_reply('replace_device_not_found')
+    end
+    count = count - 1
+  end
+
+  -- a refused login keeps the device the user chose to replace
+  if count >= max and (strategy == 'reject' or strategy == 'require_choice') then
+    local result = {0}
+    for _, id in ipairs(redis.call('ZRANGE', devices, 0, -1)) do
+      table.insert(result, id)
+    end
+    return result
+  end
+
+  if replace ~= '' then
+    drop(replace)
+    table.insert(evicted, replace)
+  end
+
+  if count >= max then
+    local source = devices
+    if strategy == 'evict_lru' then
+      source = seen
+    end
+    -- drop enough devices to fit, the limit may have been lowered
+    for _, id in ipairs(redis.call('ZRANGE', source, 0, count - max)) do
+      drop(id)
+      table.insert(evicted, id)
+    end
+  end
+end
+
+redis.call('ZADD', devices, now, device)
+redis.call('ZADD', seen, now, device)
+redis.call('SET', prefix .. device, ARGV[3], 'PX', ttl)
+
+-- the device sets live as long as their longest session
+for _, key in ipairs({devices, seen}) do
+  if redis.call('PTTL', key) < ttl then
+    redis.call('PEXPIRE', key, ttl)
+  end
+end
+
+local result = {1}
+for _, id in ipairs(evicted) do
+  table.insert(result, id)
+end
+return result
@@ -0,0 +1,515 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+)
+
+// MemorySessionStore is an in-process SessionStore with the same semantics
+// as RedisRepository: TTLs, login order, LRU and the device policy. State is
+// lost on restart, it is meant for dev mode, single-binary mode and tests.
+type MemorySessionStore struct {
+	mu      sync.Mutex
+	users   map[memUser]map[string]*memSession // user -> device -> session
+	blobs   map[string]memBlob                 // webauthn ceremonies
+	otps    map[string]*memOTP
+	cooling map[string]time.Time // phone -> end of cooldown
+	rates   map[string]*memRate  // phone -> sends in window
+	nonces  map[string]time.Time // service:nonce -> expiry
+	clock   clock.Clock
+	// writes drop every expired entry at most once per memorySweepInterval,
+	// keys that are never read again would stay forever otherwise
+	nextSweep time.Time
+}
+
+const memorySweepInterval = time.Minute
+
+type memUser struct {
+	tenantID string
+	userID   string
+}
+
+type memSession struct {
+	tokenHash string
+	loginAt   int64 // unix seconds, like the redis scores
+	seenAt    int64
+	expiresAt time.Time
+}
+
+type memBlob struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+type memOTP struct {
+	ch        domain.OTPChallenge
+	expiresAt time.Time
+}
+
+type memRate struct {
+	count     int64
+	expiresAt time.Time
+}
+
+func NewMemorySessionStore(clk clock.Clock) SessionStore {
+	return &MemorySessionStore{
+		clock:   clk,
+		users:   map[memUser]map[string]*memSession{},
+		blobs:   map[string]memBlob{},
+		otps:    map[string]*memOTP{},
+		cooling: map[string]time.Time{},
+		rates:   map[string]*memRate{},
+		nonces:  map[string]time.Time{},
+	}
+}
+
+// sweep drops expired sessions, ceremonies, challenges, rate counters and
+// nonces once the sweep interval passed, like Redis expiring its keys.
+// Callers hold mu.
+func (m *MemorySessionStore) sweep() {
+	now := m.clock.Now()
+	if now.Before(m.nextSweep) {
+		return
+	}
+	m.nextSweep = now.Add(memorySweepInterval)
+
+	for user := range m.users {
+		m.live(user)
+	}
+	for id, b := range m.blobs {
+		if !b.expiresAt.After(now) {
+			delete(m.blobs, id)
+		}
+	}
+	for id, o := range m.otps {
+		if !o.expiresAt.After(now) {
+			delete(m.otps, id)
+		}
+	}
+	for phone, until := range m.cooling {
+		if !until.After(now) {
+			delete(m.cooling, phone)
+		}
+	}
+	for phone, rate := range m.rates {
+		if !rate.expiresAt.After(now) {
+			delete(m.rates, phone)
+		}
+	}
+	for key, expiresAt := range m.nonces {
+		if !expiresAt.After(now) {
+			delete(m.nonces, key)
+		}
+	}
+}
+
+// Len is the number of entries held, expired ones included until the next
+// sweep; one per device session, ceremony, challenge, cooldown, rate counter
+// and nonce.
+func (m *MemorySessionStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := len(m.blobs) + len(m.otps) + len(m.cooling) + len(m.rates) + len(m.nonces)
+	for _, devices := range m.users {
+		n += len(devices)
+	}
+	return n
+}
+
+// live returns the unexpired sessions of the user, dropping expired ones.
+// Callers hold mu.
+func (m *MemorySessionStore) live(user memUser) map[string]*memSession {
+	devices := m.users[user]
+	now := m.clock.Now()
+	for id, s := range devices {
+		if !s.expiresAt.After(now) {
+			delete(devices, id)
+		}
+	}
+	if len(devices) == 0 {
+		delete(m.users, user)
+		return nil
+	}
+	return devices
+}
+
+// ordered lists device ids by score, ties by id (ZRANGE order).
+func ordered(devices map[string]*memSession, score func(*memSession) int64) []string {
+	ids := make([]string, 0, len(devices))
+	for id := range devices {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := score(devices[ids[i]]), score(devices[ids[j]])
+		if a != b {
+			return a < b
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func byLogin(s *memSession) int64 { return s.loginAt }
+func bySeen(s *memSession) int64  { return s.seenAt }
+
+func (m *MemorySessionStore) SaveLogin(
+	_ context.Context,
+	tenantID, userID, deviceID, tokenHash string,
+	ttl time.Duration,
+	p policy.DevicePolicy,
+	replaceDeviceID string,
+) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+
+	user := memUser{tenantID, userID}
+	devices := m.live(user)
+	if devices == nil {
+		devices = map[string]*memSession{}
+	}
+
+	var evicted []string
+	if _, ok := devices[deviceID]; !ok {
+		count := int64(len(devices))
+		if replaceDeviceID != "" {
+			if _, ok := devices[replaceDeviceID]; !ok {
+				return nil, ErrReplaceDeviceNotFound
+			}
+			count--
+		}
+		// a refused login keeps the device the user chose to replace
+		if count >= p.MaxDevices && (p.Strategy == policy.Reject || p.Strategy == policy.RequireChoice) {
+			return nil, &DeviceLimitError{Policy: p, DeviceIDs: ordered(devices, byLogin)}
+		}
+		if replaceDeviceID != "" {
+			delete(devices, replaceDeviceID)
+			evicted = append(evicted, replaceDeviceID)
+		}
+
+		if count >= p.MaxDevices {
+			score := byLogin
+			if p.Strategy == policy.EvictLRU {
+				score = bySeen
+			}
+			// drop enough devices to fit, the limit may have been lowered
+			for _, id := range ordered(devices, score)[:count-p.MaxDevices+1] {
+				delete(devices, id)
+				evicted = append(evicted, id)
+			}
+		}
+	}
+
+	now := m.clock.Now()
+	devices[deviceID] = &memSession{
+		tokenHash: tokenHash,
+		loginAt:   now.Unix(),
+		seenAt:    now.Unix(),
+		expiresAt: now.Add(ttl),
+	}
+	m.users[user] = devices
+	if evicted == nil {
+		evicted = []string{}
+	}
+	return evicted, nil
+}
+
+func (m *MemorySessionStore) RefreshSession(_ context.Context, tenantID, userID, deviceID, tokenHash string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.live(memUser{tenantID, userID})[deviceID]
+	if !ok || s.tokenHash != tokenHash {
+		return false, nil
+	}
+	now := m.clock.Now()
+	s.seenAt = now.Unix()
+	s.expiresAt = now.Add(ttl)
+	return true, nil
+}
+
+func (m *MemorySessionStore) SessionTTL(_ context.Context, tenantID, userID, deviceID string) (time.Duration, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.live(memUser{tenantID, userID})[deviceID]
+	if !ok {
+		return 0, false, nil
+	}
+	return clock.Until(m.clock, s.expiresAt), true, nil
+}
+
+func (m *MemorySessionStore) SessionTTLs(_ context.Context, tenantID, userID string, deviceIDs []string) (map[string]time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	live := m.live(memUser{tenantID, userID})
+	ttls := make(map[string]time.Duration, len(deviceIDs))
+	for _, id := range deviceIDs {
+		if s, ok := live[id]; ok {
+			ttls[id] = clock.Until(m.clock, s.expiresAt)
+		}
+	}
+	return ttls, nil
+}
+
+func (m *MemorySessionStore) ExistsRefreshToken(_ context.Context, tenantID, userID, deviceID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.live(memUser{tenantID, userID})[deviceID]
+	return ok, nil
+}
+
+func (m *MemorySessionStore) ReplaceRefreshToken(_ context.Context, tenantID, userID, deviceID, oldHash, newHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.live(memUser{tenantID, userID})[deviceID]
+	if !ok || s.tokenHash != oldHash {
+		return false, nil
+	}
+	s.tokenHash = newHash
+	return true, nil
+}
+
+func (m *MemorySessionStore) GetDevices(_ context.Context, tenantID, userID string) (map[string]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	devices := m.live(memUser{tenantID, userID})
+	out := make(map[string]time.Time, len(devices))
+	for id, s := range devices {
+		out[id] = time.Unix(s.loginAt, 0)
+	}
+	return out, nil
+}
+
+// evict removes the listed devices (only) or all others (except) and
+// returns the ids that had a session.
+func (m *MemorySessionStore) evict(user memUser, only bool, deviceIDs []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	devices := m.live(user)
+	listed := map[string]bool{}
+	for _, id := range deviceIDs {
+		listed[id] = true
+	}
+
+	removed := []string{}
+	for _, id := range ordered(devices, byLogin) {
+		if listed[id] == only {
+			delete(devices, id)
+			removed = append(removed, id)
+		}
+	}
+	if len(devices) == 0 {
+		delete(m.users, user)
+	}
+	return removed
+}
+
+func (m *MemorySessionStore) LogoutDevice(_ context.Context, tenantID, userID, deviceID string) error {
+	m.evict(memUser{tenantID, userID}, true, []string{deviceID})
+	return nil
+}
+
+func (m *MemorySessionStore) LogoutOtherDevices(_ context.Context, tenantID, userID, keepDeviceID string) ([]string, error) {
+	return m.evict(memUser{tenantID, userID}, false, []string{keepDeviceID}), nil
+}
+
+func (m *MemorySessionStore) LogoutAll(_ context.Context, tenantID, userID string) error {
+	m.evict(memUser{tenantID, userID}, false, nil)
+	return nil
+}
+
+func (m *MemorySessionStore) RestoreSession(
+	_ context.Context,
+	tenantID, userID, deviceID, tokenHash string,
+	loginAt, lastUsedAt time.Time,
+	ttl time.Duration,
+	grace time.Duration,
+) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+
+	user := memUser{tenantID, userID}
+	devices := m.live(user)
+	if devices == nil {
+		devices = map[string]*memSession{}
+		m.users[user] = devices
+	}
+
+	s, ok := devices[deviceID]
+	switch {
+	case !ok:
+		devices[deviceID] = &memSession{
+			tokenHash: tokenHash,
+			loginAt:   loginAt.Unix(),
+			seenAt:    lastUsedAt.Unix(),
+			expiresAt: m.clock.Now().Add(ttl),
+		}
+		return RestoreCreated, nil
+	case s.tokenHash != tokenHash:
+		// postgres may not have seen this login yet
+		if s.loginAt >= m.clock.Now().Add(-grace).Unix() {
+			return RestoreUnchanged, nil
+		}
+		s.tokenHash = tokenHash
+		return RestoreHashFixed, nil
+	}
+	return RestoreUnchanged, nil
+}
+
+// EachSession calls fn for batches of the sessions ordered by tenant, user
+// and device. fn runs without the lock held, so it may call back into the
+// store.
+func (m *MemorySessionStore) EachSession(_ context.Context, batch int64, fn func([]SessionKey) error) error {
+	m.mu.Lock()
+	var all []SessionKey
+	for user := range m.users {
+		for deviceID := range m.live(user) {
+			all = append(all, SessionKey{TenantID: user.tenantID, UserID: user.userID, DeviceID: deviceID})
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].TenantID != all[j].TenantID {
+			return all[i].TenantID < all[j].TenantID
+		}
+		if all[i].UserID != all[j].UserID {
+			return all[i].UserID < all[j].UserID
+		}
+		return strings.Compare(all[i].DeviceID, all[j].DeviceID) < 0
+	})
+
+	for start := 0; start < len(all); start += int(batch) {
+		end := min(start+int(batch), len(all))
+		if err := fn(all[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemorySessionStore) SaveWebAuthnSession(_ context.Context, sessionID string, data []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+
+	m.blobs[sessionID] = memBlob{data: append([]byte(nil), data...), expiresAt: m.clock.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemorySessionStore) TakeWebAuthnSession(_ context.Context, sessionID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.blobs[sessionID]
+	delete(m.blobs, sessionID)
+	if !ok || !b.expiresAt.After(m.clock.Now()) {
+		return nil, nil
+	}
+	return b.data, nil
+}
+
+func (m *MemorySessionStore) AllowOTPSend(_ context.Context, phone string, cooldown time.Duration, window time.Duration, maxPerWindow int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+
+	now := m.clock.Now()
+	if until, ok := m.cooling[phone]; ok && until.After(now) {
+		return false, nil
+	}
+	m.cooling[phone] = now.Add(cooldown)
+
+	rate, ok := m.rates[phone]
+	if !ok || !rate.expiresAt.After(now) {
+		rate = &memRate{expiresAt: now.Add(window)}
+		m.rates[phone] = rate
+	}
+	rate.count++
+	return rate.count <= maxPerWindow, nil
+}
+
+func (m *MemorySessionStore) SaveOTPChallenge(_ context.Context, ch *domain.OTPChallenge, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+
+	stored := *ch
+	stored.Attempts = 0
+	m.otps[ch.ID] = &memOTP{ch: stored, expiresAt: m.clock.Now().Add(ttl)}
+	return nil
+}
+
+// otp returns the unexpired challenge, callers hold mu.
+func (m *MemorySessionStore) otp(otpID string) *memOTP {
+	o, ok := m.otps[otpID]
+	if !ok {
+		return nil
+	}
+	if !o.expiresAt.After(m.clock.Now()) {
+		delete(m.otps, otpID)
+		return nil
+	}
+	return o
+}
+
+func (m *MemorySessionStore) GetOTPChallenge(_ context.Context, otpID string) (*domain.OTPChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.otp(otpID)
+	if o == nil {
+		return nil, nil
+	}
+	ch := o.ch
+	return &ch, nil
+}
+
+func (m *MemorySessionStore) IncrOTPAttempts(_ context.Context, otpID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.otp(otpID)
+	if o == nil {
+		return 0, ErrOTPChallengeNotFound
+	}
+	o.ch.Attempts++
+	return o.ch.Attempts, nil
+}
+
+func (m *MemorySessionStore) DeleteOTPChallenge(_ context.Context, otpID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.otps, otpID)
+	return nil
+}
+
+func (m *MemorySessionStore) ClaimNonce(_ context.Context, serviceName, nonce string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+
+	now := m.clock.Now()
+	key := serviceName + ":" + nonce
+	if expiresAt, used := m.nonces[key]; used && expiresAt.After(now) {
+		return false, nil
+	}
+	m.nonces[key] = now.Add(ttl)
+	return true, nil
+}
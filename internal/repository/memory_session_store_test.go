@@ -0,0 +1,54 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+	"central-auth/internal/repository/repotest"
+)
+
+func TestMemorySessionStoreContract(t *testing.T) {
+	repotest.RunSessionStoreSuite(t, func(t *testing.T) (repository.SessionStore, func(time.Duration)) {
+		clk := clock.NewFake(time.Now())
+		return repository.NewMemorySessionStore(clk), clk.Advance
+	})
+}
+
+// entries that are never read again are dropped by later writes
+func TestMemorySessionStoreSweepsExpired(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Now())
+	m := repository.NewMemorySessionStore(clk).(*repository.MemorySessionStore)
+
+	if _, err := m.SaveLogin(ctx, "default", "u1", "d1", "hash", time.Minute, policy.DevicePolicy{MaxDevices: 1, Strategy: policy.EvictOldest}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AllowOTPSend(ctx, "+15550001111", time.Second, time.Minute, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveOTPChallenge(ctx, &domain.OTPChallenge{ID: "o1"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveWebAuthnSession(ctx, "w1", []byte("ceremony"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ClaimNonce(ctx, "shop", "n1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Len(); n != 6 {
+		t.Fatalf("Len = %d, want 6", n)
+	}
+
+	clk.Advance(time.Hour)
+	if err := m.SaveWebAuthnSession(ctx, "w2", []byte("ceremony"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Len(); n != 1 {
+		t.Fatalf("Len after sweep = %d, want 1", n)
+	}
+}
@@ -2,7 +2,9 @@ package repository
 
 import (
 	"context"
+	"errors"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"central-auth/internal/domain"
@@ -18,52 +20,146 @@ func NewPostgresAuthUserRepository(db *pgxpool.Pool) AuthUserRepository {
 
 // AuthUser
 func (r *PostgresAuthUserRepository) FindByProvider(
-	provider, providerID string,
+	ctx context.Context,
+	tenantID, provider, providerID string,
 ) (*domain.AuthUser, error) {
 
 	const query = `
-		SELECT user_id, provider, provider_user_id, email
+		SELECT tenant_id, user_id, provider, provider_user_id, email, phone_number
 		FROM auth_users
-		WHERE provider = $1 AND provider_user_id = $2
+		WHERE tenant_id = $1 AND provider = $2 AND provider_user_id = $3
 	`
 
-	row := r.db.QueryRow(context.Background(), query, provider, providerID)
+	row := r.db.QueryRow(ctx, query, tenantID, provider, providerID)
 
 	var u domain.AuthUser
-	err := row.Scan(&u.UserID, &u.Provider, &u.ProviderID, &u.Email)
+	err := row.Scan(&u.TenantID, &u.UserID, &u.Provider, &u.ProviderID, &u.Email, &u.PhoneNumber)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *PostgresAuthUserRepository) Save(ctx context.Context, user *domain.AuthUser) error {
+	const query = `
+		INSERT INTO auth_users (tenant_id, user_id, provider, provider_user_id, email, phone_number)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant_id, user_id) DO NOTHING
+	`
+	_, err := r.db.Exec(ctx, query,
+		user.TenantID, user.UserID, user.Provider, user.ProviderID, user.Email, user.PhoneNumber)
+	return err
+}
+
+func (r *PostgresAuthUserRepository) FindByUserID(ctx context.Context, tenantID, userID string) (*domain.AuthUser, error) {
+	const query = `
+		SELECT tenant_id, user_id, provider, provider_user_id, email, phone_number
+		FROM auth_users
+		WHERE tenant_id = $1 AND user_id = $2
+	`
+
+	row := r.db.QueryRow(ctx, query, tenantID, userID)
+
+	var u domain.AuthUser
+	err := row.Scan(&u.TenantID, &u.UserID, &u.Provider, &u.ProviderID, &u.Email, &u.PhoneNumber)
+	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 	return &u, nil
 }
 
-func (r *PostgresAuthUserRepository) Save(user *domain.AuthUser) error {
+func (r *PostgresAuthUserRepository) FindByPhone(ctx context.Context, tenantID, phone string) (*domain.AuthUser, error) {
 	const query = `
-		INSERT INTO auth_users (user_id, provider, provider_user_id, email)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (user_id) DO NOTHING
+		SELECT tenant_id, user_id, provider, provider_user_id, email, phone_number
+		FROM auth_users
+		WHERE tenant_id = $1 AND phone_number = $2
 	`
-	_, err := r.db.Exec(context.Background(), query,
-		user.UserID, user.Provider, user.ProviderID, user.Email)
+
+	row := r.db.QueryRow(ctx, query, tenantID, phone)
+
+	var u domain.AuthUser
+	err := row.Scan(&u.TenantID, &u.UserID, &u.Provider, &u.ProviderID, &u.Email, &u.PhoneNumber)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// SetPhoneNumber attaches a verified phone number to a user. Users that only
+// ever logged in through /auth/login have no auth_users row yet, so one is
+// created for them with the "external" provider.
+func (r *PostgresAuthUserRepository) SetPhoneNumber(ctx context.Context, tenantID, userID string, phone string) error {
+	const query = `
+		INSERT INTO auth_users (tenant_id, user_id, provider, provider_user_id, email, phone_number)
+		VALUES ($1, $2, 'external', $2, '', $3)
+		ON CONFLICT (tenant_id, user_id) DO UPDATE SET phone_number = EXCLUDED.phone_number
+	`
+	_, err := r.db.Exec(ctx, query, tenantID, userID, phone)
 	return err
 }
 
 // Refresh Token
+
+// SaveRefreshToken stores the session of a device. A device logging in again
+// replaces its row, the previous session is moved to session_history first,
+// ended when the new one was issued.
 func (r *PostgresAuthUserRepository) SaveRefreshToken(
 	ctx context.Context,
 	token *domain.RefreshToken,
 ) error {
 
-	const query = `
+	const archive = `
+		INSERT INTO session_history
+		(tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+		 revoked, user_agent, ip_address, service, ended_at, end_reason)
+		SELECT tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+		       revoked, user_agent, ip_address, service, $4,
+		       CASE WHEN revoked THEN 'revoked' ELSE 'replaced' END
+		FROM refresh_tokens
+		WHERE tenant_id = $1 AND user_id = $2 AND device_id = $3
+		FOR UPDATE
+	`
+
+	const upsert = `
 		INSERT INTO refresh_tokens
-		(user_id, device_id, token_hash, issued_at, expires_at, revoked,
-		 user_agent, ip_address, last_used_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		(tenant_id, user_id, device_id, token_hash, issued_at, expires_at, revoked,
+		 user_agent, ip_address, last_used_at, service)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT (tenant_id, user_id, device_id) DO UPDATE SET
+			token_hash   = EXCLUDED.token_hash,
+			issued_at    = EXCLUDED.issued_at,
+			expires_at   = EXCLUDED.expires_at,
+			revoked      = EXCLUDED.revoked,
+			revoked_at   = NULL,
+			user_agent   = EXCLUDED.user_agent,
+			ip_address   = EXCLUDED.ip_address,
+			last_used_at = EXCLUDED.last_used_at,
+			service      = EXCLUDED.service,
+			created_at   = NOW()
 	`
 
-	_, err := r.db.Exec(
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, archive, token.TenantID, token.UserID, token.DeviceID, token.IssuedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
 		ctx,
-		query,
+		upsert,
+		token.TenantID,
 		token.UserID,
 		token.DeviceID,
 		token.TokenHash,
@@ -73,25 +169,32 @@ func (r *PostgresAuthUserRepository) SaveRefreshToken(
 		token.UserAgent,
 		token.IP,
 		token.LastUsedAt,
-	)
-	return err
+		token.Service,
+	); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 // Device Info
 func (r *PostgresAuthUserRepository) GetLoginDevices(
 	ctx context.Context,
+	tenantID string,
 	userID string,
+	limit int,
+	offset int,
 ) ([]domain.LoginDeviceInfo, error) {
 
 	const query = `
 		SELECT device_id, user_agent, ip_address,
 		       issued_at, expires_at, last_used_at, revoked
 		FROM refresh_tokens
-		WHERE user_id = $1
-		ORDER BY issued_at DESC
+		WHERE tenant_id = $1 AND user_id = $2
+		ORDER BY issued_at DESC, device_id
+		LIMIT $3 OFFSET $4
 	`
 
-	rows, err := r.db.Query(ctx, query, userID)
+	rows, err := r.db.Query(ctx, query, tenantID, userID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -116,65 +219,237 @@ func (r *PostgresAuthUserRepository) GetLoginDevices(
 		result = append(result, info)
 	}
 
-	return result, nil
+	return result, rows.Err()
 }
 
 func (r *PostgresAuthUserRepository) CountActiveDevices(
 	ctx context.Context,
+	tenantID string,
 	userID string,
 ) (int, error) {
 
 	const q = `
 		SELECT COUNT(*)
 		FROM refresh_tokens
-		WHERE user_id = $1
+		WHERE tenant_id = $1
+		  AND user_id = $2
 		  AND revoked = false
 		  AND expires_at > NOW()
 	`
 
 	var count int
-	err := r.db.QueryRow(ctx, q, userID).Scan(&count)
+	err := r.db.QueryRow(ctx, q, tenantID, userID).Scan(&count)
 	return count, err
 }
 
+// ListActiveSessions pages through the non-revoked, unexpired sessions of
+// all users ordered by (tenant_id, user_id, device_id). Pass the last triple
+// of the previous page, empty strings for the first one.
+func (r *PostgresAuthUserRepository) ListActiveSessions(
+	ctx context.Context,
+	afterTenantID string,
+	afterUserID string,
+	afterDeviceID string,
+	limit int,
+) ([]domain.RefreshToken, error) {
+
+	const q = `
+		SELECT tenant_id, user_id, device_id, token_hash, issued_at, expires_at,
+		       last_used_at, service
+		FROM refresh_tokens
+		WHERE revoked = false
+		  AND expires_at > NOW()
+		  AND (tenant_id, user_id, device_id) > ($1, $2, $3)
+		ORDER BY tenant_id, user_id, device_id
+		LIMIT $4
+	`
+
+	rows, err := r.db.Query(ctx, q, afterTenantID, afterUserID, afterDeviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.RefreshToken
+	for rows.Next() {
+		var t domain.RefreshToken
+		if err := rows.Scan(
+			&t.TenantID,
+			&t.UserID,
+			&t.DeviceID,
+			&t.TokenHash,
+			&t.IssuedAt,
+			&t.ExpiresAt,
+			&t.LastUsedAt,
+			&t.Service,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+// ActiveDeviceIDs returns the devices of the user with a non-revoked,
+// unexpired session.
+func (r *PostgresAuthUserRepository) ActiveDeviceIDs(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+) ([]string, error) {
+	const q = `
+		SELECT device_id
+		FROM refresh_tokens
+		WHERE tenant_id = $1
+		  AND user_id = $2
+		  AND revoked = false
+		  AND expires_at > NOW()
+	`
+	rows, err := r.db.Query(ctx, q, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // Update & Revoke
+// UpdateLastUsedAt touches an active device, false when Postgres has no
+// active session for it.
 func (r *PostgresAuthUserRepository) UpdateLastUsedAt(
 	ctx context.Context,
+	tenantID string,
 	userID string,
 	deviceID string,
-) error {
+) (bool, error) {
 	const q = `
 		UPDATE refresh_tokens
 		SET last_used_at = NOW()
-		WHERE user_id = $1 AND device_id = $2 AND revoked = false
+		WHERE tenant_id = $1 AND user_id = $2 AND device_id = $3 AND revoked = false
 	`
-	_, err := r.db.Exec(ctx, q, userID, deviceID)
-	return err
+	tag, err := r.db.Exec(ctx, q, tenantID, userID, deviceID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
 }
 
+// UpdateRefreshTokenHash swaps the token hash of an active session from
+// oldHash to newHash. False when the session is revoked or holds another
+// token.
+func (r *PostgresAuthUserRepository) UpdateRefreshTokenHash(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	deviceID string,
+	oldHash string,
+	newHash string,
+) (bool, error) {
+	const q = `
+		UPDATE refresh_tokens
+		SET token_hash = $5, last_used_at = NOW()
+		WHERE tenant_id = $1 AND user_id = $2 AND device_id = $3 AND revoked = false
+		  AND token_hash = $4
+	`
+	tag, err := r.db.Exec(ctx, q, tenantID, userID, deviceID, oldHash, newHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RevokeDevice revokes one device and queues the eviction of its Redis
+// session in session_outbox, in one statement.
 func (r *PostgresAuthUserRepository) RevokeDevice(
 	ctx context.Context,
+	tenantID string,
 	userID string,
 	deviceID string,
 ) error {
 	const q = `
-		UPDATE refresh_tokens
-		SET revoked = true
-		WHERE user_id = $1 AND device_id = $2
+		WITH revoked AS (
+			UPDATE refresh_tokens
+			SET revoked = true, revoked_at = COALESCE(revoked_at, NOW())
+			WHERE tenant_id = $1 AND user_id = $2 AND device_id = $3
+			RETURNING tenant_id, user_id, device_id
+		)
+		INSERT INTO session_outbox (tenant_id, user_id, device_id)
+		SELECT tenant_id, user_id, device_id FROM revoked
 	`
-	_, err := r.db.Exec(ctx, q, userID, deviceID)
+	_, err := r.db.Exec(ctx, q, tenantID, userID, deviceID)
 	return err
 }
 
+// RevokeAllDevices revokes every active device of the user and returns the
+// revoked device ids.
 func (r *PostgresAuthUserRepository) RevokeAllDevices(
 	ctx context.Context,
+	tenantID string,
 	userID string,
-) error {
+) ([]string, error) {
 	const q = `
-		UPDATE refresh_tokens
-		SET revoked = true
-		WHERE user_id = $1
+		WITH revoked AS (
+			UPDATE refresh_tokens
+			SET revoked = true, revoked_at = COALESCE(revoked_at, NOW())
+			WHERE tenant_id = $1 AND user_id = $2 AND revoked = false
+			RETURNING tenant_id, user_id, device_id
+		)
+		INSERT INTO session_outbox (tenant_id, user_id, device_id)
+		SELECT tenant_id, user_id, device_id FROM revoked
+		RETURNING device_id
 	`
-	_, err := r.db.Exec(ctx, q, userID)
-	return err
+	return r.revokeDevices(ctx, q, tenantID, userID)
+}
+
+// RevokeOtherDevices revokes every active device of the user except
+// keepDeviceID and returns the revoked device ids.
+func (r *PostgresAuthUserRepository) RevokeOtherDevices(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	keepDeviceID string,
+) ([]string, error) {
+	const q = `
+		WITH revoked AS (
+			UPDATE refresh_tokens
+			SET revoked = true, revoked_at = COALESCE(revoked_at, NOW())
+			WHERE tenant_id = $1 AND user_id = $2 AND device_id <> $3 AND revoked = false
+			RETURNING tenant_id, user_id, device_id
+		)
+		INSERT INTO session_outbox (tenant_id, user_id, device_id)
+		SELECT tenant_id, user_id, device_id FROM revoked
+		RETURNING device_id
+	`
+	return r.revokeDevices(ctx, q, tenantID, userID, keepDeviceID)
+}
+
+func (r *PostgresAuthUserRepository) revokeDevices(
+	ctx context.Context,
+	query string,
+	args ...any,
+) ([]string, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revoked []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, deviceID)
+	}
+	return revoked, rows.Err()
 }
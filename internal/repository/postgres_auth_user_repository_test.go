@@ -0,0 +1,46 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"central-auth/internal/migrate"
+	"central-auth/internal/repository"
+	"central-auth/internal/repository/repotest"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newPostgresRepo migrates the database in POSTGRES_TEST_DSN and empties
+// its tables. Never point it at a database in use.
+func newPostgresRepo(t *testing.T) repository.AuthUserRepository {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+	pool, err := pgxpool.New(t.Context(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	migrator, err := migrate.New(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.Exec(t.Context(), `
+		TRUNCATE auth_users, refresh_tokens, session_history, session_outbox, webauthn_credentials,
+		         service_clients, service_client_keys, service_client_certs
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repository.NewPostgresAuthUserRepository(pool)
+}
+
+func TestPostgresAuthUserRepositoryContract(t *testing.T) {
+	repotest.RunAuthUserRepositorySuite(t, newPostgresRepo)
+}
@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Janitor
+
+// ArchiveFinishedSessions removes up to limit sessions that expired or were
+// revoked before `before`. With archive they are copied to session_history
+// in the same statement, ended at now. Returns the number of rows removed.
+func (r *PostgresAuthUserRepository) ArchiveFinishedSessions(
+	ctx context.Context,
+	now time.Time,
+	before time.Time,
+	limit int,
+	archive bool,
+) (int64, error) {
+
+	const finished = `
+		WITH finished AS (
+			DELETE FROM refresh_tokens
+			WHERE id IN (
+				SELECT id FROM refresh_tokens
+				WHERE expires_at < $1
+				   OR (revoked = true AND COALESCE(revoked_at, issued_at) < $1)
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		)
+	`
+
+	const archiveQuery = finished + `
+		INSERT INTO session_history
+		(tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+		 revoked, user_agent, ip_address, service, ended_at, end_reason)
+		SELECT tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+		       revoked, user_agent, ip_address, service, $3,
+		       CASE WHEN revoked THEN 'revoked' ELSE 'expired' END
+		FROM finished
+	`
+
+	const deleteQuery = finished + `
+		SELECT COUNT(*) FROM finished
+	`
+
+	if archive {
+		tag, err := r.db.Exec(ctx, archiveQuery, before, limit, now)
+		if err != nil {
+			return 0, err
+		}
+		return tag.RowsAffected(), nil
+	}
+
+	var n int64
+	err := r.db.QueryRow(ctx, deleteQuery, before, limit).Scan(&n)
+	return n, err
+}
+
+// PruneSessionOutbox deletes up to limit outbox rows applied before `before`.
+func (r *PostgresAuthUserRepository) PruneSessionOutbox(
+	ctx context.Context,
+	before time.Time,
+	limit int,
+) (int64, error) {
+	const q = `
+		DELETE FROM session_outbox
+		WHERE id IN (
+			SELECT id FROM session_outbox
+			WHERE processed_at < $1
+			LIMIT $2
+		)
+	`
+	tag, err := r.db.Exec(ctx, q, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+const (
+	historyPartitionPrefix  = "session_history_"
+	historyDefaultPartition = "session_history_default"
+)
+
+// EnsureHistoryPartitions creates the monthly session_history partitions for
+// the month of from and the following months-1 months, and for every month
+// that has rows in the default partition. Those rows are moved to their
+// month, Postgres refuses to create a partition for rows still in default.
+func (r *PostgresAuthUserRepository) EnsureHistoryPartitions(
+	ctx context.Context,
+	from time.Time,
+	months int,
+) error {
+	start := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	wanted := map[time.Time]bool{}
+	for i := 0; i < months; i++ {
+		wanted[start.AddDate(0, i, 0)] = true
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT DISTINCT date_trunc('month', ended_at AT TIME ZONE 'UTC')
+		FROM `+historyDefaultPartition)
+	if err != nil {
+		return err
+	}
+	stranded, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
+	if err != nil {
+		return err
+	}
+	for _, m := range stranded {
+		wanted[time.Date(m.Year(), m.Month(), 1, 0, 0, 0, 0, time.UTC)] = true
+	}
+
+	for lo := range wanted {
+		if err := r.createHistoryPartition(ctx, lo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createHistoryPartition creates the partition of the month starting at lo.
+// Rows of that month in the default partition are moved in the same
+// transaction: detach default, create the month, move, attach default.
+func (r *PostgresAuthUserRepository) createHistoryPartition(ctx context.Context, lo time.Time) error {
+	hi := lo.AddDate(0, 1, 0)
+	name := pgx.Identifier{historyPartitionPrefix + lo.Format("200601")}.Sanitize()
+	create := fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF session_history FOR VALUES FROM ('%s') TO ('%s')`,
+		name, lo.Format(time.RFC3339), hi.Format(time.RFC3339),
+	)
+
+	var stranded bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM `+historyDefaultPartition+` WHERE ended_at >= $1 AND ended_at < $2)
+	`, lo, hi).Scan(&stranded)
+	if err != nil {
+		return err
+	}
+	if !stranded {
+		if _, err := r.db.Exec(ctx, create); err != nil {
+			return fmt.Errorf("create partition %s: %w", name, err)
+		}
+		return nil
+	}
+
+	err = pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		steps := []struct {
+			q    string
+			args []any
+		}{
+			{`ALTER TABLE session_history DETACH PARTITION ` + historyDefaultPartition, nil},
+			{create, nil},
+			{`INSERT INTO session_history SELECT * FROM ` + historyDefaultPartition + ` WHERE ended_at >= $1 AND ended_at < $2`, []any{lo, hi}},
+			{`DELETE FROM ` + historyDefaultPartition + ` WHERE ended_at >= $1 AND ended_at < $2`, []any{lo, hi}},
+			{`ALTER TABLE session_history ATTACH PARTITION ` + historyDefaultPartition + ` DEFAULT`, nil},
+		}
+		for _, s := range steps {
+			if _, err := tx.Exec(ctx, s.q, s.args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("move default rows to partition %s: %w", name, err)
+	}
+	return nil
+}
+
+// DropHistoryPartitions drops the monthly partitions that end before
+// `before` and returns their names. The default partition is never dropped,
+// its rows that ended before `before` are deleted.
+func (r *PostgresAuthUserRepository) DropHistoryPartitions(
+	ctx context.Context,
+	before time.Time,
+) ([]string, error) {
+	const q = `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'session_history'
+	`
+	rows, err := r.db.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		month, err := time.Parse("200601", strings.TrimPrefix(name, historyPartitionPrefix))
+		if err != nil {
+			// default partition or a table not made by the janitor
+			continue
+		}
+		if !month.AddDate(0, 1, 0).After(before) {
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.db.Exec(ctx, `DELETE FROM `+historyDefaultPartition+` WHERE ended_at < $1`, before); err != nil {
+		return nil, fmt.Errorf("prune %s: %w", historyDefaultPartition, err)
+	}
+
+	var dropped []string
+	for _, name := range expired {
+		if _, err := r.db.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{name}.Sanitize()); err != nil {
+			return dropped, fmt.Errorf("drop partition %s: %w", name, err)
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, nil
+}
+
+// RunExclusive runs fn while holding the session level advisory lock lockID,
+// so only one replica does the work. Returns false without running fn when
+// another connection holds the lock.
+func (r *PostgresAuthUserRepository) RunExclusive(
+	ctx context.Context,
+	lockID int64,
+	fn func(ctx context.Context) error,
+) (bool, error) {
+	// the lock belongs to the connection, keep it for the whole run
+	conn, err := r.db.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockID).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+			// closing the connection releases the lock as well
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	return true, fn(ctx)
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"central-auth/internal/domain"
+)
+
+// Service Client
+func (r *PostgresAuthUserRepository) SaveServiceClient(
+	ctx context.Context,
+	client *domain.ServiceClient,
+) error {
+
+	// created_at stays the one of the first registration
+	const upsert = `
+		INSERT INTO service_clients
+		(name, tenant_id, allowed_endpoints, allowed_ips, disabled, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		ON CONFLICT (name) DO UPDATE
+		SET tenant_id = EXCLUDED.tenant_id,
+		    allowed_endpoints = EXCLUDED.allowed_endpoints,
+		    allowed_ips = EXCLUDED.allowed_ips,
+		    disabled = EXCLUDED.disabled
+	`
+	// the certificate identities are replaced as a whole
+	const clearCerts = `DELETE FROM service_client_certs WHERE service_name = $1`
+	const insertCerts = `
+		INSERT INTO service_client_certs (identity, service_name)
+		SELECT unnest($2::text[]), $1
+	`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(
+		ctx,
+		upsert,
+		client.Name,
+		client.TenantID,
+		nonNil(client.AllowedEndpoints),
+		nonNil(client.AllowedIPs),
+		client.Disabled,
+		client.CreatedAt,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, clearCerts, client.Name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, insertCerts, client.Name, nonNil(client.CertIdentities)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// nonNil keeps NOT NULL array columns from receiving NULL.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+const serviceClientColumns = `
+	name, tenant_id, allowed_endpoints, allowed_ips,
+	ARRAY(SELECT identity FROM service_client_certs k WHERE k.service_name = c.name ORDER BY identity),
+	disabled, created_at
+`
+
+func scanServiceClient(row pgx.Row) (*domain.ServiceClient, error) {
+	var c domain.ServiceClient
+	err := row.Scan(
+		&c.Name,
+		&c.TenantID,
+		&c.AllowedEndpoints,
+		&c.AllowedIPs,
+		&c.CertIdentities,
+		&c.Disabled,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *PostgresAuthUserRepository) FindServiceClient(
+	ctx context.Context,
+	name string,
+) (*domain.ServiceClient, error) {
+
+	query := `SELECT ` + serviceClientColumns + `
+		FROM service_clients c
+		WHERE name = $1
+	`
+
+	c, err := scanServiceClient(r.db.QueryRow(ctx, query, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (r *PostgresAuthUserRepository) ListServiceClients(ctx context.Context) ([]domain.ServiceClient, error) {
+	query := `SELECT ` + serviceClientColumns + `
+		FROM service_clients c
+		ORDER BY name
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.ServiceClient
+	for rows.Next() {
+		c, err := scanServiceClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *c)
+	}
+	return result, rows.Err()
+}
+
+func (r *PostgresAuthUserRepository) ServiceNamesByCertIdentity(
+	ctx context.Context,
+	identities []string,
+) ([]string, error) {
+
+	const query = `
+		SELECT DISTINCT service_name
+		FROM service_client_certs
+		WHERE identity = ANY($1)
+		ORDER BY service_name
+	`
+
+	rows, err := r.db.Query(ctx, query, nonNil(identities))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// Service Client Key
+func (r *PostgresAuthUserRepository) SaveServiceClientKey(
+	ctx context.Context,
+	key *domain.ServiceClientKey,
+) error {
+
+	const query = `
+		INSERT INTO service_client_keys
+		(key_id, service_name, key_hash, signing_key, expires_at, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6)
+	`
+
+	_, err := r.db.Exec(
+		ctx,
+		query,
+		key.KeyID,
+		key.ServiceName,
+		key.KeyHash,
+		key.SigningKey,
+		key.ExpiresAt,
+		key.CreatedAt,
+	)
+	return err
+}
+
+const serviceClientKeyColumns = `key_id, service_name, key_hash, signing_key, expires_at, revoked, created_at, last_used_at`
+
+func scanServiceClientKey(row pgx.Row) (*domain.ServiceClientKey, error) {
+	var k domain.ServiceClientKey
+	err := row.Scan(
+		&k.KeyID,
+		&k.ServiceName,
+		&k.KeyHash,
+		&k.SigningKey,
+		&k.ExpiresAt,
+		&k.Revoked,
+		&k.CreatedAt,
+		&k.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *PostgresAuthUserRepository) FindServiceClientKey(
+	ctx context.Context,
+	keyID string,
+) (*domain.ServiceClientKey, error) {
+
+	query := `SELECT ` + serviceClientKeyColumns + `
+		FROM service_client_keys
+		WHERE key_id = $1
+	`
+
+	k, err := scanServiceClientKey(r.db.QueryRow(ctx, query, keyID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (r *PostgresAuthUserRepository) ListServiceClientKeys(
+	ctx context.Context,
+	serviceName string,
+) ([]domain.ServiceClientKey, error) {
+
+	query := `SELECT ` + serviceClientKeyColumns + `
+		FROM service_client_keys
+		WHERE service_name = $1
+		ORDER BY created_at, key_id
+	`
+
+	rows, err := r.db.Query(ctx, query, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.ServiceClientKey
+	for rows.Next() {
+		k, err := scanServiceClientKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *k)
+	}
+	return result, rows.Err()
+}
+
+func (r *PostgresAuthUserRepository) RevokeServiceClientKey(ctx context.Context, keyID string) (bool, error) {
+	const q = `
+		UPDATE service_client_keys
+		SET revoked = TRUE, revoked_at = NOW()
+		WHERE key_id = $1 AND revoked = FALSE
+	`
+	tag, err := r.db.Exec(ctx, q, keyID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// TouchServiceClientKey records a use of the key, at most once a minute.
+func (r *PostgresAuthUserRepository) TouchServiceClientKey(ctx context.Context, keyID string, at time.Time) error {
+	const q = `
+		UPDATE service_client_keys
+		SET last_used_at = $2
+		WHERE key_id = $1
+		  AND (last_used_at IS NULL OR last_used_at < $2 - INTERVAL '1 minute')
+	`
+	_, err := r.db.Exec(ctx, q, keyID, at)
+	return err
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"central-auth/internal/domain"
+)
+
+// Session Outbox
+//
+// Revocations insert their outbox rows in the same statement that updates
+// refresh_tokens (see RevokeDevice and friends), so Postgres always knows
+// which Redis sessions still have to go.
+
+// ClaimSessionOutbox leases up to limit pending events for lease. A worker
+// that dies mid-batch leaves them to be picked up again after the lease.
+func (r *PostgresAuthUserRepository) ClaimSessionOutbox(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) ([]domain.SessionOutboxEvent, error) {
+
+	const q = `
+		WITH claimed AS (
+			UPDATE session_outbox
+			SET next_attempt_at = NOW() + make_interval(secs => $2)
+			WHERE id IN (
+				SELECT id FROM session_outbox
+				WHERE processed_at IS NULL AND next_attempt_at <= NOW()
+				ORDER BY id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, tenant_id, user_id, device_id, attempts, created_at
+		)
+		SELECT c.id, c.tenant_id, c.user_id, c.device_id, c.attempts, c.created_at,
+		       COALESCE(rt.revoked, true)
+		FROM claimed c
+		LEFT JOIN refresh_tokens rt
+		  ON rt.tenant_id = c.tenant_id
+		 AND rt.user_id = c.user_id
+		 AND rt.device_id = c.device_id
+		ORDER BY c.id
+	`
+
+	rows, err := r.db.Query(ctx, q, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []domain.SessionOutboxEvent
+	for rows.Next() {
+		var ev domain.SessionOutboxEvent
+		if err := rows.Scan(
+			&ev.ID,
+			&ev.TenantID,
+			&ev.UserID,
+			&ev.DeviceID,
+			&ev.Attempts,
+			&ev.CreatedAt,
+			&ev.Revoked,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// CompleteSessionOutbox marks the pending events of the given devices as
+// applied. Used both by the worker and by requests that already updated
+// Redis themselves.
+func (r *PostgresAuthUserRepository) CompleteSessionOutbox(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	deviceIDs []string,
+) error {
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+	const q = `
+		UPDATE session_outbox
+		SET processed_at = NOW(), last_error = NULL
+		WHERE tenant_id = $1 AND user_id = $2 AND device_id = ANY($3)
+		  AND processed_at IS NULL
+	`
+	_, err := r.db.Exec(ctx, q, tenantID, userID, deviceIDs)
+	return err
+}
+
+// FailSessionOutbox records a failed attempt and schedules the next one.
+func (r *PostgresAuthUserRepository) FailSessionOutbox(
+	ctx context.Context,
+	id int64,
+	lastError string,
+	retryAt time.Time,
+) error {
+	const q = `
+		UPDATE session_outbox
+		SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+		WHERE id = $1
+	`
+	_, err := r.db.Exec(ctx, q, id, lastError, retryAt)
+	return err
+}
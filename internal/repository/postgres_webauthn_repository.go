@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"central-auth/internal/domain"
+)
+
+// WebAuthn Credential
+func (r *PostgresAuthUserRepository) SaveWebAuthnCredential(
+	ctx context.Context,
+	cred *domain.WebAuthnCredential,
+) error {
+
+	const query = `
+		INSERT INTO webauthn_credentials
+		(tenant_id, user_id, credential_id, public_key, attestation_type, aaguid,
+		 sign_count, clone_warning, transports, flags, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+	`
+
+	_, err := r.db.Exec(
+		ctx,
+		query,
+		cred.TenantID,
+		cred.UserID,
+		cred.CredentialID,
+		cred.PublicKey,
+		cred.AttestationType,
+		cred.AAGUID,
+		int64(cred.SignCount),
+		cred.CloneWarning,
+		cred.Transports,
+		int16(cred.Flags),
+		cred.CreatedAt,
+	)
+	return err
+}
+
+const webauthnColumns = `
+	tenant_id, user_id, credential_id, public_key, attestation_type, aaguid,
+	sign_count, clone_warning, transports, flags, created_at, last_used_at
+`
+
+func scanWebAuthnCredential(row pgx.Row) (*domain.WebAuthnCredential, error) {
+	var (
+		c         domain.WebAuthnCredential
+		signCount int64
+		flags     int16
+	)
+	err := row.Scan(
+		&c.TenantID,
+		&c.UserID,
+		&c.CredentialID,
+		&c.PublicKey,
+		&c.AttestationType,
+		&c.AAGUID,
+		&signCount,
+		&c.CloneWarning,
+		&c.Transports,
+		&flags,
+		&c.CreatedAt,
+		&c.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.SignCount = uint32(signCount)
+	c.Flags = uint8(flags)
+	return &c, nil
+}
+
+func (r *PostgresAuthUserRepository) GetWebAuthnCredentials(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+) ([]domain.WebAuthnCredential, error) {
+
+	query := `SELECT ` + webauthnColumns + `
+		FROM webauthn_credentials
+		WHERE tenant_id = $1 AND user_id = $2
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.WebAuthnCredential
+	for rows.Next() {
+		c, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *c)
+	}
+	return result, rows.Err()
+}
+
+func (r *PostgresAuthUserRepository) FindWebAuthnCredential(
+	ctx context.Context,
+	tenantID string,
+	credentialID []byte,
+) (*domain.WebAuthnCredential, error) {
+
+	query := `SELECT ` + webauthnColumns + `
+		FROM webauthn_credentials
+		WHERE tenant_id = $1 AND credential_id = $2
+	`
+
+	c, err := scanWebAuthnCredential(r.db.QueryRow(ctx, query, tenantID, credentialID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (r *PostgresAuthUserRepository) UpdateWebAuthnSignCount(
+	ctx context.Context,
+	credentialID []byte,
+	signCount uint32,
+	cloneWarning bool,
+) error {
+	const q = `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, clone_warning = $3, last_used_at = NOW()
+		WHERE credential_id = $1
+	`
+	_, err := r.db.Exec(ctx, q, credentialID, int64(signCount), cloneWarning)
+	return err
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"central-auth/internal/domain"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// legacyRedisPatterns match the keys written before the user and phone
+// hash tags; webauthn and otp challenges expire within minutes and are left alone.
+var legacyRedisPatterns = []string{
+	"auth:devices:*",
+	"auth:refresh:*",
+	"auth:otp:cooldown:*",
+	"auth:otp:rate:*",
+}
+
+// taggedKey maps a key of the old layout to the hash tagged one, ok is false
+// for keys that already use the new layout. The old layout predates tenants,
+// its sessions belong to the default tenant.
+func taggedKey(key string) (string, bool) {
+	untagged := func(rest string) bool { return rest != "" && rest[0] != '{' }
+
+	if rest, ok := strings.CutPrefix(key, "auth:devices:seen:"); ok {
+		return seenKey(domain.DefaultTenant, rest), untagged(rest)
+	}
+	if rest, ok := strings.CutPrefix(key, "auth:devices:"); ok {
+		return devicesKey(domain.DefaultTenant, rest), untagged(rest)
+	}
+	if rest, ok := strings.CutPrefix(key, "auth:refresh:"); ok {
+		userID, deviceID, found := strings.Cut(rest, ":")
+		return refreshKey(domain.DefaultTenant, userID, deviceID), found && untagged(rest)
+	}
+	if rest, ok := strings.CutPrefix(key, "auth:otp:cooldown:"); ok {
+		return otpCooldownKey(rest), untagged(rest)
+	}
+	if rest, ok := strings.CutPrefix(key, "auth:otp:rate:"); ok {
+		return otpRateKey(rest), untagged(rest)
+	}
+	return "", false
+}
+
+// MigrateRedisKeys moves session keys of the old layout (auth:devices:user,
+// auth:refresh:user:device, ...) to the hash tagged one, keeping their TTLs.
+// Keys are copied instead of renamed because old and new key may live in
+// different cluster slots. A new key that already exists wins, the old one
+// is dropped. It returns how many keys were moved.
+//
+// Run it once while no server writes the old layout; the session reconciler
+// repairs whatever is left.
+func MigrateRedisKeys(ctx context.Context, client redis.UniversalClient) (int, error) {
+	var (
+		mu    sync.Mutex
+		moved int
+	)
+	migrate := func(ctx context.Context, node redis.UniversalClient) error {
+		for _, pattern := range legacyRedisPatterns {
+			iter := node.Scan(ctx, 0, pattern, 500).Iterator()
+			for iter.Next(ctx) {
+				key := iter.Val()
+				newKey, ok := taggedKey(key)
+				if !ok {
+					continue
+				}
+				ok, err := moveKey(ctx, client, key, newKey)
+				if err != nil {
+					return err
+				}
+				if ok {
+					mu.Lock()
+					moved++
+					mu.Unlock()
+				}
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return migrate(ctx, node)
+		})
+	} else {
+		err = migrate(ctx, client)
+	}
+	return moved, err
+}
+
+// moveKey copies key to newKey with its remaining TTL and deletes key.
+// Only the types this store writes (strings and sorted sets) are copied.
+func moveKey(ctx context.Context, client redis.UniversalClient, key, newKey string) (bool, error) {
+	exists, err := client.Exists(ctx, newKey).Result()
+	if err != nil {
+		return false, err
+	}
+	if exists > 0 {
+		return false, client.Del(ctx, key).Err()
+	}
+
+	ttl, err := client.PTTL(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if ttl == -2 {
+		return false, nil // expired meanwhile
+	}
+	if ttl < 0 {
+		ttl = 0 // no expiry
+	}
+
+	kind, err := client.Type(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	switch kind {
+	case "string":
+		val, err := client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := client.Set(ctx, newKey, val, ttl).Err(); err != nil {
+			return false, err
+		}
+	case "zset":
+		members, err := client.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil {
+			return false, err
+		}
+		if len(members) == 0 {
+			return false, nil
+		}
+		if _, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, newKey, members...)
+			if ttl > 0 {
+				pipe.PExpire(ctx, newKey, ttl)
+			}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+	case "none":
+		return false, nil
+	default:
+		return false, fmt.Errorf("migrate %s: unexpected type %s", key, kind)
+	}
+	return true, client.Del(ctx, key).Err()
+}
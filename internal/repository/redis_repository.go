@@ -1,128 +1,509 @@
 package repository
 
 import (
-	"central-auth/internal/config"
+	"context"
+	"sync"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+	_ "embed"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-const MaxDevices int64 = 5
+// DeviceLimitError is returned by SaveLogin when the policy refuses to make
+// room for a new device (reject / require_choice).
+type DeviceLimitError struct {
+	Policy    policy.DevicePolicy
+	DeviceIDs []string // current devices, oldest login first
+}
+
+func (e *DeviceLimitError) Error() string {
+	return "device limit reached"
+}
 
+// RedisRepository works with a single node, Sentinel or Cluster client.
+// TTLs run on the Redis server clock, login and refresh scores on clk.
 type RedisRepository struct {
-	client *redis.Client
+	client redis.UniversalClient
+	clock  clock.Clock
+}
+
+func NewRedisRepository(client redis.UniversalClient, clk clock.Clock) SessionStore {
+	return &RedisRepository{client: client, clock: clk}
+}
+
+// auth:devices:{user} scores devices by login time,
+// auth:devices:seen:{user} by last login or refresh (for LRU eviction).
+//
+// The user id is a hash tag ({...}), so every key of one user lands in the
+// same cluster slot and the session scripts can touch them together.
+// Keys written before the hash tags are moved by MigrateRedisKeys.
+//
+// Session keys of tenants other than the default one start with
+// auth:t:<tenant>: instead of auth:, the default tenant keeps the layout
+// from before tenants.
+func keyPrefix(tenantID string) string {
+	if tenantID == domain.DefaultTenant {
+		return "auth:"
+	}
+	return "auth:t:" + tenantID + ":"
+}
+
+func devicesKey(tenantID, userID string) string {
+	return keyPrefix(tenantID) + "devices:{" + userID + "}"
 }
 
-func NewRedisRepository(client *redis.Client) *RedisRepository {
-	return &RedisRepository{client: client}
+func seenKey(tenantID, userID string) string {
+	return keyPrefix(tenantID) + "devices:seen:{" + userID + "}"
 }
 
-func devicesKey(userID string) string {
-	return "auth:devices:" + userID
+func refreshKey(tenantID, userID, deviceID string) string {
+	return refreshPrefix(tenantID, userID) + deviceID
 }
 
-func refreshKey(userID, deviceID string) string {
-	return "auth:refresh:" + userID + ":" + deviceID
+func refreshPrefix(tenantID, userID string) string {
+	return keyPrefix(tenantID) + "refresh:{" + userID + "}:"
 }
 
+// Session writes are server-side Lua scripts so that concurrent logins,
+// refreshes and logouts of one user can not interleave.
+var (
+	//go:embed lua/save_login.lua
+	saveLoginLua    string
+	saveLoginScript = redis.NewScript(saveLoginLua)
+
+	//go:embed lua/evict.lua
+	evictLua    string
+	evictScript = redis.NewScript(evictLua)
+
+	//go:embed lua/refresh.lua
+	refreshLua    string
+	refreshScript = redis.NewScript(refreshLua)
+
+	//go:embed lua/restore.lua
+	restoreLua    string
+	restoreScript = redis.NewScript(restoreLua)
 
-func (r *RedisRepository) SaveLogin(userID, deviceID, refreshToken string, ttl time.Duration) error {
-	ctx := config.Ctx
+	//go:embed lua/replace.lua
+	replaceLua    string
+	replaceScript = redis.NewScript(replaceLua)
 
-	now := float64(time.Now().Unix())
-	dKey := devicesKey(userID)
+	//go:embed lua/otp_attempt.lua
+	otpAttemptLua    string
+	otpAttemptScript = redis.NewScript(otpAttemptLua)
+)
+
+var ErrReplaceDeviceNotFound = errors.New("replace_device_id is not an active device")
+
+// ErrOTPChallengeNotFound: the challenge expired or was used before the
+// attempt was counted.
+var ErrOTPChallengeNotFound = errors.New("otp challenge not found")
+
+// SaveLogin stores the session of deviceID and applies the device policy.
+// Sessions hold the refresh token hash, the same value as refresh_tokens, so
+// they can be rebuilt from Postgres.
+// replaceDeviceID is the device the user chose to give up (require_choice).
+// Returns the devices evicted to make room.
+func (r *RedisRepository) SaveLogin(
+	ctx context.Context,
+	tenantID, userID, deviceID, tokenHash string,
+	ttl time.Duration,
+	p policy.DevicePolicy,
+	replaceDeviceID string,
+) ([]string, error) {
+	res, err := saveLoginScript.Run(ctx, r.client,
+		[]string{devicesKey(tenantID, userID), seenKey(tenantID, userID)},
+		refreshPrefix(tenantID, userID),
+		deviceID,
+		tokenHash,
+		ttl.Milliseconds(),
+		r.clock.Now().Unix(),
+		p.MaxDevices,
+		p.Strategy,
+		replaceDeviceID,
+	).Slice()
+	if err != nil {
+		if strings.Contains(err.Error(), "replace_device_not_found") {
+			return nil, ErrReplaceDeviceNotFound
+		}
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, errors.New("unexpected save_login reply")
+	}
 
-	// checking deviceID
-	count, err := r.client.ZCard(ctx, dKey).Result()
+	ids, err := toStrings(res[1:])
 	if err != nil {
-		return err
+		return nil, err
 	}
-	existsScore, err := r.client.ZScore(ctx, dKey, deviceID).Result()
-	if err != nil && err != redis.Nil {
-		return err
+	if status, _ := res[0].(int64); status == 0 {
+		return nil, &DeviceLimitError{Policy: p, DeviceIDs: ids}
 	}
+	return ids, nil
+}
 
-	isExistingDevice := (err == nil && existsScore != 0) || (err == nil) // ZScore returns nil err if exists
+// RefreshSession checks that tokenHash is the current token of the device,
+// sets the session's remaining lifetime to ttl (sliding idle timeout) and
+// marks the device as used for LRU eviction.
+func (r *RedisRepository) RefreshSession(ctx context.Context, tenantID, userID, deviceID, tokenHash string, ttl time.Duration) (bool, error) {
+	ok, err := refreshScript.Run(ctx, r.client,
+		[]string{refreshKey(tenantID, userID, deviceID), devicesKey(tenantID, userID), seenKey(tenantID, userID)},
+		deviceID,
+		tokenHash,
+		r.clock.Now().Unix(),
+		ttl.Milliseconds(),
+	).Int()
+	return ok == 1, err
+}
 
-	if !isExistingDevice && count >= MaxDevices {
-		oldest, err := r.client.ZRangeWithScores(ctx, dKey, 0, 0).Result()
-		if err != nil {
-			return err
+// SessionTTL returns how long the device session lives without a refresh,
+// false when there is no session.
+func (r *RedisRepository) SessionTTL(ctx context.Context, tenantID, userID, deviceID string) (time.Duration, bool, error) {
+	ttl, err := r.client.PTTL(ctx, refreshKey(tenantID, userID, deviceID)).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	// go-redis passes -2 (no key) and -1 (no expiry) through unscaled
+	if ttl == -2 {
+		return 0, false, nil
+	}
+	return ttl, true, nil
+}
+
+// SessionTTLs is SessionTTL for several devices of a user in one round
+// trip; the keys share the user's hash tag. Devices without a session are
+// left out.
+func (r *RedisRepository) SessionTTLs(ctx context.Context, tenantID, userID string, deviceIDs []string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration, len(deviceIDs))
+	if len(deviceIDs) == 0 {
+		return ttls, nil
+	}
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.DurationCmd, len(deviceIDs))
+	for i, id := range deviceIDs {
+		cmds[i] = pipe.PTTL(ctx, refreshKey(tenantID, userID, id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		// -2: no key
+		if ttl := cmd.Val(); ttl != -2 {
+			ttls[deviceIDs[i]] = ttl
 		}
-		if len(oldest) == 0 {
-			return errors.New("device set empty unexpectedly")
+	}
+	return ttls, nil
+}
+
+func (r *RedisRepository) evict(ctx context.Context, tenantID, userID, mode string, deviceIDs []string) ([]string, error) {
+	args := make([]any, 0, len(deviceIDs)+2)
+	args = append(args, refreshPrefix(tenantID, userID), mode)
+	for _, id := range deviceIDs {
+		args = append(args, id)
+	}
+
+	res, err := evictScript.Run(ctx, r.client,
+		[]string{devicesKey(tenantID, userID), seenKey(tenantID, userID)},
+		args...,
+	).Slice()
+	if err != nil {
+		return nil, err
+	}
+	return toStrings(res)
+}
+
+func toStrings(vals []any) ([]string, error) {
+	out := make([]string, 0, len(vals))
+	for _, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("invalid member type in script reply")
 		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (r *RedisRepository) ExistsRefreshToken(ctx context.Context, tenantID, userID, deviceID string) (bool, error) {
+	cnt, err := r.client.Exists(ctx, refreshKey(tenantID, userID, deviceID)).Result()
+
+	if err != nil {
+		return false, err
+	}
+	return cnt == 1, nil
+}
+
+// ReplaceRefreshToken swaps the stored refresh token hash of a session from
+// oldHash to newHash keeping its TTL. Returns false if the session no longer
+// exists or holds another token.
+func (r *RedisRepository) ReplaceRefreshToken(ctx context.Context, tenantID, userID, deviceID, oldHash, newHash string) (bool, error) {
+	ok, err := replaceScript.Run(ctx, r.client,
+		[]string{refreshKey(tenantID, userID, deviceID)},
+		oldHash,
+		newHash,
+	).Int()
+	return ok == 1, err
+}
+
+// GetDevices returns the devices in auth:devices with their login time.
+func (r *RedisRepository) GetDevices(ctx context.Context, tenantID, userID string) (map[string]time.Time, error) {
+	zs, err := r.client.ZRangeWithScores(ctx, devicesKey(tenantID, userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
 
-		oldDeviceID, ok := oldest[0].Member.(string)
+	devices := make(map[string]time.Time, len(zs))
+	for _, z := range zs {
+		deviceID, ok := z.Member.(string)
 		if !ok {
-			return errors.New("invalid member type in zset")
+			return nil, errors.New("invalid member type in zset")
 		}
+		devices[deviceID] = time.Unix(int64(z.Score), 0)
+	}
+	return devices, nil
+}
 
-		// delete oldest deviceID and refreshToken
-		pipe := r.client.TxPipeline()
-		pipe.ZRem(ctx, dKey, oldDeviceID)
-		pipe.Del(ctx, refreshKey(userID, oldDeviceID))
-		if _, err := pipe.Exec(ctx); err != nil {
-			return err
+func (r *RedisRepository) LogoutDevice(ctx context.Context, tenantID, userID, deviceID string) error {
+	_, err := r.evict(ctx, tenantID, userID, "only", []string{deviceID})
+	return err
+}
+
+// LogoutOtherDevices removes every session of the user except keepDeviceID
+// and returns the removed device ids.
+func (r *RedisRepository) LogoutOtherDevices(ctx context.Context, tenantID, userID, keepDeviceID string) ([]string, error) {
+	return r.evict(ctx, tenantID, userID, "except", []string{keepDeviceID})
+}
+
+func (r *RedisRepository) LogoutAll(ctx context.Context, tenantID, userID string) error {
+	_, err := r.evict(ctx, tenantID, userID, "except", nil)
+	return err
+}
+
+// Restore results
+const (
+	RestoreUnchanged int64 = iota
+	RestoreCreated
+	RestoreHashFixed
+	RestoreIndexFixed
+)
+
+// RestoreSession writes a session known to Postgres back into Redis. A
+// missing key gets ttl, an existing one keeps its TTL. Devices that logged
+// in less than grace ago keep their hash, Postgres may still be behind.
+// Returns one of the Restore* results.
+func (r *RedisRepository) RestoreSession(
+	ctx context.Context,
+	tenantID, userID, deviceID, tokenHash string,
+	loginAt, lastUsedAt time.Time,
+	ttl time.Duration,
+	grace time.Duration,
+) (int64, error) {
+	return restoreScript.Run(ctx, r.client,
+		[]string{refreshKey(tenantID, userID, deviceID), devicesKey(tenantID, userID), seenKey(tenantID, userID)},
+		deviceID,
+		tokenHash,
+		loginAt.Unix(),
+		lastUsedAt.Unix(),
+		ttl.Milliseconds(),
+		r.clock.Now().Add(-grace).Unix(),
+	).Int64()
+}
+
+// SessionKey names one auth:refresh key.
+type SessionKey struct {
+	TenantID string
+	UserID   string
+	DeviceID string
+}
+
+// parseRefreshKey splits auth:refresh:{user}:device and
+// auth:t:tenant:refresh:{user}:device.
+func parseRefreshKey(key string) (SessionKey, bool) {
+	tenantID := domain.DefaultTenant
+	rest, ok := strings.CutPrefix(key, "auth:t:")
+	if ok {
+		tenantID, rest, ok = strings.Cut(rest, ":")
+		if !ok || tenantID == domain.DefaultTenant {
+			return SessionKey{}, false
 		}
+	} else {
+		rest = strings.TrimPrefix(key, "auth:")
+	}
+	rest, ok = strings.CutPrefix(rest, "refresh:{")
+	if !ok {
+		return SessionKey{}, false
+	}
+	userID, deviceID, ok := strings.Cut(rest, "}:")
+	if !ok {
+		return SessionKey{}, false
 	}
+	return SessionKey{TenantID: tenantID, UserID: userID, DeviceID: deviceID}, true
+}
 
-	if err := r.client.ZAdd(ctx, dKey, redis.Z{Score: now, Member: deviceID}).Err(); err != nil {
-		return err
+// EachSession walks the auth:refresh keys of all tenants with SCAN and calls
+// fn for every batch of about batch keys. On a cluster every master is
+// scanned.
+func (r *RedisRepository) EachSession(ctx context.Context, batch int64, fn func([]SessionKey) error) error {
+	scan := func(client redis.UniversalClient, match string) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, match, batch).Result()
+			if err != nil {
+				return err
+			}
+			sessions := make([]SessionKey, 0, len(keys))
+			for _, key := range keys {
+				if k, ok := parseRefreshKey(key); ok {
+					sessions = append(sessions, k)
+				}
+			}
+			if len(sessions) > 0 {
+				if err := fn(sessions); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
 	}
 
-	if err := r.client.Set(ctx, refreshKey(userID, deviceID), refreshToken, ttl).Err(); err != nil {
-		return err
+	scanAll := func(client redis.UniversalClient) error {
+		if err := scan(client, "auth:refresh:{*"); err != nil {
+			return err
+		}
+		return scan(client, "auth:t:*:refresh:{*")
 	}
 
-	if err := r.client.Expire(ctx, dKey, ttl).Err(); err != nil {
-		return err
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		// fn is not safe for concurrent use, scan the masters one by one
+		var mu sync.Mutex
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return scanAll(node)
+		})
 	}
+	return scanAll(r.client)
+}
 
-	return nil
+func webauthnSessionKey(sessionID string) string {
+	return "auth:webauthn:" + sessionID
 }
 
-func (r *RedisRepository) ExistsRefreshToken(userID, deviceID string) (bool, error) {
-	ctx := config.Ctx
-	key := "auth:refresh:" + userID + ":" + deviceID
-	cnt, err := r.client.Exists(ctx, key).Result()
+// SaveWebAuthnSession stores the ceremony state (challenge etc.) until the
+// client posts the authenticator response back.
+func (r *RedisRepository) SaveWebAuthnSession(ctx context.Context, sessionID string, data []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, webauthnSessionKey(sessionID), data, ttl).Err()
+}
 
-	if err != nil {
-		return false, err
+// TakeWebAuthnSession returns and deletes the ceremony state so a challenge
+// can only be answered once. Returns nil when missing or expired.
+func (r *RedisRepository) TakeWebAuthnSession(ctx context.Context, sessionID string) ([]byte, error) {
+	data, err := r.client.GetDel(ctx, webauthnSessionKey(sessionID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
 	}
-	return cnt == 1, nil
+	return data, err
+}
+
+func otpChallengeKey(otpID string) string {
+	return "auth:otp:sms:" + otpID
+}
+
+func otpCooldownKey(phone string) string {
+	return "auth:otp:cooldown:{" + phone + "}"
+}
+
+func otpRateKey(phone string) string {
+	return "auth:otp:rate:{" + phone + "}"
 }
 
-func (r *RedisRepository) LogoutDevice(userID, deviceID string) error {
-	ctx := config.Ctx
-	dKey := devicesKey(userID)
-	rKey := refreshKey(userID, deviceID)
+// AllowOTPSend applies the per-number limits: one message per cooldown and
+// at most maxPerWindow messages per window.
+func (r *RedisRepository) AllowOTPSend(ctx context.Context, phone string, cooldown time.Duration, window time.Duration, maxPerWindow int64) (bool, error) {
+	ok, err := r.client.SetNX(ctx, otpCooldownKey(phone), 1, cooldown).Result()
+	if err != nil || !ok {
+		return false, err
+	}
 
 	pipe := r.client.TxPipeline()
-	pipe.Del(ctx, rKey)
-	pipe.ZRem(ctx, dKey, deviceID)
+	incr := pipe.Incr(ctx, otpRateKey(phone))
+	pipe.ExpireNX(ctx, otpRateKey(phone), window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return incr.Val() <= maxPerWindow, nil
+}
+
+func (r *RedisRepository) SaveOTPChallenge(ctx context.Context, ch *domain.OTPChallenge, ttl time.Duration) error {
+	key := otpChallengeKey(ch.ID)
 
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key,
+		"purpose", ch.Purpose,
+		"tenant_id", ch.TenantID,
+		"phone", ch.Phone,
+		"user_id", ch.UserID,
+		"code_hash", ch.CodeHash,
+		"attempts", 0,
+	)
+	pipe.Expire(ctx, key, ttl)
 	_, err := pipe.Exec(ctx)
 	return err
 }
 
-func (r *RedisRepository) LogoutAll(userID string) error {
-	ctx := config.Ctx
-	dKey := devicesKey(userID)
-
-	devicesIDs, err := r.client.ZRange(ctx, dKey, 0, -1).Result()
+// GetOTPChallenge returns nil when the challenge expired or was used.
+func (r *RedisRepository) GetOTPChallenge(ctx context.Context, otpID string) (*domain.OTPChallenge, error) {
+	vals, err := r.client.HGetAll(ctx, otpChallengeKey(otpID)).Result()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
 	}
 
-	pipe := r.client.TxPipeline()
+	attempts, _ := strconv.ParseInt(vals["attempts"], 10, 64)
+	return &domain.OTPChallenge{
+		ID:       otpID,
+		TenantID: vals["tenant_id"],
+		Purpose:  vals["purpose"],
+		Phone:    vals["phone"],
+		UserID:   vals["user_id"],
+		CodeHash: vals["code_hash"],
+		Attempts: attempts,
+	}, nil
+}
 
-	for _, deviceID := range devicesIDs {
-		pipe.Del(ctx, refreshKey(userID, deviceID))
+func (r *RedisRepository) IncrOTPAttempts(ctx context.Context, otpID string) (int64, error) {
+	n, err := otpAttemptScript.Run(ctx, r.client, []string{otpChallengeKey(otpID)}).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, ErrOTPChallengeNotFound
 	}
+	return n, nil
+}
 
-	pipe.Del(ctx, dKey)
-	_, err = pipe.Exec(ctx)
-	return err
-}
\ No newline at end of file
+func (r *RedisRepository) DeleteOTPChallenge(ctx context.Context, otpID string) error {
+	return r.client.Del(ctx, otpChallengeKey(otpID)).Err()
+}
+
+func nonceKey(serviceName, nonce string) string {
+	return "auth:nonce:" + serviceName + ":" + nonce
+}
+
+// ClaimNonce records the nonce of a signed request for ttl. False when the
+// service already used it, the request is a replay.
+func (r *RedisRepository) ClaimNonce(ctx context.Context, serviceName, nonce string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, nonceKey(serviceName, nonce), 1, ttl).Result()
+}
@@ -0,0 +1,28 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/repository"
+	"central-auth/internal/repository/redistest"
+	"central-auth/internal/repository/repotest"
+)
+
+func TestRedisSessionStoreContract(t *testing.T) {
+	repotest.RunSessionStoreSuite(t, func(t *testing.T) (repository.SessionStore, func(time.Duration)) {
+		client, mr := redistest.NewClient(t)
+		clk := clock.NewFake(time.Now())
+		return repository.NewRedisRepository(client, clk), func(d time.Duration) {
+			clk.Advance(d)
+			mr.FastForward(d)
+		}
+	})
+}
+
+func TestLocalRedisSessionStoreContract(t *testing.T) {
+	repotest.RunSessionStoreSuite(t, func(t *testing.T) (repository.SessionStore, func(time.Duration)) {
+		return repository.NewRedisRepository(redistest.NewLocalClient(t), clock.Real{}), time.Sleep
+	})
+}
@@ -0,0 +1,29 @@
+package redistest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewLocalClient connects to the Redis server in REDIS_TEST_URL (for example
+// redis://localhost:6379/15) and empties its database. The test is skipped
+// when the variable is not set. Never point it at a database in use.
+func NewLocalClient(t testing.TB) *redis.Client {
+	t.Helper()
+	url := os.Getenv("REDIS_TEST_URL")
+	if url == "" {
+		t.Skip("REDIS_TEST_URL not set")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(opts)
+	t.Cleanup(func() { client.Close() })
+	if err := client.FlushDB(t.Context()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
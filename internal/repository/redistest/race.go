@@ -0,0 +1,264 @@
+// Package redistest checks the session scripts of RedisRepository under
+// concurrency against miniredis, an in-process Redis stand-in.
+//
+// RunRaceSuite runs with the tests of this package against miniredis, call
+// it from a test to run it elsewhere:
+//
+//	func TestRedisSessionRaces(t *testing.T) {
+//		redistest.RunRaceSuite(t)
+//	}
+package redistest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const workers = 32
+
+var ctx = context.Background()
+
+// NewClient starts a miniredis server that lives as long as the test.
+func NewClient(t testing.TB) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client, mr
+}
+
+func RunRaceSuite(t *testing.T) {
+	t.Run("ConcurrentLoginsNeverExceedLimit", concurrentLoginsNeverExceedLimit)
+	t.Run("ConcurrentLoginsSameDevice", concurrentLoginsSameDevice)
+	t.Run("RejectUnderConcurrency", rejectUnderConcurrency)
+	t.Run("LoginRacingLogoutAll", loginRacingLogoutAll)
+	t.Run("ShortSessionKeepsLongDeviceSet", shortSessionKeepsLongDeviceSet)
+	t.Run("RefreshRejectsReplacedToken", refreshRejectsReplacedToken)
+}
+
+func concurrentLoginsNeverExceedLimit(t *testing.T) {
+	client, mr := NewClient(t)
+	repo := repository.NewRedisRepository(client, clock.Real{})
+	p := policy.DevicePolicy{MaxDevices: 5, Strategy: policy.EvictOldest}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		evicted = map[string]int{}
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			device := fmt.Sprintf("device-%d", i)
+			ev, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", device, "token-"+device, time.Hour, p, "")
+			if err != nil {
+				t.Errorf("SaveLogin %s: %v", device, err)
+				return
+			}
+			mu.Lock()
+			for _, id := range ev {
+				evicted[id]++
+			}
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	members, err := mr.ZMembers("auth:devices:{u1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 5 {
+		t.Fatalf("devices = %d, want 5", len(members))
+	}
+	for _, id := range members {
+		if !mr.Exists("auth:refresh:{u1}:" + id) {
+			t.Errorf("device %s has no refresh key", id)
+		}
+		if evicted[id] != 0 {
+			t.Errorf("device %s is active but was reported evicted", id)
+		}
+	}
+	if len(evicted) != workers-5 {
+		t.Errorf("evicted = %d, want %d", len(evicted), workers-5)
+	}
+	for id, n := range evicted {
+		if n != 1 {
+			t.Errorf("device %s evicted %d times", id, n)
+		}
+		if mr.Exists("auth:refresh:{u1}:" + id) {
+			t.Errorf("evicted device %s still has a refresh key", id)
+		}
+	}
+}
+
+func concurrentLoginsSameDevice(t *testing.T) {
+	client, mr := NewClient(t)
+	repo := repository.NewRedisRepository(client, clock.Real{})
+	p := policy.DevicePolicy{MaxDevices: 2, Strategy: policy.EvictOldest}
+
+	if _, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", "other", "t", time.Hour, p, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ev, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", "phone", fmt.Sprintf("t-%d", i), time.Hour, p, "")
+			if err != nil {
+				t.Errorf("SaveLogin: %v", err)
+			}
+			if len(ev) != 0 {
+				t.Errorf("re-login of the same device evicted %v", ev)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	members, _ := mr.ZMembers("auth:devices:{u1}")
+	if len(members) != 2 {
+		t.Fatalf("devices = %v, want [other phone]", members)
+	}
+}
+
+func rejectUnderConcurrency(t *testing.T) {
+	client, mr := NewClient(t)
+	repo := repository.NewRedisRepository(client, clock.Real{})
+	p := policy.DevicePolicy{MaxDevices: 3, Strategy: policy.Reject}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", fmt.Sprintf("d-%d", i), "t", time.Hour, p, "")
+			if err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+				return
+			}
+			if _, ok := err.(*repository.DeviceLimitError); !ok {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	members, _ := mr.ZMembers("auth:devices:{u1}")
+	if accepted != 3 || len(members) != 3 {
+		t.Fatalf("accepted = %d, devices = %d, want 3", accepted, len(members))
+	}
+}
+
+func loginRacingLogoutAll(t *testing.T) {
+	client, mr := NewClient(t)
+	repo := repository.NewRedisRepository(client, clock.Real{})
+	p := policy.DevicePolicy{MaxDevices: 5, Strategy: policy.EvictOldest}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", fmt.Sprintf("d-%d", i%7), "t", time.Hour, p, ""); err != nil {
+				t.Errorf("SaveLogin: %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if err := repo.LogoutAll(ctx, domain.DefaultTenant, "u1"); err != nil {
+				t.Errorf("LogoutAll: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// whatever the interleaving, the set and the refresh keys must agree
+	members, _ := mr.ZMembers("auth:devices:{u1}")
+	if len(members) > 5 {
+		t.Fatalf("devices = %d, want <= 5", len(members))
+	}
+	for _, key := range mr.Keys() {
+		var device string
+		if _, err := fmt.Sscanf(key, "auth:refresh:{u1}:%s", &device); err != nil {
+			continue
+		}
+		if score, err := mr.ZScore("auth:devices:{u1}", device); err != nil || score == 0 {
+			t.Errorf("refresh key %s without device entry", key)
+		}
+	}
+}
+
+func shortSessionKeepsLongDeviceSet(t *testing.T) {
+	client, mr := NewClient(t)
+	repo := repository.NewRedisRepository(client, clock.Real{})
+	p := policy.DevicePolicy{MaxDevices: 5, Strategy: policy.EvictOldest}
+
+	if _, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", "laptop", "t1", 30*24*time.Hour, p, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", "phone", "t2", 7*24*time.Hour, p, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := mr.TTL("auth:devices:{u1}"); ttl < 29*24*time.Hour {
+		t.Fatalf("device set ttl shortened to %v", ttl)
+	}
+
+	// the short session expires, the long one stays
+	mr.FastForward(8 * 24 * time.Hour)
+	if _, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", "tablet", "t3", time.Hour, p, ""); err != nil {
+		t.Fatal(err)
+	}
+	members, _ := mr.ZMembers("auth:devices:{u1}")
+	if len(members) != 2 {
+		t.Fatalf("devices = %v, want [laptop tablet]", members)
+	}
+}
+
+func refreshRejectsReplacedToken(t *testing.T) {
+	client, _ := NewClient(t)
+	repo := repository.NewRedisRepository(client, clock.Real{})
+	p := policy.DevicePolicy{MaxDevices: 5, Strategy: policy.EvictLRU}
+
+	if _, err := repo.SaveLogin(ctx, domain.DefaultTenant, "u1", "d1", "old", time.Hour, p, ""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := repo.ReplaceRefreshToken(ctx, domain.DefaultTenant, "u1", "d1", "old", "new"); err != nil || !ok {
+		t.Fatalf("ReplaceRefreshToken = %v, %v", ok, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := repo.RefreshSession(ctx, domain.DefaultTenant, "u1", "d1", "old", time.Hour); err != nil || ok {
+				t.Errorf("old token accepted: %v, %v", ok, err)
+			}
+			if ok, err := repo.RefreshSession(ctx, domain.DefaultTenant, "u1", "d1", "new", time.Hour); err != nil || !ok {
+				t.Errorf("current token rejected: %v, %v", ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
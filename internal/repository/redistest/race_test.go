@@ -0,0 +1,7 @@
+package redistest
+
+import "testing"
+
+func TestRedisSessionRaces(t *testing.T) {
+	RunRaceSuite(t)
+}
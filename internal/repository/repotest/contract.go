@@ -0,0 +1,625 @@
+// Package repotest is the behaviour every AuthUserRepository implementation
+// must share. Run it from a test with a constructor for a fresh, empty
+// repository:
+//
+//	func TestSQLiteContract(t *testing.T) {
+//		repotest.RunAuthUserRepositorySuite(t, newSQLiteRepo)
+//	}
+package repotest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/repository"
+)
+
+// NewRepo returns an empty repository that lives as long as the test.
+type NewRepo func(t *testing.T) repository.AuthUserRepository
+
+func RunAuthUserRepositorySuite(t *testing.T, newRepo NewRepo) {
+	cases := map[string]func(*testing.T, repository.AuthUserRepository){
+		"Users":                users,
+		"PhoneNumber":          phoneNumber,
+		"ReloginReplacesToken": reloginReplacesToken,
+		"LoginDevicesPaging":   loginDevicesPaging,
+		"RevokeDevice":         revokeDevice,
+		"RevokeOtherAndAll":    revokeOtherAndAll,
+		"ActiveSessions":       activeSessions,
+		"LastUsedAndHash":      lastUsedAndHash,
+		"OutboxLifecycle":      outboxLifecycle,
+		"ArchiveFinished":      archiveFinished,
+		"RunExclusive":         runExclusive,
+		"WebAuthnCredentials":  webauthnCredentials,
+		"ServiceClients":       serviceClients,
+		"Tenants":              tenants,
+	}
+	names := make([]string, 0, len(cases))
+	for name := range cases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fn := cases[name]
+		t.Run(name, func(t *testing.T) { fn(t, newRepo(t)) })
+	}
+}
+
+var ctx = context.Background()
+
+// tenant owns the data of the cases that are not about tenants.
+const tenant = domain.DefaultTenant
+
+func ptr[T any](v T) *T { return &v }
+
+// Session saves an active session of deviceID in the default tenant that
+// expires in ttl.
+func Session(t *testing.T, repo repository.AuthUserRepository, userID, deviceID string, issuedAt time.Time, ttl time.Duration) {
+	t.Helper()
+	tenantSession(t, repo, tenant, userID, deviceID, issuedAt, ttl)
+}
+
+func tenantSession(t *testing.T, repo repository.AuthUserRepository, tenantID, userID, deviceID string, issuedAt time.Time, ttl time.Duration) {
+	t.Helper()
+	err := repo.SaveRefreshToken(ctx, &domain.RefreshToken{
+		TenantID:  tenantID,
+		UserID:    userID,
+		DeviceID:  deviceID,
+		TokenHash: "hash-" + deviceID,
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(ttl),
+		UserAgent: ptr("agent"),
+		IP:        ptr("127.0.0.1"),
+		Service:   "default",
+	})
+	if err != nil {
+		t.Fatalf("SaveRefreshToken: %v", err)
+	}
+}
+
+func users(t *testing.T, repo repository.AuthUserRepository) {
+	u := &domain.AuthUser{TenantID: tenant, UserID: "u1", Provider: "google", ProviderID: "g-1", Email: "a@example.com"}
+	if err := repo.Save(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	// saving again is a no-op
+	if err := repo.Save(ctx, &domain.AuthUser{TenantID: tenant, UserID: "u1", Provider: "google", ProviderID: "g-1", Email: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.FindByProvider(ctx, tenant, "google", "g-1")
+	if err != nil || got == nil || got.UserID != "u1" || got.Email != "a@example.com" {
+		t.Fatalf("FindByProvider = %+v, %v", got, err)
+	}
+	got, err = repo.FindByUserID(ctx, tenant, "u1")
+	if err != nil || got == nil || got.ProviderID != "g-1" || got.PhoneNumber != nil {
+		t.Fatalf("FindByUserID = %+v, %v", got, err)
+	}
+
+	for name, find := range map[string]func() (*domain.AuthUser, error){
+		"FindByProvider": func() (*domain.AuthUser, error) { return repo.FindByProvider(ctx, tenant, "google", "missing") },
+		"FindByUserID":   func() (*domain.AuthUser, error) { return repo.FindByUserID(ctx, tenant, "missing") },
+		"FindByPhone":    func() (*domain.AuthUser, error) { return repo.FindByPhone(ctx, tenant, "+15550000000") },
+	} {
+		if got, err := find(); got != nil || err != nil {
+			t.Fatalf("%s(missing) = %+v, %v, want nil, nil", name, got, err)
+		}
+	}
+}
+
+func phoneNumber(t *testing.T, repo repository.AuthUserRepository) {
+	// unknown users get an "external" row
+	if err := repo.SetPhoneNumber(ctx, tenant, "u1", "+15551230001"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.FindByPhone(ctx, tenant, "+15551230001")
+	if err != nil || got == nil || got.UserID != "u1" || got.Provider != "external" {
+		t.Fatalf("FindByPhone = %+v, %v", got, err)
+	}
+
+	if err := repo.SetPhoneNumber(ctx, tenant, "u1", "+15551230002"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = repo.FindByUserID(ctx, tenant, "u1")
+	if got == nil || got.PhoneNumber == nil || *got.PhoneNumber != "+15551230002" {
+		t.Fatalf("phone not updated: %+v", got)
+	}
+
+	// a number belongs to one user
+	if err := repo.SetPhoneNumber(ctx, tenant, "u2", "+15551230002"); err == nil {
+		t.Fatal("duplicate phone number accepted")
+	}
+}
+
+func reloginReplacesToken(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now()
+	Session(t, repo, "u1", "d1", now.Add(-time.Hour), time.Hour*24)
+	if err := repo.RevokeDevice(ctx, tenant, "u1", "d1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// same device again: no unique violation, row active again
+	err := repo.SaveRefreshToken(ctx, &domain.RefreshToken{
+		TenantID:  tenant,
+		UserID:    "u1",
+		DeviceID:  "d1",
+		TokenHash: "second",
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Hour),
+		Service:   "default",
+	})
+	if err != nil {
+		t.Fatalf("re-login: %v", err)
+	}
+
+	devices, err := repo.GetLoginDevices(ctx, tenant, "u1", 10, 0)
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("GetLoginDevices = %+v, %v", devices, err)
+	}
+	if devices[0].Revoked || devices[0].UserAgent != nil {
+		t.Fatalf("row not replaced: %+v", devices[0])
+	}
+	if n, _ := repo.CountActiveDevices(ctx, tenant, "u1"); n != 1 {
+		t.Fatalf("CountActiveDevices = %d", n)
+	}
+}
+
+func loginDevicesPaging(t *testing.T, repo repository.AuthUserRepository) {
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		Session(t, repo, "u1", fmt.Sprintf("d%d", i), base.Add(time.Duration(i)*time.Minute), time.Hour*24)
+	}
+	Session(t, repo, "u2", "other", base, time.Hour*24)
+
+	page, err := repo.GetLoginDevices(ctx, tenant, "u1", 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newest first
+	if len(page) != 2 || page[0].DeviceID != "d3" || page[1].DeviceID != "d2" {
+		t.Fatalf("page = %+v", page)
+	}
+	if page[0].UserAgent == nil || *page[0].UserAgent != "agent" || page[0].IPAddress == nil {
+		t.Fatalf("device info lost: %+v", page[0])
+	}
+	if page[0].IssuedAt.Sub(base.Add(3*time.Minute)).Abs() > time.Millisecond {
+		t.Fatalf("issued_at = %s", page[0].IssuedAt)
+	}
+	if n, _ := repo.CountActiveDevices(ctx, tenant, "u1"); n != 5 {
+		t.Fatalf("CountActiveDevices = %d", n)
+	}
+}
+
+func revokeDevice(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now()
+	Session(t, repo, "u1", "d1", now, time.Hour)
+	Session(t, repo, "u1", "d2", now, time.Hour)
+
+	if err := repo.RevokeDevice(ctx, tenant, "u1", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := repo.CountActiveDevices(ctx, tenant, "u1"); n != 1 {
+		t.Fatalf("CountActiveDevices = %d", n)
+	}
+	ids, _ := repo.ActiveDeviceIDs(ctx, tenant, "u1")
+	if len(ids) != 1 || ids[0] != "d2" {
+		t.Fatalf("ActiveDeviceIDs = %v", ids)
+	}
+	// refresh of a revoked device is refused
+	if ok, err := repo.UpdateLastUsedAt(ctx, tenant, "u1", "d1"); ok || err != nil {
+		t.Fatalf("UpdateLastUsedAt(revoked) = %v, %v", ok, err)
+	}
+
+	// the revocation queued a redis eviction
+	events, err := repo.ClaimSessionOutbox(ctx, 10, time.Minute)
+	if err != nil || len(events) != 1 || events[0].DeviceID != "d1" || !events[0].Revoked {
+		t.Fatalf("ClaimSessionOutbox = %+v, %v", events, err)
+	}
+}
+
+func revokeOtherAndAll(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now()
+	for _, d := range []string{"d1", "d2", "d3"} {
+		Session(t, repo, "u1", d, now, time.Hour)
+	}
+
+	others, err := repo.RevokeOtherDevices(ctx, tenant, "u1", "d2")
+	sort.Strings(others)
+	if err != nil || len(others) != 2 || others[0] != "d1" || others[1] != "d3" {
+		t.Fatalf("RevokeOtherDevices = %v, %v", others, err)
+	}
+	// already revoked devices are not reported twice
+	all, err := repo.RevokeAllDevices(ctx, tenant, "u1")
+	if err != nil || len(all) != 1 || all[0] != "d2" {
+		t.Fatalf("RevokeAllDevices = %v, %v", all, err)
+	}
+	if n, _ := repo.CountActiveDevices(ctx, tenant, "u1"); n != 0 {
+		t.Fatalf("CountActiveDevices = %d", n)
+	}
+
+	events, _ := repo.ClaimSessionOutbox(ctx, 10, time.Minute)
+	if len(events) != 3 {
+		t.Fatalf("outbox events = %+v", events)
+	}
+}
+
+func activeSessions(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now()
+	Session(t, repo, "a", "d1", now, time.Hour)
+	Session(t, repo, "a", "d2", now, time.Hour)
+	Session(t, repo, "b", "d1", now, time.Hour)
+	Session(t, repo, "b", "expired", now.Add(-2*time.Hour), time.Hour)
+	Session(t, repo, "c", "revoked", now, time.Hour)
+	if err := repo.RevokeDevice(ctx, tenant, "c", "revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	afterTenant, afterUser, afterDevice := "", "", ""
+	for {
+		page, err := repo.ListActiveSessions(ctx, afterTenant, afterUser, afterDevice, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range page {
+			got = append(got, s.UserID+"/"+s.DeviceID)
+			if s.TokenHash != "hash-"+s.DeviceID || s.Service != "default" {
+				t.Fatalf("session = %+v", s)
+			}
+		}
+		if len(page) < 2 {
+			break
+		}
+		last := page[len(page)-1]
+		afterTenant, afterUser, afterDevice = last.TenantID, last.UserID, last.DeviceID
+	}
+	want := []string{"a/d1", "a/d2", "b/d1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ListActiveSessions = %v, want %v", got, want)
+	}
+}
+
+func lastUsedAndHash(t *testing.T, repo repository.AuthUserRepository) {
+	Session(t, repo, "u1", "d1", time.Now(), time.Hour)
+
+	if ok, err := repo.UpdateLastUsedAt(ctx, tenant, "u1", "d1"); !ok || err != nil {
+		t.Fatalf("UpdateLastUsedAt = %v, %v", ok, err)
+	}
+	if ok, _ := repo.UpdateLastUsedAt(ctx, tenant, "u1", "missing"); ok {
+		t.Fatal("UpdateLastUsedAt(missing) = true")
+	}
+	// only the current token is swapped
+	if ok, err := repo.UpdateRefreshTokenHash(ctx, tenant, "u1", "d1", "stale-hash", "other-hash"); ok || err != nil {
+		t.Fatalf("UpdateRefreshTokenHash(stale) = %v, %v", ok, err)
+	}
+	if ok, err := repo.UpdateRefreshTokenHash(ctx, tenant, "u1", "d1", "hash-d1", "new-hash"); !ok || err != nil {
+		t.Fatalf("UpdateRefreshTokenHash = %v, %v", ok, err)
+	}
+
+	sessions, _ := repo.ListActiveSessions(ctx, "", "", "", 10)
+	if len(sessions) != 1 || sessions[0].TokenHash != "new-hash" || sessions[0].LastUsedAt == nil {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+}
+
+func outboxLifecycle(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now()
+	Session(t, repo, "u1", "d1", now, time.Hour)
+	Session(t, repo, "u1", "d2", now, time.Hour)
+	if _, err := repo.RevokeAllDevices(ctx, tenant, "u1"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := repo.ClaimSessionOutbox(ctx, 10, time.Minute)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("claim = %+v, %v", events, err)
+	}
+	// leased events are not handed out twice
+	if again, _ := repo.ClaimSessionOutbox(ctx, 10, time.Minute); len(again) != 0 {
+		t.Fatalf("claimed twice: %+v", again)
+	}
+
+	// a failed attempt comes back once retryAt passed
+	if err := repo.FailSessionOutbox(ctx, events[0].ID, "boom", now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CompleteSessionOutbox(ctx, tenant, "u1", []string{events[1].DeviceID}); err != nil {
+		t.Fatal(err)
+	}
+	retry, _ := repo.ClaimSessionOutbox(ctx, 10, time.Minute)
+	if len(retry) != 1 || retry[0].ID != events[0].ID || retry[0].Attempts != 1 {
+		t.Fatalf("retry = %+v", retry)
+	}
+
+	// a device that logged in again is reported as not revoked
+	Session(t, repo, "u1", retry[0].DeviceID, now, time.Hour)
+	if err := repo.FailSessionOutbox(ctx, retry[0].ID, "boom", now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	retry, _ = repo.ClaimSessionOutbox(ctx, 10, time.Minute)
+	if len(retry) != 1 || retry[0].Revoked {
+		t.Fatalf("re-login not seen: %+v", retry)
+	}
+
+	if err := repo.CompleteSessionOutbox(ctx, tenant, "u1", []string{retry[0].DeviceID}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := repo.PruneSessionOutbox(ctx, time.Now().Add(time.Minute), 10)
+	if err != nil || n != 2 {
+		t.Fatalf("PruneSessionOutbox = %d, %v", n, err)
+	}
+}
+
+func archiveFinished(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now()
+	Session(t, repo, "u1", "active", now, time.Hour)
+	Session(t, repo, "u1", "expired", now.Add(-3*time.Hour), time.Hour)
+	Session(t, repo, "u1", "revoked", now, time.Hour)
+	if err := repo.RevokeDevice(ctx, tenant, "u1", "revoked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.EnsureHistoryPartitions(ctx, now, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the grace period keeps the freshly revoked row
+	n, err := repo.ArchiveFinishedSessions(ctx, now, now.Add(-time.Hour), 10, true)
+	if err != nil || n != 1 {
+		t.Fatalf("ArchiveFinishedSessions(grace) = %d, %v", n, err)
+	}
+	// past the grace the revoked row goes too, delete mode keeps no history
+	n, err = repo.ArchiveFinishedSessions(ctx, now, now.Add(time.Minute), 10, false)
+	if err != nil || n != 1 {
+		t.Fatalf("ArchiveFinishedSessions = %d, %v", n, err)
+	}
+
+	devices, _ := repo.GetLoginDevices(ctx, tenant, "u1", 10, 0)
+	if len(devices) != 1 || devices[0].DeviceID != "active" {
+		t.Fatalf("left = %+v", devices)
+	}
+	if _, err := repo.DropHistoryPartitions(ctx, now.AddDate(-1, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a month without partition lands in the default partition, creating
+	// the month afterwards moves it out
+	Session(t, repo, "u2", "stale", now.AddDate(0, -3, 0), time.Hour)
+	if n, err := repo.ArchiveFinishedSessions(ctx, now, now, 10, true); err != nil || n != 1 {
+		t.Fatalf("ArchiveFinishedSessions(stale) = %d, %v", n, err)
+	}
+	if err := repo.EnsureHistoryPartitions(ctx, now, 2); err != nil {
+		t.Fatalf("EnsureHistoryPartitions with default rows: %v", err)
+	}
+	if err := repo.EnsureHistoryPartitions(ctx, now, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DropHistoryPartitions(ctx, now.AddDate(0, -1, 0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runExclusive(t *testing.T, repo repository.AuthUserRepository) {
+	ran, err := repo.RunExclusive(ctx, 42, func(ctx context.Context) error {
+		inner, err := repo.RunExclusive(ctx, 42, func(context.Context) error { return nil })
+		if err != nil || inner {
+			t.Errorf("nested RunExclusive = %v, %v", inner, err)
+		}
+		return nil
+	})
+	if err != nil || !ran {
+		t.Fatalf("RunExclusive = %v, %v", ran, err)
+	}
+	// released afterwards
+	if ran, _ := repo.RunExclusive(ctx, 42, func(context.Context) error { return nil }); !ran {
+		t.Fatal("lock not released")
+	}
+}
+
+func webauthnCredentials(t *testing.T, repo repository.AuthUserRepository) {
+	cred := &domain.WebAuthnCredential{
+		TenantID:        tenant,
+		UserID:          "u1",
+		CredentialID:    []byte{1, 2, 3},
+		PublicKey:       []byte{4, 5, 6},
+		AttestationType: "none",
+		SignCount:       1,
+		Transports:      []string{"internal", "hybrid"},
+		Flags:           0x05,
+		CreatedAt:       time.Now(),
+	}
+	if err := repo.SaveWebAuthnCredential(ctx, cred); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveWebAuthnCredential(ctx, cred); err == nil {
+		t.Fatal("duplicate credential id accepted")
+	}
+
+	got, err := repo.FindWebAuthnCredential(ctx, tenant, []byte{1, 2, 3})
+	if err != nil || got == nil || !bytes.Equal(got.PublicKey, cred.PublicKey) ||
+		fmt.Sprint(got.Transports) != fmt.Sprint(cred.Transports) || got.Flags != 0x05 {
+		t.Fatalf("FindWebAuthnCredential = %+v, %v", got, err)
+	}
+	if got, err := repo.FindWebAuthnCredential(ctx, tenant, []byte{9}); got != nil || err != nil {
+		t.Fatalf("FindWebAuthnCredential(missing) = %+v, %v", got, err)
+	}
+
+	if err := repo.UpdateWebAuthnSignCount(ctx, cred.CredentialID, 7, true); err != nil {
+		t.Fatal(err)
+	}
+	list, err := repo.GetWebAuthnCredentials(ctx, tenant, "u1")
+	if err != nil || len(list) != 1 || list[0].SignCount != 7 || !list[0].CloneWarning || list[0].LastUsedAt == nil {
+		t.Fatalf("GetWebAuthnCredentials = %+v, %v", list, err)
+	}
+}
+
+func serviceClients(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now().Truncate(time.Millisecond)
+	client := &domain.ServiceClient{Name: "shop", TenantID: "acme", AllowedIPs: []string{"10.0.0.0/8"}, CreatedAt: now}
+	if err := repo.SaveServiceClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	// saving again updates the settings
+	client.AllowedEndpoints = []string{"POST /auth/login"}
+	client.CertIdentities = []string{"dns:shop.internal", "cn:shop"}
+	client.Disabled = true
+	if err := repo.SaveServiceClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.FindServiceClient(ctx, "shop")
+	if err != nil || got == nil || !got.Disabled || got.TenantID != "acme" ||
+		fmt.Sprint(got.AllowedEndpoints) != "[POST /auth/login]" || fmt.Sprint(got.AllowedIPs) != "[10.0.0.0/8]" ||
+		fmt.Sprint(got.CertIdentities) != "[cn:shop dns:shop.internal]" {
+		t.Fatalf("FindServiceClient = %+v, %v", got, err)
+	}
+	if got, err := repo.FindServiceClient(ctx, "missing"); got != nil || err != nil {
+		t.Fatalf("FindServiceClient(missing) = %+v, %v", got, err)
+	}
+	if err := repo.SaveServiceClient(ctx, &domain.ServiceClient{Name: "admin", TenantID: tenant, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := repo.ListServiceClients(ctx); err != nil || len(list) != 2 || list[0].Name != "admin" ||
+		len(list[0].AllowedIPs) != 0 || len(list[0].CertIdentities) != 0 || len(list[1].CertIdentities) != 2 {
+		t.Fatalf("ListServiceClients = %+v, %v", list, err)
+	}
+
+	names, err := repo.ServiceNamesByCertIdentity(ctx, []string{"cn:shop", "dns:shop.internal", "cn:other"})
+	if err != nil || fmt.Sprint(names) != "[shop]" {
+		t.Fatalf("ServiceNamesByCertIdentity = %v, %v", names, err)
+	}
+	// the identities are replaced on save
+	client.CertIdentities = []string{"uri:spiffe://example.org/shop"}
+	if err := repo.SaveServiceClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := repo.ServiceNamesByCertIdentity(ctx, []string{"cn:shop"}); err != nil || len(names) != 0 {
+		t.Fatalf("ServiceNamesByCertIdentity(replaced) = %v, %v", names, err)
+	}
+	if names, err := repo.ServiceNamesByCertIdentity(ctx, nil); err != nil || len(names) != 0 {
+		t.Fatalf("ServiceNamesByCertIdentity(nil) = %v, %v", names, err)
+	}
+
+	// two active keys during a rotation
+	for i, id := range []string{"k1", "k2"} {
+		err := repo.SaveServiceClientKey(ctx, &domain.ServiceClientKey{
+			KeyID:       id,
+			ServiceName: "shop",
+			KeyHash:     "hash-" + id,
+			SigningKey:  []byte("sealed-" + id),
+			ExpiresAt:   ptr(now.Add(time.Hour)),
+			CreatedAt:   now.Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, err := repo.FindServiceClientKey(ctx, "k1")
+	if err != nil || key == nil || key.ServiceName != "shop" || key.KeyHash != "hash-k1" || string(key.SigningKey) != "sealed-k1" ||
+		key.Revoked || key.ExpiresAt == nil || !key.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("FindServiceClientKey = %+v, %v", key, err)
+	}
+	if key, err := repo.FindServiceClientKey(ctx, "missing"); key != nil || err != nil {
+		t.Fatalf("FindServiceClientKey(missing) = %+v, %v", key, err)
+	}
+
+	if err := repo.TouchServiceClientKey(ctx, "k1", now); err != nil {
+		t.Fatal(err)
+	}
+	// a use within the minute is not written again
+	if err := repo.TouchServiceClientKey(ctx, "k1", now.Add(30*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := repo.RevokeServiceClientKey(ctx, "k1"); !ok || err != nil {
+		t.Fatalf("RevokeServiceClientKey = %v, %v", ok, err)
+	}
+	if ok, _ := repo.RevokeServiceClientKey(ctx, "k1"); ok {
+		t.Fatal("revoked twice")
+	}
+
+	keys, err := repo.ListServiceClientKeys(ctx, "shop")
+	if err != nil || len(keys) != 2 || keys[0].KeyID != "k1" || !keys[0].Revoked || keys[1].Revoked ||
+		keys[0].LastUsedAt == nil || !keys[0].LastUsedAt.Equal(now) {
+		t.Fatalf("ListServiceClientKeys = %+v, %v", keys, err)
+	}
+}
+
+// tenants: the same user, provider id, phone number and device exist in two
+// tenants without touching each other.
+func tenants(t *testing.T, repo repository.AuthUserRepository) {
+	now := time.Now()
+	for _, tenantID := range []string{tenant, "acme"} {
+		u := &domain.AuthUser{TenantID: tenantID, UserID: "u1", Provider: "google", ProviderID: "g-1", Email: tenantID + "@example.com"}
+		if err := repo.Save(ctx, u); err != nil {
+			t.Fatalf("Save(%s): %v", tenantID, err)
+		}
+		if err := repo.SetPhoneNumber(ctx, tenantID, "u1", "+15550001111"); err != nil {
+			t.Fatalf("SetPhoneNumber(%s): %v", tenantID, err)
+		}
+		tenantSession(t, repo, tenantID, "u1", "d1", now, time.Hour)
+	}
+
+	for _, tenantID := range []string{tenant, "acme"} {
+		u, err := repo.FindByProvider(ctx, tenantID, "google", "g-1")
+		if err != nil || u == nil || u.TenantID != tenantID || u.Email != tenantID+"@example.com" {
+			t.Fatalf("FindByProvider(%s) = %+v, %v", tenantID, u, err)
+		}
+		if u, err := repo.FindByPhone(ctx, tenantID, "+15550001111"); err != nil || u == nil || u.TenantID != tenantID {
+			t.Fatalf("FindByPhone(%s) = %+v, %v", tenantID, u, err)
+		}
+	}
+	if u, err := repo.FindByUserID(ctx, "other", "u1"); u != nil || err != nil {
+		t.Fatalf("FindByUserID(other tenant) = %+v, %v", u, err)
+	}
+
+	revoked, err := repo.RevokeAllDevices(ctx, "acme", "u1")
+	if err != nil || fmt.Sprint(revoked) != "[d1]" {
+		t.Fatalf("RevokeAllDevices = %v, %v", revoked, err)
+	}
+	if n, _ := repo.CountActiveDevices(ctx, tenant, "u1"); n != 1 {
+		t.Fatalf("default tenant devices = %d, want 1", n)
+	}
+	if n, _ := repo.CountActiveDevices(ctx, "acme", "u1"); n != 0 {
+		t.Fatalf("acme devices = %d, want 0", n)
+	}
+
+	events, err := repo.ClaimSessionOutbox(ctx, 10, time.Minute)
+	if err != nil || len(events) != 1 || events[0].TenantID != "acme" || !events[0].Revoked {
+		t.Fatalf("ClaimSessionOutbox = %+v, %v", events, err)
+	}
+	// completing in the wrong tenant leaves the event pending
+	if err := repo.CompleteSessionOutbox(ctx, tenant, "u1", []string{"d1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.FailSessionOutbox(ctx, events[0].ID, "retry", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := repo.ClaimSessionOutbox(ctx, 10, time.Minute); len(events) != 1 {
+		t.Fatalf("pending events = %+v", events)
+	}
+
+	tenantSession(t, repo, "acme", "u1", "d2", now, time.Hour)
+	sessions, err := repo.ListActiveSessions(ctx, "", "", "", 10)
+	if err != nil || len(sessions) != 2 ||
+		sessions[0].TenantID != "acme" || sessions[0].DeviceID != "d2" ||
+		sessions[1].TenantID != tenant || sessions[1].DeviceID != "d1" {
+		t.Fatalf("ListActiveSessions = %+v, %v", sessions, err)
+	}
+
+	cred := &domain.WebAuthnCredential{TenantID: "acme", UserID: "u1", CredentialID: []byte{7}, PublicKey: []byte{1}, Transports: []string{}, CreatedAt: now}
+	if err := repo.SaveWebAuthnCredential(ctx, cred); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.FindWebAuthnCredential(ctx, tenant, []byte{7}); got != nil || err != nil {
+		t.Fatalf("FindWebAuthnCredential(other tenant) = %+v, %v", got, err)
+	}
+	if list, _ := repo.GetWebAuthnCredentials(ctx, tenant, "u1"); len(list) != 0 {
+		t.Fatalf("GetWebAuthnCredentials(other tenant) = %+v", list)
+	}
+	if got, _ := repo.FindWebAuthnCredential(ctx, "acme", []byte{7}); got == nil || got.TenantID != "acme" {
+		t.Fatalf("FindWebAuthnCredential = %+v", got)
+	}
+}
@@ -0,0 +1,564 @@
+package repotest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+)
+
+// NewSessionStore returns an empty session store that lives as long as the
+// test, and advance, which lets d pass for the store's TTLs.
+type NewSessionStore func(t *testing.T) (store repository.SessionStore, advance func(d time.Duration))
+
+// RunSessionStoreSuite is the behaviour every SessionStore implementation
+// must share.
+func RunSessionStoreSuite(t *testing.T, newStore NewSessionStore) {
+	cases := map[string]func(*testing.T, repository.SessionStore, func(time.Duration)){
+		"LoginAndRefresh":    loginAndRefresh,
+		"SameDeviceRelogin":  sameDeviceRelogin,
+		"EvictOldest":        evictOldest,
+		"EvictLRU":           evictLRU,
+		"RejectAndChoice":    rejectAndChoice,
+		"Logout":             logout,
+		"Expiry":             expiry,
+		"ReplaceToken":       replaceToken,
+		"Restore":            restore,
+		"EachSession":        eachSession,
+		"ConcurrentLogins":   concurrentLogins,
+		"WebAuthnSession":    webauthnSession,
+		"OTPLimits":          otpLimits,
+		"OTPChallenge":       otpChallenge,
+		"Nonces":             nonces,
+		"LimitLoweredEvicts": limitLoweredEvicts,
+		"Tenants":            tenantSessions,
+	}
+	names := make([]string, 0, len(cases))
+	for name := range cases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fn := cases[name]
+		t.Run(name, func(t *testing.T) {
+			store, advance := newStore(t)
+			fn(t, store, advance)
+		})
+	}
+}
+
+var oldest = policy.DevicePolicy{MaxDevices: 2, Strategy: policy.EvictOldest}
+
+// login saves a session of deviceID whose token hash is "hash-"+deviceID.
+func login(t *testing.T, store repository.SessionStore, userID, deviceID string, p policy.DevicePolicy) []string {
+	t.Helper()
+	evicted, err := store.SaveLogin(ctx, tenant, userID, deviceID, "hash-"+deviceID, time.Hour, p, "")
+	if err != nil {
+		t.Fatalf("SaveLogin(%s): %v", deviceID, err)
+	}
+	return evicted
+}
+
+func deviceIDs(t *testing.T, store repository.SessionStore, userID string) []string {
+	t.Helper()
+	devices, err := store.GetDevices(ctx, tenant, userID)
+	if err != nil {
+		t.Fatalf("GetDevices: %v", err)
+	}
+	ids := make([]string, 0, len(devices))
+	for id := range devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equal(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func loginAndRefresh(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	before := time.Now().Add(-time.Second)
+	if evicted := login(t, store, "u1", "d1", oldest); len(evicted) != 0 {
+		t.Fatalf("evicted = %v", evicted)
+	}
+
+	devices, err := store.GetDevices(ctx, tenant, "u1")
+	if err != nil || len(devices) != 1 || devices["d1"].Before(before.Truncate(time.Second)) {
+		t.Fatalf("GetDevices = %v, %v", devices, err)
+	}
+	if ok, err := store.ExistsRefreshToken(ctx, tenant, "u1", "d1"); !ok || err != nil {
+		t.Fatalf("ExistsRefreshToken = %v, %v", ok, err)
+	}
+	if ttl, ok, err := store.SessionTTL(ctx, tenant, "u1", "d1"); !ok || err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("SessionTTL = %v, %v, %v", ttl, ok, err)
+	}
+
+	// refresh slides the ttl, a wrong hash is refused
+	if ok, err := store.RefreshSession(ctx, tenant, "u1", "d1", "hash-d1", 2*time.Hour); !ok || err != nil {
+		t.Fatalf("RefreshSession = %v, %v", ok, err)
+	}
+	if ttl, _, _ := store.SessionTTL(ctx, tenant, "u1", "d1"); ttl <= time.Hour {
+		t.Fatalf("ttl not slid: %v", ttl)
+	}
+	if ok, err := store.RefreshSession(ctx, tenant, "u1", "d1", "other", time.Hour); ok || err != nil {
+		t.Fatalf("RefreshSession(wrong hash) = %v, %v", ok, err)
+	}
+	if ok, err := store.RefreshSession(ctx, tenant, "u1", "missing", "hash-missing", time.Hour); ok || err != nil {
+		t.Fatalf("RefreshSession(missing) = %v, %v", ok, err)
+	}
+	if _, ok, err := store.SessionTTL(ctx, tenant, "u1", "missing"); ok || err != nil {
+		t.Fatalf("SessionTTL(missing) = %v, %v", ok, err)
+	}
+
+	// the batch leaves out devices without a session
+	login(t, store, "u1", "d2", oldest)
+	ttls, err := store.SessionTTLs(ctx, tenant, "u1", []string{"d1", "missing", "d2"})
+	if err != nil || len(ttls) != 2 || ttls["d1"] <= time.Hour || ttls["d2"] <= 59*time.Minute || ttls["d2"] > time.Hour {
+		t.Fatalf("SessionTTLs = %v, %v", ttls, err)
+	}
+	if ttls, err := store.SessionTTLs(ctx, tenant, "u1", nil); len(ttls) != 0 || err != nil {
+		t.Fatalf("SessionTTLs(nil) = %v, %v", ttls, err)
+	}
+}
+
+func sameDeviceRelogin(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	login(t, store, "u1", "d1", oldest)
+	login(t, store, "u1", "d2", oldest)
+
+	// the device is already counted, nothing is evicted
+	evicted, err := store.SaveLogin(ctx, tenant, "u1", "d2", "second", time.Hour, oldest, "")
+	if err != nil || len(evicted) != 0 {
+		t.Fatalf("SaveLogin = %v, %v", evicted, err)
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d2", "hash-d2", time.Hour); ok {
+		t.Fatal("old token still valid after re-login")
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d2", "second", time.Hour); !ok {
+		t.Fatal("new token refused")
+	}
+}
+
+func evictOldest(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	// same second: ties go by device id, d1 is still the oldest
+	login(t, store, "u1", "d1", oldest)
+	login(t, store, "u1", "d2", oldest)
+
+	if evicted := login(t, store, "u1", "d3", oldest); !equal(evicted, []string{"d1"}) {
+		t.Fatalf("evicted = %v, want [d1]", evicted)
+	}
+	if ids := deviceIDs(t, store, "u1"); !equal(ids, []string{"d2", "d3"}) {
+		t.Fatalf("devices = %v", ids)
+	}
+	if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u1", "d1"); ok {
+		t.Fatal("evicted session still exists")
+	}
+}
+
+func evictLRU(t *testing.T, store repository.SessionStore, advance func(time.Duration)) {
+	lru := policy.DevicePolicy{MaxDevices: 2, Strategy: policy.EvictLRU}
+	login(t, store, "u1", "d1", lru)
+	login(t, store, "u1", "d2", lru)
+
+	// scores have second resolution
+	advance(time.Second)
+	if ok, err := store.RefreshSession(ctx, tenant, "u1", "d1", "hash-d1", time.Hour); !ok || err != nil {
+		t.Fatalf("RefreshSession = %v, %v", ok, err)
+	}
+
+	if evicted := login(t, store, "u1", "d3", lru); !equal(evicted, []string{"d2"}) {
+		t.Fatalf("evicted = %v, want [d2]", evicted)
+	}
+}
+
+func rejectAndChoice(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	for _, strategy := range []string{policy.Reject, policy.RequireChoice} {
+		userID := "u-" + strategy
+		p := policy.DevicePolicy{MaxDevices: 2, Strategy: strategy}
+		login(t, store, userID, "d1", p)
+		login(t, store, userID, "d2", p)
+
+		_, err := store.SaveLogin(ctx, tenant, userID, "d3", "hash-d3", time.Hour, p, "")
+		var limitErr *repository.DeviceLimitError
+		if !errors.As(err, &limitErr) || !equal(limitErr.DeviceIDs, []string{"d1", "d2"}) {
+			t.Fatalf("%s: SaveLogin = %v, want DeviceLimitError", strategy, err)
+		}
+		if ok, _ := store.ExistsRefreshToken(ctx, tenant, userID, "d3"); ok {
+			t.Fatalf("%s: refused session stored", strategy)
+		}
+	}
+
+	p := policy.DevicePolicy{MaxDevices: 2, Strategy: policy.RequireChoice}
+	if _, err := store.SaveLogin(ctx, tenant, "u-"+policy.RequireChoice, "d3", "hash-d3", time.Hour, p, "missing"); !errors.Is(err, repository.ErrReplaceDeviceNotFound) {
+		t.Fatalf("SaveLogin(replace missing) = %v", err)
+	}
+	evicted, err := store.SaveLogin(ctx, tenant, "u-"+policy.RequireChoice, "d3", "hash-d3", time.Hour, p, "d1")
+	if err != nil || !equal(evicted, []string{"d1"}) {
+		t.Fatalf("SaveLogin(replace d1) = %v, %v", evicted, err)
+	}
+
+	// the limit was lowered below the device count: replacing one is not
+	// enough, and the refused login keeps the chosen device
+	one := policy.DevicePolicy{MaxDevices: 1, Strategy: policy.RequireChoice}
+	_, err = store.SaveLogin(ctx, tenant, "u-"+policy.RequireChoice, "d4", "hash-d4", time.Hour, one, "d2")
+	var limitErr *repository.DeviceLimitError
+	if !errors.As(err, &limitErr) || !equal(limitErr.DeviceIDs, []string{"d2", "d3"}) {
+		t.Fatalf("SaveLogin(replace d2, over limit) = %v, want DeviceLimitError", err)
+	}
+	if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u-"+policy.RequireChoice, "d2"); !ok {
+		t.Fatal("refused login dropped the device chosen to replace")
+	}
+}
+
+func limitLoweredEvicts(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	wide := policy.DevicePolicy{MaxDevices: 4, Strategy: policy.EvictOldest}
+	for _, id := range []string{"d1", "d2", "d3", "d4"} {
+		login(t, store, "u1", id, wide)
+	}
+
+	// down to two devices: the new one plus the newest
+	evicted := login(t, store, "u1", "d5", oldest)
+	sort.Strings(evicted)
+	if !equal(evicted, []string{"d1", "d2", "d3"}) {
+		t.Fatalf("evicted = %v", evicted)
+	}
+}
+
+func logout(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	many := policy.DevicePolicy{MaxDevices: 5, Strategy: policy.EvictOldest}
+	for _, id := range []string{"d1", "d2", "d3", "d4"} {
+		login(t, store, "u1", id, many)
+	}
+	login(t, store, "u2", "d1", many)
+
+	if err := store.LogoutDevice(ctx, tenant, "u1", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	// unknown devices are no error
+	if err := store.LogoutDevice(ctx, tenant, "u1", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := deviceIDs(t, store, "u1"); !equal(ids, []string{"d2", "d3", "d4"}) {
+		t.Fatalf("devices = %v", ids)
+	}
+
+	removed, err := store.LogoutOtherDevices(ctx, tenant, "u1", "d3")
+	sort.Strings(removed)
+	if err != nil || !equal(removed, []string{"d2", "d4"}) {
+		t.Fatalf("LogoutOtherDevices = %v, %v", removed, err)
+	}
+	if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u1", "d3"); !ok {
+		t.Fatal("kept device logged out")
+	}
+
+	if err := store.LogoutAll(ctx, tenant, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := deviceIDs(t, store, "u1"); len(ids) != 0 {
+		t.Fatalf("devices after LogoutAll = %v", ids)
+	}
+	// other users are untouched
+	if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u2", "d1"); !ok {
+		t.Fatal("LogoutAll removed another user's session")
+	}
+}
+
+func expiry(t *testing.T, store repository.SessionStore, advance func(time.Duration)) {
+	if _, err := store.SaveLogin(ctx, tenant, "u1", "d1", "h1", 100*time.Millisecond, oldest, ""); err != nil {
+		t.Fatal(err)
+	}
+	login(t, store, "u1", "d2", oldest)
+	advance(300 * time.Millisecond)
+
+	if ok, err := store.ExistsRefreshToken(ctx, tenant, "u1", "d1"); ok || err != nil {
+		t.Fatalf("ExistsRefreshToken(expired) = %v, %v", ok, err)
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d1", "h1", time.Hour); ok {
+		t.Fatal("expired session refreshed")
+	}
+	if _, ok, _ := store.SessionTTL(ctx, tenant, "u1", "d1"); ok {
+		t.Fatal("expired session has a ttl")
+	}
+	if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u1", "d2"); !ok {
+		t.Fatal("live session expired")
+	}
+	// an expired session does not count against the limit
+	if evicted := login(t, store, "u1", "d3", oldest); len(evicted) != 0 {
+		t.Fatalf("evicted = %v", evicted)
+	}
+}
+
+func replaceToken(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	login(t, store, "u1", "d1", oldest)
+	ttl, _, _ := store.SessionTTL(ctx, tenant, "u1", "d1")
+
+	if ok, err := store.ReplaceRefreshToken(ctx, tenant, "u1", "d1", "stale", "other"); ok || err != nil {
+		t.Fatalf("ReplaceRefreshToken(stale) = %v, %v", ok, err)
+	}
+	if ok, err := store.ReplaceRefreshToken(ctx, tenant, "u1", "d1", "hash-d1", "new"); !ok || err != nil {
+		t.Fatalf("ReplaceRefreshToken = %v, %v", ok, err)
+	}
+	if got, _, _ := store.SessionTTL(ctx, tenant, "u1", "d1"); got > ttl {
+		t.Fatalf("ttl extended by replace: %v > %v", got, ttl)
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d1", "hash-d1", time.Hour); ok {
+		t.Fatal("replaced token still valid")
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d1", "new", time.Hour); !ok {
+		t.Fatal("new token refused")
+	}
+
+	if ok, err := store.ReplaceRefreshToken(ctx, tenant, "u1", "missing", "hash-missing", "new"); ok || err != nil {
+		t.Fatalf("ReplaceRefreshToken(missing) = %v, %v", ok, err)
+	}
+	if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u1", "missing"); ok {
+		t.Fatal("replace created a session")
+	}
+}
+
+func restore(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	now := time.Now()
+	loginAt := now.Add(-time.Hour)
+
+	res, err := store.RestoreSession(ctx, tenant, "u1", "d1", "h1", loginAt, now, time.Hour, time.Minute)
+	if err != nil || res != repository.RestoreCreated {
+		t.Fatalf("RestoreSession(missing) = %v, %v", res, err)
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d1", "h1", time.Hour); !ok {
+		t.Fatal("restored session refused")
+	}
+	devices, _ := store.GetDevices(ctx, tenant, "u1")
+	if got := devices["d1"]; got.Unix() != loginAt.Unix() {
+		t.Fatalf("login time = %v, want %v", got, loginAt)
+	}
+
+	if res, _ := store.RestoreSession(ctx, tenant, "u1", "d1", "h1", loginAt, now, time.Hour, time.Minute); res != repository.RestoreUnchanged {
+		t.Fatalf("RestoreSession(same) = %v", res)
+	}
+	if res, _ := store.RestoreSession(ctx, tenant, "u1", "d1", "h2", loginAt, now, time.Hour, time.Minute); res != repository.RestoreHashFixed {
+		t.Fatalf("RestoreSession(other hash) = %v", res)
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d1", "h2", time.Hour); !ok {
+		t.Fatal("fixed hash refused")
+	}
+
+	// a login inside the grace window wins over postgres
+	login(t, store, "u1", "d2", oldest)
+	if res, _ := store.RestoreSession(ctx, tenant, "u1", "d2", "stale", loginAt, now, time.Hour, time.Minute); res != repository.RestoreUnchanged {
+		t.Fatalf("RestoreSession(fresh login) = %v", res)
+	}
+	if ok, _ := store.RefreshSession(ctx, tenant, "u1", "d2", "hash-d2", time.Hour); !ok {
+		t.Fatal("fresh login overwritten")
+	}
+}
+
+func eachSession(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	many := policy.DevicePolicy{MaxDevices: 10, Strategy: policy.EvictOldest}
+	want := map[repository.SessionKey]bool{}
+	for _, u := range []string{"u1", "u2", "u3"} {
+		for _, d := range []string{"d1", "d2", "d3"} {
+			login(t, store, u, d, many)
+			want[repository.SessionKey{TenantID: tenant, UserID: u, DeviceID: d}] = true
+		}
+	}
+	if _, err := store.SaveLogin(ctx, "acme", "u1", "d1", "hash-d1", time.Hour, many, ""); err != nil {
+		t.Fatal(err)
+	}
+	want[repository.SessionKey{TenantID: "acme", UserID: "u1", DeviceID: "d1"}] = true
+
+	got := map[repository.SessionKey]bool{}
+	err := store.EachSession(ctx, 2, func(keys []repository.SessionKey) error {
+		for _, k := range keys {
+			got[k] = true
+		}
+		return nil
+	})
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("EachSession = %v, %v", got, err)
+	}
+	for k := range want {
+		if !got[k] {
+			t.Fatalf("EachSession missed %+v", k)
+		}
+	}
+
+	// errors stop the walk
+	stop := errors.New("stop")
+	if err := store.EachSession(ctx, 2, func([]repository.SessionKey) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("EachSession error = %v", err)
+	}
+}
+
+func concurrentLogins(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	p := policy.DevicePolicy{MaxDevices: 3, Strategy: policy.EvictOldest}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("d%02d", i)
+			if _, err := store.SaveLogin(ctx, tenant, "u1", id, "hash-"+id, time.Hour, p, ""); err != nil {
+				t.Errorf("SaveLogin: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ids := deviceIDs(t, store, "u1")
+	if len(ids) != 3 {
+		t.Fatalf("devices = %v, want 3", ids)
+	}
+	for _, id := range ids {
+		if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u1", id); !ok {
+			t.Fatalf("device %s without session", id)
+		}
+	}
+}
+
+func webauthnSession(t *testing.T, store repository.SessionStore, advance func(time.Duration)) {
+	if err := store.SaveWebAuthnSession(ctx, "s1", []byte("state"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.TakeWebAuthnSession(ctx, "s1"); err != nil || !bytes.Equal(data, []byte("state")) {
+		t.Fatalf("TakeWebAuthnSession = %q, %v", data, err)
+	}
+	// a challenge is answered once
+	if data, err := store.TakeWebAuthnSession(ctx, "s1"); data != nil || err != nil {
+		t.Fatalf("second TakeWebAuthnSession = %q, %v", data, err)
+	}
+
+	if err := store.SaveWebAuthnSession(ctx, "s2", []byte("state"), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	advance(300 * time.Millisecond)
+	if data, _ := store.TakeWebAuthnSession(ctx, "s2"); data != nil {
+		t.Fatal("expired ceremony returned")
+	}
+}
+
+func otpLimits(t *testing.T, store repository.SessionStore, advance func(time.Duration)) {
+	const phone = "+15550001111"
+	allow := func() bool {
+		t.Helper()
+		ok, err := store.AllowOTPSend(ctx, phone, 100*time.Millisecond, time.Hour, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if !allow() {
+		t.Fatal("first send refused")
+	}
+	if allow() {
+		t.Fatal("send within cooldown allowed")
+	}
+	advance(300 * time.Millisecond)
+	if !allow() {
+		t.Fatal("second send refused")
+	}
+	advance(300 * time.Millisecond)
+	if allow() {
+		t.Fatal("send over the window limit allowed")
+	}
+}
+
+func otpChallenge(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	ch := &domain.OTPChallenge{ID: "o1", TenantID: "acme", Purpose: "login", Phone: "+15550001111", UserID: "u1", CodeHash: "h"}
+	if err := store.SaveOTPChallenge(ctx, ch, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetOTPChallenge(ctx, "o1")
+	if err != nil || got == nil || *got != *ch {
+		t.Fatalf("GetOTPChallenge = %+v, %v", got, err)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		if n, err := store.IncrOTPAttempts(ctx, "o1"); n != want || err != nil {
+			t.Fatalf("IncrOTPAttempts = %d, %v", n, err)
+		}
+	}
+	if got, _ := store.GetOTPChallenge(ctx, "o1"); got == nil || got.Attempts != 2 {
+		t.Fatalf("attempts = %+v", got)
+	}
+	// an expired challenge is not brought back without TTL
+	if n, err := store.IncrOTPAttempts(ctx, "missing"); !errors.Is(err, repository.ErrOTPChallengeNotFound) {
+		t.Fatalf("IncrOTPAttempts(missing) = %d, %v", n, err)
+	}
+	if got, err := store.GetOTPChallenge(ctx, "missing"); got != nil || err != nil {
+		t.Fatalf("GetOTPChallenge(missing) = %+v, %v", got, err)
+	}
+
+	if err := store.DeleteOTPChallenge(ctx, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetOTPChallenge(ctx, "o1"); got != nil || err != nil {
+		t.Fatalf("GetOTPChallenge(deleted) = %+v, %v", got, err)
+	}
+}
+
+func nonces(t *testing.T, store repository.SessionStore, advance func(time.Duration)) {
+	claim := func(service, nonce string) bool {
+		t.Helper()
+		ok, err := store.ClaimNonce(ctx, service, nonce, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if !claim("shop", "n1") {
+		t.Fatal("first use refused")
+	}
+	if claim("shop", "n1") {
+		t.Fatal("replayed nonce accepted")
+	}
+	// nonces are per service
+	if !claim("admin", "n1") {
+		t.Fatal("nonce of another service refused")
+	}
+	advance(300 * time.Millisecond)
+	if !claim("shop", "n1") {
+		t.Fatal("expired nonce still claimed")
+	}
+}
+
+// tenantSessions: the same user and device in two tenants are two sessions.
+func tenantSessions(t *testing.T, store repository.SessionStore, _ func(time.Duration)) {
+	for _, tenantID := range []string{tenant, "acme"} {
+		if _, err := store.SaveLogin(ctx, tenantID, "u1", "d1", "hash-"+tenantID, time.Hour, oldest, ""); err != nil {
+			t.Fatalf("SaveLogin(%s): %v", tenantID, err)
+		}
+	}
+	// each tenant counts its own devices against the limit
+	if evicted, err := store.SaveLogin(ctx, "acme", "u1", "d2", "hash-d2", time.Hour, oldest, ""); err != nil || len(evicted) != 0 {
+		t.Fatalf("SaveLogin(acme, d2) = %v, %v", evicted, err)
+	}
+
+	if ok, _ := store.RefreshSession(ctx, "acme", "u1", "d1", "hash-"+tenant, time.Hour); ok {
+		t.Fatal("refreshed with the token of another tenant")
+	}
+	if ok, _ := store.RefreshSession(ctx, "acme", "u1", "d1", "hash-acme", time.Hour); !ok {
+		t.Fatal("RefreshSession(acme) = false")
+	}
+
+	if err := store.LogoutAll(ctx, "acme", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.ExistsRefreshToken(ctx, "acme", "u1", "d1"); ok {
+		t.Fatal("acme session survived LogoutAll")
+	}
+	if ok, _ := store.ExistsRefreshToken(ctx, tenant, "u1", "d1"); !ok {
+		t.Fatal("LogoutAll(acme) removed the session of the default tenant")
+	}
+	if ids := deviceIDs(t, store, "u1"); fmt.Sprint(ids) != "[d1]" {
+		t.Fatalf("default tenant devices = %v", ids)
+	}
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+)
+
+// SessionStore holds the short-lived state of the service: device sessions
+// (refresh token hash per device, login order, last use), WebAuthn
+// ceremonies and SMS challenges. Postgres stays the source of truth for
+// sessions, a store can be rebuilt from it (see RestoreSession). Sessions
+// are kept apart per tenant, the same user id may exist in several.
+//
+// RedisRepository is the production implementation, MemorySessionStore
+// keeps everything in process for dev mode and tests.
+type SessionStore interface {
+	// Sessions
+	SaveLogin(ctx context.Context, tenantID, userID, deviceID, tokenHash string, ttl time.Duration, p policy.DevicePolicy, replaceDeviceID string) ([]string, error)
+	RefreshSession(ctx context.Context, tenantID, userID, deviceID, tokenHash string, ttl time.Duration) (bool, error)
+	SessionTTL(ctx context.Context, tenantID, userID, deviceID string) (time.Duration, bool, error)
+	SessionTTLs(ctx context.Context, tenantID, userID string, deviceIDs []string) (map[string]time.Duration, error)
+	ExistsRefreshToken(ctx context.Context, tenantID, userID, deviceID string) (bool, error)
+	ReplaceRefreshToken(ctx context.Context, tenantID, userID, deviceID, oldHash, newHash string) (bool, error)
+	GetDevices(ctx context.Context, tenantID, userID string) (map[string]time.Time, error)
+	LogoutDevice(ctx context.Context, tenantID, userID, deviceID string) error
+	LogoutOtherDevices(ctx context.Context, tenantID, userID, keepDeviceID string) ([]string, error)
+	LogoutAll(ctx context.Context, tenantID, userID string) error
+
+	// Reconciliation
+	RestoreSession(ctx context.Context, tenantID, userID, deviceID, tokenHash string, loginAt, lastUsedAt time.Time, ttl time.Duration, grace time.Duration) (int64, error)
+	EachSession(ctx context.Context, batch int64, fn func([]SessionKey) error) error
+
+	// WebAuthn ceremonies
+	SaveWebAuthnSession(ctx context.Context, sessionID string, data []byte, ttl time.Duration) error
+	TakeWebAuthnSession(ctx context.Context, sessionID string) ([]byte, error)
+
+	// SMS one-time passcodes
+	AllowOTPSend(ctx context.Context, phone string, cooldown time.Duration, window time.Duration, maxPerWindow int64) (bool, error)
+	SaveOTPChallenge(ctx context.Context, ch *domain.OTPChallenge, ttl time.Duration) error
+	GetOTPChallenge(ctx context.Context, otpID string) (*domain.OTPChallenge, error)
+	IncrOTPAttempts(ctx context.Context, otpID string) (int64, error)
+	DeleteOTPChallenge(ctx context.Context, otpID string) error
+
+	// Signed service requests
+	ClaimNonce(ctx context.Context, serviceName, nonce string, ttl time.Duration) (bool, error)
+}
@@ -0,0 +1,162 @@
+-- Schema of the single-binary mode, the SQLite counterpart of
+-- internal/migrate/migrations. Times are unix milliseconds.
+
+CREATE TABLE IF NOT EXISTS auth_users (
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    user_id TEXT NOT NULL,
+
+    provider TEXT NOT NULL,
+    provider_user_id TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    phone_number TEXT NULL,
+
+    created_at INTEGER NOT NULL,
+
+    PRIMARY KEY (tenant_id, user_id),
+    UNIQUE (tenant_id, provider, provider_user_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_auth_users_phone
+ON auth_users(tenant_id, phone_number)
+WHERE phone_number IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    user_id TEXT NOT NULL,
+    device_id TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+
+    issued_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    last_used_at INTEGER NULL,
+
+    revoked INTEGER NOT NULL DEFAULT 0,
+    revoked_at INTEGER NULL,
+
+    user_agent TEXT NULL,
+    ip_address TEXT NULL,
+
+    service TEXT NOT NULL DEFAULT 'default',
+
+    created_at INTEGER NOT NULL,
+
+    UNIQUE (tenant_id, user_id, device_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_active
+ON refresh_tokens(tenant_id, user_id, expires_at)
+WHERE revoked = 0;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires
+ON refresh_tokens(expires_at);
+
+CREATE TABLE IF NOT EXISTS session_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    user_id TEXT NOT NULL,
+    device_id TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+
+    issued_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    last_used_at INTEGER NULL,
+
+    revoked INTEGER NOT NULL,
+
+    user_agent TEXT NULL,
+    ip_address TEXT NULL,
+
+    service TEXT NOT NULL DEFAULT 'default',
+
+    ended_at INTEGER NOT NULL,
+    end_reason TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_history_user_device
+ON session_history(tenant_id, user_id, device_id, ended_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_session_history_ended
+ON session_history(ended_at);
+
+CREATE TABLE IF NOT EXISTS session_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    user_id TEXT NOT NULL,
+    device_id TEXT NOT NULL,
+
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    next_attempt_at INTEGER NOT NULL,
+
+    created_at INTEGER NOT NULL,
+    processed_at INTEGER NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_outbox_pending
+ON session_outbox(next_attempt_at)
+WHERE processed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    user_id TEXT NOT NULL,
+    credential_id BLOB NOT NULL UNIQUE,
+    public_key BLOB NOT NULL,
+    attestation_type TEXT NOT NULL DEFAULT 'none',
+    aaguid BLOB NULL,
+
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    clone_warning INTEGER NOT NULL DEFAULT 0,
+    transports TEXT NOT NULL DEFAULT '[]',
+    flags INTEGER NOT NULL DEFAULT 0,
+
+    created_at INTEGER NOT NULL,
+    last_used_at INTEGER NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user
+ON webauthn_credentials(tenant_id, user_id);
+
+CREATE TABLE IF NOT EXISTS service_clients (
+    name TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+
+    allowed_endpoints TEXT NOT NULL DEFAULT '[]',
+    allowed_ips TEXT NOT NULL DEFAULT '[]',
+    disabled INTEGER NOT NULL DEFAULT 0,
+
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS service_client_keys (
+    key_id TEXT PRIMARY KEY,
+
+    service_name TEXT NOT NULL REFERENCES service_clients(name) ON DELETE CASCADE,
+    key_hash TEXT NOT NULL,
+    -- servicesign key, sealed with the server key
+    signing_key BLOB NULL,
+
+    expires_at INTEGER NULL,
+    revoked INTEGER NOT NULL DEFAULT 0,
+    revoked_at INTEGER NULL,
+
+    created_at INTEGER NOT NULL,
+    last_used_at INTEGER NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_client_keys_service
+ON service_client_keys(service_name);
+
+CREATE TABLE IF NOT EXISTS service_client_certs (
+    identity TEXT PRIMARY KEY,
+
+    service_name TEXT NOT NULL REFERENCES service_clients(name) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_client_certs_service
+ON service_client_certs(service_name);
@@ -0,0 +1,522 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/domain"
+)
+
+//go:embed sqlite/schema.sql
+var sqliteSchema string
+
+// SQLiteAuthUserRepository stores users and sessions in one SQLite file for
+// the single-binary mode. It behaves like PostgresAuthUserRepository, the
+// contract suite in repotest runs against both.
+type SQLiteAuthUserRepository struct {
+	db    *sql.DB
+	clock clock.Clock
+	// single process, so a mutex replaces the postgres advisory locks
+	exclusive sync.Mutex
+}
+
+// NewSQLiteAuthUserRepository creates the schema if needed.
+func NewSQLiteAuthUserRepository(db *sql.DB, clk clock.Clock) (AuthUserRepository, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	legacy, err := sqliteBeforeTenants(tx)
+	if err != nil {
+		return nil, err
+	}
+	if legacy {
+		if _, err := tx.Exec(sqliteTenantsPrepare); err != nil {
+			return nil, err
+		}
+		// older files may lack some of these, the schema creates them
+		for _, table := range []string{"session_history", "session_outbox", "webauthn_credentials", "service_clients"} {
+			exists, err := sqliteTableExists(tx, table)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'`); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	// keys issued before signed requests lack the sealed signing key
+	if err := sqliteAddColumn(tx, "service_client_keys", "signing_key", "BLOB NULL"); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(sqliteSchema); err != nil {
+		return nil, err
+	}
+	if legacy {
+		if err := sqliteTenantsCopy(tx); err != nil {
+			return nil, err
+		}
+	}
+	// SMS users were once stored with provider 'phone'
+	if _, err := tx.Exec(`UPDATE auth_users SET provider = 'sms' WHERE provider = 'phone'`); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &SQLiteAuthUserRepository{db: db, clock: clk}, nil
+}
+
+// Files created before tenants have their rows moved to the default tenant.
+// auth_users and refresh_tokens change their keys, SQLite cannot alter
+// those, so they are rebuilt; the other tables get the column added.
+
+const sqliteTenantsPrepare = `
+	ALTER TABLE auth_users RENAME TO auth_users_pre_tenants;
+	ALTER TABLE refresh_tokens RENAME TO refresh_tokens_pre_tenants;
+	DROP INDEX IF EXISTS uq_auth_users_phone;
+	DROP INDEX IF EXISTS idx_refresh_tokens_active;
+	DROP INDEX IF EXISTS idx_refresh_tokens_expires;
+	DROP INDEX IF EXISTS idx_session_history_user_device;
+	DROP INDEX IF EXISTS idx_webauthn_credentials_user;
+`
+
+func sqliteBeforeTenants(tx *sql.Tx) (bool, error) {
+	exists, err := sqliteTableExists(tx, "auth_users")
+	if err != nil || !exists {
+		return false, err
+	}
+	var columns int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('auth_users') WHERE name = 'tenant_id'`).Scan(&columns)
+	return columns == 0, err
+}
+
+func sqliteTableExists(tx *sql.Tx, table string) (bool, error) {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n)
+	return n > 0, err
+}
+
+// sqliteAddColumn adds column to an existing table that lacks it, new files
+// get it from the schema.
+func sqliteAddColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := sqliteTableExists(tx, table)
+	if err != nil || !exists {
+		return err
+	}
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+func sqliteTenantsCopy(tx *sql.Tx) error {
+	for _, table := range []string{"auth_users", "refresh_tokens"} {
+		old := table + "_pre_tenants"
+		rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, old)
+		if err != nil {
+			return err
+		}
+		var columns []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			columns = append(columns, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		list := strings.Join(columns, ", ")
+		if _, err := tx.Exec(`INSERT INTO ` + table + ` (` + list + `) SELECT ` + list + ` FROM ` + old); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DROP TABLE ` + old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// times are stored as unix milliseconds
+
+func toMillis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+func toNullMillis(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.UnixMilli(ms)
+}
+
+func fromNullMillis(ms sql.NullInt64) *time.Time {
+	if !ms.Valid {
+		return nil
+	}
+	t := time.UnixMilli(ms.Int64)
+	return &t
+}
+
+func fromNullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// withTx runs fn in a transaction, committing when it returns nil.
+func (r *SQLiteAuthUserRepository) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AuthUser
+const sqliteUserColumns = `tenant_id, user_id, provider, provider_user_id, email, phone_number`
+
+func scanSQLiteUser(row *sql.Row) (*domain.AuthUser, error) {
+	var (
+		u     domain.AuthUser
+		phone sql.NullString
+	)
+	err := row.Scan(&u.TenantID, &u.UserID, &u.Provider, &u.ProviderID, &u.Email, &phone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.PhoneNumber = fromNullString(phone)
+	return &u, nil
+}
+
+func (r *SQLiteAuthUserRepository) FindByProvider(ctx context.Context, tenantID, provider, providerID string) (*domain.AuthUser, error) {
+	return scanSQLiteUser(r.db.QueryRowContext(ctx,
+		`SELECT `+sqliteUserColumns+` FROM auth_users WHERE tenant_id = ? AND provider = ? AND provider_user_id = ?`,
+		tenantID, provider, providerID,
+	))
+}
+
+func (r *SQLiteAuthUserRepository) Save(ctx context.Context, user *domain.AuthUser) error {
+	const query = `
+		INSERT INTO auth_users (tenant_id, user_id, provider, provider_user_id, email, phone_number, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (tenant_id, user_id) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		user.TenantID, user.UserID, user.Provider, user.ProviderID, user.Email, user.PhoneNumber, toMillis(r.clock.Now()))
+	return err
+}
+
+func (r *SQLiteAuthUserRepository) FindByUserID(ctx context.Context, tenantID, userID string) (*domain.AuthUser, error) {
+	return scanSQLiteUser(r.db.QueryRowContext(ctx,
+		`SELECT `+sqliteUserColumns+` FROM auth_users WHERE tenant_id = ? AND user_id = ?`,
+		tenantID, userID,
+	))
+}
+
+func (r *SQLiteAuthUserRepository) FindByPhone(ctx context.Context, tenantID, phone string) (*domain.AuthUser, error) {
+	return scanSQLiteUser(r.db.QueryRowContext(ctx,
+		`SELECT `+sqliteUserColumns+` FROM auth_users WHERE tenant_id = ? AND phone_number = ?`,
+		tenantID, phone,
+	))
+}
+
+func (r *SQLiteAuthUserRepository) SetPhoneNumber(ctx context.Context, tenantID, userID string, phone string) error {
+	const query = `
+		INSERT INTO auth_users (tenant_id, user_id, provider, provider_user_id, email, phone_number, created_at)
+		VALUES (?, ?, 'external', ?, '', ?, ?)
+		ON CONFLICT (tenant_id, user_id) DO UPDATE SET phone_number = excluded.phone_number
+	`
+	_, err := r.db.ExecContext(ctx, query, tenantID, userID, userID, phone, toMillis(r.clock.Now()))
+	return err
+}
+
+// Refresh Token
+func (r *SQLiteAuthUserRepository) SaveRefreshToken(ctx context.Context, token *domain.RefreshToken) error {
+	const archive = `
+		INSERT INTO session_history
+		(tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+		 revoked, user_agent, ip_address, service, ended_at, end_reason)
+		SELECT tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+		       revoked, user_agent, ip_address, service, ?,
+		       CASE WHEN revoked THEN 'revoked' ELSE 'replaced' END
+		FROM refresh_tokens
+		WHERE tenant_id = ? AND user_id = ? AND device_id = ?
+	`
+
+	const upsert = `
+		INSERT INTO refresh_tokens
+		(tenant_id, user_id, device_id, token_hash, issued_at, expires_at, revoked,
+		 user_agent, ip_address, last_used_at, service, created_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?)
+		ON CONFLICT (tenant_id, user_id, device_id) DO UPDATE SET
+			token_hash   = excluded.token_hash,
+			issued_at    = excluded.issued_at,
+			expires_at   = excluded.expires_at,
+			revoked      = excluded.revoked,
+			revoked_at   = NULL,
+			user_agent   = excluded.user_agent,
+			ip_address   = excluded.ip_address,
+			last_used_at = excluded.last_used_at,
+			service      = excluded.service,
+			created_at   = excluded.created_at
+	`
+
+	now := toMillis(r.clock.Now())
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, archive, now, token.TenantID, token.UserID, token.DeviceID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, upsert,
+			token.TenantID,
+			token.UserID,
+			token.DeviceID,
+			token.TokenHash,
+			toMillis(token.IssuedAt),
+			toMillis(token.ExpiresAt),
+			token.Revoked,
+			token.UserAgent,
+			token.IP,
+			toNullMillis(token.LastUsedAt),
+			token.Service,
+			now,
+		)
+		return err
+	})
+}
+
+// Device Info
+func (r *SQLiteAuthUserRepository) GetLoginDevices(
+	ctx context.Context,
+	tenantID string,
+	userID string,
+	limit int,
+	offset int,
+) ([]domain.LoginDeviceInfo, error) {
+
+	const query = `
+		SELECT device_id, user_agent, ip_address,
+		       issued_at, expires_at, last_used_at, revoked
+		FROM refresh_tokens
+		WHERE tenant_id = ? AND user_id = ?
+		ORDER BY issued_at DESC, device_id
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, tenantID, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.LoginDeviceInfo
+	for rows.Next() {
+		var (
+			info            domain.LoginDeviceInfo
+			ua, ip          sql.NullString
+			issued, expires int64
+			lastUsed        sql.NullInt64
+		)
+		if err := rows.Scan(&info.DeviceID, &ua, &ip, &issued, &expires, &lastUsed, &info.Revoked); err != nil {
+			return nil, err
+		}
+		info.UserAgent = fromNullString(ua)
+		info.IPAddress = fromNullString(ip)
+		info.IssuedAt = fromMillis(issued)
+		info.ExpiresAt = fromMillis(expires)
+		info.LastUsedAt = fromNullMillis(lastUsed)
+		result = append(result, info)
+	}
+	return result, rows.Err()
+}
+
+func (r *SQLiteAuthUserRepository) CountActiveDevices(ctx context.Context, tenantID, userID string) (int, error) {
+	const q = `
+		SELECT COUNT(*)
+		FROM refresh_tokens
+		WHERE tenant_id = ? AND user_id = ? AND revoked = 0 AND expires_at > ?
+	`
+	var count int
+	err := r.db.QueryRowContext(ctx, q, tenantID, userID, toMillis(r.clock.Now())).Scan(&count)
+	return count, err
+}
+
+func (r *SQLiteAuthUserRepository) ListActiveSessions(
+	ctx context.Context,
+	afterTenantID string,
+	afterUserID string,
+	afterDeviceID string,
+	limit int,
+) ([]domain.RefreshToken, error) {
+
+	const q = `
+		SELECT tenant_id, user_id, device_id, token_hash, issued_at, expires_at,
+		       last_used_at, service
+		FROM refresh_tokens
+		WHERE revoked = 0
+		  AND expires_at > ?
+		  AND (tenant_id, user_id, device_id) > (?, ?, ?)
+		ORDER BY tenant_id, user_id, device_id
+		LIMIT ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, q, toMillis(r.clock.Now()), afterTenantID, afterUserID, afterDeviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.RefreshToken
+	for rows.Next() {
+		var (
+			t               domain.RefreshToken
+			issued, expires int64
+			lastUsed        sql.NullInt64
+		)
+		if err := rows.Scan(&t.TenantID, &t.UserID, &t.DeviceID, &t.TokenHash, &issued, &expires, &lastUsed, &t.Service); err != nil {
+			return nil, err
+		}
+		t.IssuedAt = fromMillis(issued)
+		t.ExpiresAt = fromMillis(expires)
+		t.LastUsedAt = fromNullMillis(lastUsed)
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+func (r *SQLiteAuthUserRepository) ActiveDeviceIDs(ctx context.Context, tenantID, userID string) ([]string, error) {
+	const q = `
+		SELECT device_id
+		FROM refresh_tokens
+		WHERE tenant_id = ? AND user_id = ? AND revoked = 0 AND expires_at > ?
+	`
+	return queryStrings(ctx, r.db, q, tenantID, userID, toMillis(r.clock.Now()))
+}
+
+// queryer is *sql.DB or *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryStrings(ctx context.Context, q queryer, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Update & Revoke
+func (r *SQLiteAuthUserRepository) UpdateLastUsedAt(ctx context.Context, tenantID, userID string, deviceID string) (bool, error) {
+	const q = `
+		UPDATE refresh_tokens
+		SET last_used_at = ?
+		WHERE tenant_id = ? AND user_id = ? AND device_id = ? AND revoked = 0
+	`
+	res, err := r.db.ExecContext(ctx, q, toMillis(r.clock.Now()), tenantID, userID, deviceID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *SQLiteAuthUserRepository) UpdateRefreshTokenHash(ctx context.Context, tenantID, userID string, deviceID string, oldHash, newHash string) (bool, error) {
+	const q = `
+		UPDATE refresh_tokens
+		SET token_hash = ?, last_used_at = ?
+		WHERE tenant_id = ? AND user_id = ? AND device_id = ? AND revoked = 0
+		  AND token_hash = ?
+	`
+	res, err := r.db.ExecContext(ctx, q, newHash, toMillis(r.clock.Now()), tenantID, userID, deviceID, oldHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// revoke marks the devices revoked and queues their redis eviction in the
+// same transaction. where starts with the tenant and user conditions.
+// Returns the revoked device ids.
+func (r *SQLiteAuthUserRepository) revoke(ctx context.Context, where string, args ...any) ([]string, error) {
+	var revoked []string
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		ids, err := queryStrings(ctx, tx, `SELECT device_id FROM refresh_tokens WHERE `+where, args...)
+		if err != nil {
+			return err
+		}
+
+		now := toMillis(r.clock.Now())
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET revoked = 1, revoked_at = COALESCE(revoked_at, ?) WHERE `+where,
+			append([]any{now}, args...)...,
+		); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO session_outbox (tenant_id, user_id, device_id, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+				args[0], args[1], id, now, now,
+			); err != nil {
+				return err
+			}
+		}
+		revoked = ids
+		return nil
+	})
+	return revoked, err
+}
+
+func (r *SQLiteAuthUserRepository) RevokeDevice(ctx context.Context, tenantID, userID string, deviceID string) error {
+	_, err := r.revoke(ctx, `tenant_id = ? AND user_id = ? AND device_id = ?`, tenantID, userID, deviceID)
+	return err
+}
+
+func (r *SQLiteAuthUserRepository) RevokeAllDevices(ctx context.Context, tenantID, userID string) ([]string, error) {
+	return r.revoke(ctx, `tenant_id = ? AND user_id = ? AND revoked = 0`, tenantID, userID)
+}
+
+func (r *SQLiteAuthUserRepository) RevokeOtherDevices(ctx context.Context, tenantID, userID string, keepDeviceID string) ([]string, error) {
+	return r.revoke(ctx, `tenant_id = ? AND user_id = ? AND device_id <> ? AND revoked = 0`, tenantID, userID, keepDeviceID)
+}
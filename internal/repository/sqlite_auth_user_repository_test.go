@@ -0,0 +1,95 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/config"
+	"central-auth/internal/domain"
+	"central-auth/internal/repository"
+	"central-auth/internal/repository/repotest"
+)
+
+func newSQLiteRepo(t *testing.T) repository.AuthUserRepository {
+	db, err := config.NewSQLiteConn(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo, err := repository.NewSQLiteAuthUserRepository(db, clock.Real{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestSQLiteAuthUserRepositoryContract(t *testing.T) {
+	repotest.RunAuthUserRepositorySuite(t, newSQLiteRepo)
+}
+
+// Files written before tenants are moved to the default tenant on open.
+func TestSQLiteUpgradesFilesWithoutTenants(t *testing.T) {
+	db, err := config.NewSQLiteConn(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	_, err = db.Exec(`
+		CREATE TABLE auth_users (
+			user_id TEXT PRIMARY KEY,
+			provider TEXT NOT NULL,
+			provider_user_id TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			phone_number TEXT NULL,
+			created_at INTEGER NOT NULL,
+			UNIQUE (provider, provider_user_id)
+		);
+		CREATE UNIQUE INDEX uq_auth_users_phone ON auth_users(phone_number) WHERE phone_number IS NOT NULL;
+		CREATE TABLE refresh_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			token_hash TEXT NOT NULL,
+			issued_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			last_used_at INTEGER NULL,
+			revoked INTEGER NOT NULL DEFAULT 0,
+			revoked_at INTEGER NULL,
+			user_agent TEXT NULL,
+			ip_address TEXT NULL,
+			service TEXT NOT NULL DEFAULT 'default',
+			created_at INTEGER NOT NULL,
+			UNIQUE (user_id, device_id)
+		);
+		CREATE INDEX idx_refresh_tokens_active ON refresh_tokens(user_id, expires_at) WHERE revoked = 0;
+		INSERT INTO auth_users (user_id, provider, provider_user_id, phone_number, created_at)
+		VALUES ('u1', 'google', 'g-1', '+15550001111', 0);
+		INSERT INTO refresh_tokens (user_id, device_id, token_hash, issued_at, expires_at, created_at)
+		VALUES ('u1', 'd1', 'h', 0, ?, 0);
+	`, now.Add(time.Hour).UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := repository.NewSQLiteAuthUserRepository(db, clock.Real{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := repo.FindByPhone(context.Background(), domain.DefaultTenant, "+15550001111")
+	if err != nil || u == nil || u.UserID != "u1" || u.TenantID != domain.DefaultTenant {
+		t.Fatalf("FindByPhone = %+v, %v", u, err)
+	}
+	if n, err := repo.CountActiveDevices(context.Background(), domain.DefaultTenant, "u1"); n != 1 || err != nil {
+		t.Fatalf("CountActiveDevices = %d, %v", n, err)
+	}
+	// the rebuilt keys allow the same user in another tenant
+	if err := repo.SetPhoneNumber(context.Background(), "acme", "u1", "+15550001111"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"central-auth/internal/domain"
+)
+
+// Service Client
+func (r *SQLiteAuthUserRepository) SaveServiceClient(ctx context.Context, client *domain.ServiceClient) error {
+	endpoints, err := json.Marshal(nonNil(client.AllowedEndpoints))
+	if err != nil {
+		return err
+	}
+	ips, err := json.Marshal(nonNil(client.AllowedIPs))
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO service_clients
+		(name, tenant_id, allowed_endpoints, allowed_ips, disabled, created_at)
+		VALUES (?,?,?,?,?,?)
+		ON CONFLICT (name) DO UPDATE
+		SET tenant_id = excluded.tenant_id,
+		    allowed_endpoints = excluded.allowed_endpoints,
+		    allowed_ips = excluded.allowed_ips,
+		    disabled = excluded.disabled
+	`
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, query,
+			client.Name,
+			client.TenantID,
+			string(endpoints),
+			string(ips),
+			client.Disabled,
+			toMillis(client.CreatedAt),
+		); err != nil {
+			return err
+		}
+		// the certificate identities are replaced as a whole
+		if _, err := tx.ExecContext(ctx, `DELETE FROM service_client_certs WHERE service_name = ?`, client.Name); err != nil {
+			return err
+		}
+		for _, identity := range client.CertIdentities {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO service_client_certs (identity, service_name) VALUES (?,?)`,
+				identity, client.Name,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sqliteServiceClientColumns returns the certificate identities as a JSON
+// array, sorted by the scan.
+const sqliteServiceClientColumns = `
+	name, tenant_id, allowed_endpoints, allowed_ips,
+	(SELECT json_group_array(identity) FROM service_client_certs k WHERE k.service_name = c.name),
+	disabled, created_at
+`
+
+func scanSQLiteServiceClient(row rowScanner) (*domain.ServiceClient, error) {
+	var (
+		c         domain.ServiceClient
+		endpoints string
+		ips       string
+		certs     string
+		created   int64
+	)
+	if err := row.Scan(&c.Name, &c.TenantID, &endpoints, &ips, &certs, &c.Disabled, &created); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(endpoints), &c.AllowedEndpoints); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(ips), &c.AllowedIPs); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(certs), &c.CertIdentities); err != nil {
+		return nil, err
+	}
+	slices.Sort(c.CertIdentities)
+	c.CreatedAt = fromMillis(created)
+	return &c, nil
+}
+
+func (r *SQLiteAuthUserRepository) FindServiceClient(ctx context.Context, name string) (*domain.ServiceClient, error) {
+	c, err := scanSQLiteServiceClient(r.db.QueryRowContext(ctx,
+		`SELECT `+sqliteServiceClientColumns+` FROM service_clients c WHERE name = ?`,
+		name,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (r *SQLiteAuthUserRepository) ListServiceClients(ctx context.Context) ([]domain.ServiceClient, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+sqliteServiceClientColumns+` FROM service_clients c ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.ServiceClient
+	for rows.Next() {
+		c, err := scanSQLiteServiceClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *c)
+	}
+	return result, rows.Err()
+}
+
+func (r *SQLiteAuthUserRepository) ServiceNamesByCertIdentity(ctx context.Context, identities []string) ([]string, error) {
+	if len(identities) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(identities))
+	for i, identity := range identities {
+		args[i] = identity
+	}
+	query := `SELECT DISTINCT service_name FROM service_client_certs
+		WHERE identity IN (?` + strings.Repeat(",?", len(identities)-1) + `)
+		ORDER BY service_name`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Service Client Key
+func (r *SQLiteAuthUserRepository) SaveServiceClientKey(ctx context.Context, key *domain.ServiceClientKey) error {
+	const query = `
+		INSERT INTO service_client_keys
+		(key_id, service_name, key_hash, signing_key, expires_at, created_at)
+		VALUES (?,?,?,?,?,?)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		key.KeyID,
+		key.ServiceName,
+		key.KeyHash,
+		key.SigningKey,
+		toNullMillis(key.ExpiresAt),
+		toMillis(key.CreatedAt),
+	)
+	return err
+}
+
+func scanSQLiteServiceClientKey(row rowScanner) (*domain.ServiceClientKey, error) {
+	var (
+		k        domain.ServiceClientKey
+		expires  sql.NullInt64
+		created  int64
+		lastUsed sql.NullInt64
+	)
+	err := row.Scan(
+		&k.KeyID,
+		&k.ServiceName,
+		&k.KeyHash,
+		&k.SigningKey,
+		&expires,
+		&k.Revoked,
+		&created,
+		&lastUsed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	k.ExpiresAt = fromNullMillis(expires)
+	k.CreatedAt = fromMillis(created)
+	k.LastUsedAt = fromNullMillis(lastUsed)
+	return &k, nil
+}
+
+func (r *SQLiteAuthUserRepository) FindServiceClientKey(ctx context.Context, keyID string) (*domain.ServiceClientKey, error) {
+	k, err := scanSQLiteServiceClientKey(r.db.QueryRowContext(ctx,
+		`SELECT `+serviceClientKeyColumns+` FROM service_client_keys WHERE key_id = ?`,
+		keyID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (r *SQLiteAuthUserRepository) ListServiceClientKeys(ctx context.Context, serviceName string) ([]domain.ServiceClientKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+serviceClientKeyColumns+` FROM service_client_keys WHERE service_name = ? ORDER BY created_at, key_id`,
+		serviceName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.ServiceClientKey
+	for rows.Next() {
+		k, err := scanSQLiteServiceClientKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *k)
+	}
+	return result, rows.Err()
+}
+
+func (r *SQLiteAuthUserRepository) RevokeServiceClientKey(ctx context.Context, keyID string) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE service_client_keys SET revoked = 1, revoked_at = ? WHERE key_id = ? AND revoked = 0`,
+		toMillis(r.clock.Now()), keyID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TouchServiceClientKey records a use of the key, at most once a minute.
+func (r *SQLiteAuthUserRepository) TouchServiceClientKey(ctx context.Context, keyID string, at time.Time) error {
+	const q = `
+		UPDATE service_client_keys
+		SET last_used_at = ?
+		WHERE key_id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`
+	_, err := r.db.ExecContext(ctx, q, toMillis(at), keyID, toMillis(at.Add(-time.Minute)))
+	return err
+}
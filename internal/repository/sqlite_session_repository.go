@@ -0,0 +1,322 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"central-auth/internal/domain"
+)
+
+// Session Outbox
+func (r *SQLiteAuthUserRepository) ClaimSessionOutbox(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) ([]domain.SessionOutboxEvent, error) {
+
+	const pending = `
+		SELECT o.id, o.tenant_id, o.user_id, o.device_id, o.attempts, o.created_at,
+		       COALESCE(rt.revoked, 1)
+		FROM session_outbox o
+		LEFT JOIN refresh_tokens rt
+		  ON rt.tenant_id = o.tenant_id
+		 AND rt.user_id = o.user_id
+		 AND rt.device_id = o.device_id
+		WHERE o.processed_at IS NULL AND o.next_attempt_at <= ?
+		ORDER BY o.id
+		LIMIT ?
+	`
+
+	var events []domain.SessionOutboxEvent
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		now := r.clock.Now()
+		rows, err := tx.QueryContext(ctx, pending, toMillis(now), limit)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var (
+				ev      domain.SessionOutboxEvent
+				created int64
+			)
+			if err := rows.Scan(&ev.ID, &ev.TenantID, &ev.UserID, &ev.DeviceID, &ev.Attempts, &created, &ev.Revoked); err != nil {
+				rows.Close()
+				return err
+			}
+			ev.CreatedAt = fromMillis(created)
+			events = append(events, ev)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, ev := range events {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE session_outbox SET next_attempt_at = ? WHERE id = ?`,
+				toMillis(now.Add(lease)), ev.ID,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (r *SQLiteAuthUserRepository) CompleteSessionOutbox(ctx context.Context, tenantID, userID string, deviceIDs []string) error {
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		now := toMillis(r.clock.Now())
+		for _, deviceID := range deviceIDs {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE session_outbox
+				SET processed_at = ?, last_error = NULL
+				WHERE tenant_id = ? AND user_id = ? AND device_id = ? AND processed_at IS NULL
+			`, now, tenantID, userID, deviceID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *SQLiteAuthUserRepository) FailSessionOutbox(ctx context.Context, id int64, lastError string, retryAt time.Time) error {
+	const q = `
+		UPDATE session_outbox
+		SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+		WHERE id = ?
+	`
+	_, err := r.db.ExecContext(ctx, q, lastError, toMillis(retryAt), id)
+	return err
+}
+
+// Janitor
+func (r *SQLiteAuthUserRepository) ArchiveFinishedSessions(
+	ctx context.Context,
+	now time.Time,
+	before time.Time,
+	limit int,
+	archive bool,
+) (int64, error) {
+
+	const batch = `
+		SELECT id FROM refresh_tokens
+		WHERE expires_at < ?1
+		   OR (revoked = 1 AND COALESCE(revoked_at, issued_at) < ?1)
+		ORDER BY id
+		LIMIT ?2
+	`
+
+	var removed int64
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if archive {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO session_history
+				(tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+				 revoked, user_agent, ip_address, service, ended_at, end_reason)
+				SELECT tenant_id, user_id, device_id, token_hash, issued_at, expires_at, last_used_at,
+				       revoked, user_agent, ip_address, service, ?3,
+				       CASE WHEN revoked THEN 'revoked' ELSE 'expired' END
+				FROM refresh_tokens
+				WHERE id IN (`+batch+`)
+			`, toMillis(before), limit, toMillis(now)); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx,
+			`DELETE FROM refresh_tokens WHERE id IN (`+batch+`)`,
+			toMillis(before), limit,
+		)
+		if err != nil {
+			return err
+		}
+		removed, err = res.RowsAffected()
+		return err
+	})
+	return removed, err
+}
+
+func (r *SQLiteAuthUserRepository) PruneSessionOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	const q = `
+		DELETE FROM session_outbox
+		WHERE id IN (
+			SELECT id FROM session_outbox
+			WHERE processed_at < ?
+			LIMIT ?
+		)
+	`
+	res, err := r.db.ExecContext(ctx, q, toMillis(before), limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// EnsureHistoryPartitions is a no-op, session_history is a plain table.
+func (r *SQLiteAuthUserRepository) EnsureHistoryPartitions(ctx context.Context, from time.Time, months int) error {
+	return nil
+}
+
+// DropHistoryPartitions deletes the history rows of the months that end
+// before `before`, the same rows the postgres partitions would hold. Returns
+// the "session_history_YYYYMM" names of the months it emptied.
+func (r *SQLiteAuthUserRepository) DropHistoryPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	month := time.Date(before.Year(), before.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var oldest sql.NullInt64
+	if err := r.db.QueryRowContext(ctx,
+		`SELECT MIN(ended_at) FROM session_history WHERE ended_at < ?`, toMillis(month),
+	).Scan(&oldest); err != nil {
+		return nil, err
+	}
+	if !oldest.Valid {
+		return nil, nil
+	}
+	if _, err := r.db.ExecContext(ctx,
+		`DELETE FROM session_history WHERE ended_at < ?`, toMillis(month),
+	); err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	first := fromMillis(oldest.Int64).UTC()
+	for m := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC); m.Before(month); m = m.AddDate(0, 1, 0) {
+		dropped = append(dropped, historyPartitionPrefix+m.Format("200601"))
+	}
+	return dropped, nil
+}
+
+// RunExclusive only guards against concurrent runs in this process, the
+// database file is not shared between replicas.
+func (r *SQLiteAuthUserRepository) RunExclusive(
+	ctx context.Context,
+	lockID int64,
+	fn func(ctx context.Context) error,
+) (bool, error) {
+	if !r.exclusive.TryLock() {
+		return false, nil
+	}
+	defer r.exclusive.Unlock()
+	return true, fn(ctx)
+}
+
+// WebAuthn Credential
+func (r *SQLiteAuthUserRepository) SaveWebAuthnCredential(ctx context.Context, cred *domain.WebAuthnCredential) error {
+	transports, err := json.Marshal(cred.Transports)
+	if err != nil {
+		return err
+	}
+	if cred.Transports == nil {
+		transports = []byte("[]")
+	}
+
+	const query = `
+		INSERT INTO webauthn_credentials
+		(tenant_id, user_id, credential_id, public_key, attestation_type, aaguid,
+		 sign_count, clone_warning, transports, flags, created_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?)
+	`
+	_, err = r.db.ExecContext(ctx, query,
+		cred.TenantID,
+		cred.UserID,
+		cred.CredentialID,
+		cred.PublicKey,
+		cred.AttestationType,
+		cred.AAGUID,
+		int64(cred.SignCount),
+		cred.CloneWarning,
+		string(transports),
+		int64(cred.Flags),
+		toMillis(cred.CreatedAt),
+	)
+	return err
+}
+
+// rowScanner is *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSQLiteWebAuthnCredential(row rowScanner) (*domain.WebAuthnCredential, error) {
+	var (
+		c          domain.WebAuthnCredential
+		signCount  int64
+		flags      int64
+		transports string
+		created    int64
+		lastUsed   sql.NullInt64
+	)
+	err := row.Scan(
+		&c.TenantID,
+		&c.UserID,
+		&c.CredentialID,
+		&c.PublicKey,
+		&c.AttestationType,
+		&c.AAGUID,
+		&signCount,
+		&c.CloneWarning,
+		&transports,
+		&flags,
+		&created,
+		&lastUsed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(transports), &c.Transports); err != nil {
+		return nil, err
+	}
+	c.SignCount = uint32(signCount)
+	c.Flags = uint8(flags)
+	c.CreatedAt = fromMillis(created)
+	c.LastUsedAt = fromNullMillis(lastUsed)
+	return &c, nil
+}
+
+func (r *SQLiteAuthUserRepository) GetWebAuthnCredentials(ctx context.Context, tenantID, userID string) ([]domain.WebAuthnCredential, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+webauthnColumns+` FROM webauthn_credentials WHERE tenant_id = ? AND user_id = ? ORDER BY created_at, id`,
+		tenantID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.WebAuthnCredential
+	for rows.Next() {
+		c, err := scanSQLiteWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *c)
+	}
+	return result, rows.Err()
+}
+
+func (r *SQLiteAuthUserRepository) FindWebAuthnCredential(ctx context.Context, tenantID string, credentialID []byte) (*domain.WebAuthnCredential, error) {
+	c, err := scanSQLiteWebAuthnCredential(r.db.QueryRowContext(ctx,
+		`SELECT `+webauthnColumns+` FROM webauthn_credentials WHERE tenant_id = ? AND credential_id = ?`,
+		tenantID, credentialID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (r *SQLiteAuthUserRepository) UpdateWebAuthnSignCount(ctx context.Context, credentialID []byte, signCount uint32, cloneWarning bool) error {
+	const q = `
+		UPDATE webauthn_credentials
+		SET sign_count = ?, clone_warning = ?, last_used_at = ?
+		WHERE credential_id = ?
+	`
+	_, err := r.db.ExecContext(ctx, q, int64(signCount), cloneWarning, toMillis(r.clock.Now()), credentialID)
+	return err
+}
@@ -0,0 +1,418 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+)
+
+// bounded limits each call to one dependency to timeout (or the caller's
+// deadline when that is earlier) and classifies its failures.
+type bounded struct {
+	dependency string
+	timeout    time.Duration
+}
+
+func (b bounded) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, b.timeout)
+}
+
+func (b bounded) classify(err error) error {
+	return classify(b.dependency, err)
+}
+
+// WithSessionStoreTimeout bounds every call to store by timeout. Failures
+// from timeouts and lost connections become DependencyErrors of dependency.
+// EachSession is not bounded as a whole, its callback may run for long.
+func WithSessionStoreTimeout(store SessionStore, dependency string, timeout time.Duration) SessionStore {
+	return &boundedSessionStore{next: store, bounded: bounded{dependency, timeout}}
+}
+
+type boundedSessionStore struct {
+	bounded
+	next SessionStore
+}
+
+func (s *boundedSessionStore) SaveLogin(ctx context.Context, tenantID, userID, deviceID, tokenHash string, ttl time.Duration, p policy.DevicePolicy, replaceDeviceID string) ([]string, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.SaveLogin(ctx, tenantID, userID, deviceID, tokenHash, ttl, p, replaceDeviceID)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) RefreshSession(ctx context.Context, tenantID, userID, deviceID, tokenHash string, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.RefreshSession(ctx, tenantID, userID, deviceID, tokenHash, ttl)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) SessionTTL(ctx context.Context, tenantID, userID, deviceID string) (time.Duration, bool, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	ttl, ok, err := s.next.SessionTTL(ctx, tenantID, userID, deviceID)
+	return ttl, ok, s.classify(err)
+}
+
+func (s *boundedSessionStore) SessionTTLs(ctx context.Context, tenantID, userID string, deviceIDs []string) (map[string]time.Duration, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.SessionTTLs(ctx, tenantID, userID, deviceIDs)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) ExistsRefreshToken(ctx context.Context, tenantID, userID, deviceID string) (bool, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.ExistsRefreshToken(ctx, tenantID, userID, deviceID)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) ReplaceRefreshToken(ctx context.Context, tenantID, userID, deviceID, oldHash, newHash string) (bool, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.ReplaceRefreshToken(ctx, tenantID, userID, deviceID, oldHash, newHash)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) GetDevices(ctx context.Context, tenantID, userID string) (map[string]time.Time, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.GetDevices(ctx, tenantID, userID)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) LogoutDevice(ctx context.Context, tenantID, userID, deviceID string) error {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	return s.classify(s.next.LogoutDevice(ctx, tenantID, userID, deviceID))
+}
+
+func (s *boundedSessionStore) LogoutOtherDevices(ctx context.Context, tenantID, userID, keepDeviceID string) ([]string, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.LogoutOtherDevices(ctx, tenantID, userID, keepDeviceID)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) LogoutAll(ctx context.Context, tenantID, userID string) error {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	return s.classify(s.next.LogoutAll(ctx, tenantID, userID))
+}
+
+func (s *boundedSessionStore) RestoreSession(ctx context.Context, tenantID, userID, deviceID, tokenHash string, loginAt, lastUsedAt time.Time, ttl time.Duration, grace time.Duration) (int64, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.RestoreSession(ctx, tenantID, userID, deviceID, tokenHash, loginAt, lastUsedAt, ttl, grace)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) EachSession(ctx context.Context, batch int64, fn func([]SessionKey) error) error {
+	return s.classify(s.next.EachSession(ctx, batch, fn))
+}
+
+func (s *boundedSessionStore) SaveWebAuthnSession(ctx context.Context, sessionID string, data []byte, ttl time.Duration) error {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	return s.classify(s.next.SaveWebAuthnSession(ctx, sessionID, data, ttl))
+}
+
+func (s *boundedSessionStore) TakeWebAuthnSession(ctx context.Context, sessionID string) ([]byte, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.TakeWebAuthnSession(ctx, sessionID)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) AllowOTPSend(ctx context.Context, phone string, cooldown time.Duration, window time.Duration, maxPerWindow int64) (bool, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.AllowOTPSend(ctx, phone, cooldown, window, maxPerWindow)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) SaveOTPChallenge(ctx context.Context, ch *domain.OTPChallenge, ttl time.Duration) error {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	return s.classify(s.next.SaveOTPChallenge(ctx, ch, ttl))
+}
+
+func (s *boundedSessionStore) GetOTPChallenge(ctx context.Context, otpID string) (*domain.OTPChallenge, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.GetOTPChallenge(ctx, otpID)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) IncrOTPAttempts(ctx context.Context, otpID string) (int64, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.IncrOTPAttempts(ctx, otpID)
+	return v, s.classify(err)
+}
+
+func (s *boundedSessionStore) DeleteOTPChallenge(ctx context.Context, otpID string) error {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	return s.classify(s.next.DeleteOTPChallenge(ctx, otpID))
+}
+
+func (s *boundedSessionStore) ClaimNonce(ctx context.Context, serviceName, nonce string, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.bound(ctx)
+	defer cancel()
+	v, err := s.next.ClaimNonce(ctx, serviceName, nonce, ttl)
+	return v, s.classify(err)
+}
+
+// WithAuthUserRepositoryTimeout bounds every call to repo by timeout like
+// WithSessionStoreTimeout. RunExclusive holds its lock for the whole job and
+// is not bounded.
+func WithAuthUserRepositoryTimeout(repo AuthUserRepository, dependency string, timeout time.Duration) AuthUserRepository {
+	return &boundedAuthUserRepository{next: repo, bounded: bounded{dependency, timeout}}
+}
+
+type boundedAuthUserRepository struct {
+	bounded
+	next AuthUserRepository
+}
+
+func (r *boundedAuthUserRepository) FindByProvider(ctx context.Context, tenantID, provider, providerID string) (*domain.AuthUser, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.FindByProvider(ctx, tenantID, provider, providerID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) Save(ctx context.Context, user *domain.AuthUser) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.Save(ctx, user))
+}
+
+func (r *boundedAuthUserRepository) FindByUserID(ctx context.Context, tenantID, userID string) (*domain.AuthUser, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.FindByUserID(ctx, tenantID, userID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) FindByPhone(ctx context.Context, tenantID, phone string) (*domain.AuthUser, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.FindByPhone(ctx, tenantID, phone)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) SetPhoneNumber(ctx context.Context, tenantID, userID string, phone string) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.SetPhoneNumber(ctx, tenantID, userID, phone))
+}
+
+func (r *boundedAuthUserRepository) SaveRefreshToken(ctx context.Context, token *domain.RefreshToken) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.SaveRefreshToken(ctx, token))
+}
+
+func (r *boundedAuthUserRepository) UpdateLastUsedAt(ctx context.Context, tenantID, userID string, deviceID string) (bool, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.UpdateLastUsedAt(ctx, tenantID, userID, deviceID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) UpdateRefreshTokenHash(ctx context.Context, tenantID, userID string, deviceID string, oldHash, newHash string) (bool, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.UpdateRefreshTokenHash(ctx, tenantID, userID, deviceID, oldHash, newHash)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) RevokeDevice(ctx context.Context, tenantID, userID string, deviceID string) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.RevokeDevice(ctx, tenantID, userID, deviceID))
+}
+
+func (r *boundedAuthUserRepository) RevokeAllDevices(ctx context.Context, tenantID, userID string) ([]string, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.RevokeAllDevices(ctx, tenantID, userID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) RevokeOtherDevices(ctx context.Context, tenantID, userID string, keepDeviceID string) ([]string, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.RevokeOtherDevices(ctx, tenantID, userID, keepDeviceID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) GetLoginDevices(ctx context.Context, tenantID, userID string, limit int, offset int) ([]domain.LoginDeviceInfo, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.GetLoginDevices(ctx, tenantID, userID, limit, offset)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) CountActiveDevices(ctx context.Context, tenantID, userID string) (int, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.CountActiveDevices(ctx, tenantID, userID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) ListActiveSessions(ctx context.Context, afterTenantID, afterUserID string, afterDeviceID string, limit int) ([]domain.RefreshToken, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.ListActiveSessions(ctx, afterTenantID, afterUserID, afterDeviceID, limit)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) ActiveDeviceIDs(ctx context.Context, tenantID, userID string) ([]string, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.ActiveDeviceIDs(ctx, tenantID, userID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) ClaimSessionOutbox(ctx context.Context, limit int, lease time.Duration) ([]domain.SessionOutboxEvent, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.ClaimSessionOutbox(ctx, limit, lease)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) CompleteSessionOutbox(ctx context.Context, tenantID, userID string, deviceIDs []string) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.CompleteSessionOutbox(ctx, tenantID, userID, deviceIDs))
+}
+
+func (r *boundedAuthUserRepository) FailSessionOutbox(ctx context.Context, id int64, lastError string, retryAt time.Time) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.FailSessionOutbox(ctx, id, lastError, retryAt))
+}
+
+func (r *boundedAuthUserRepository) ArchiveFinishedSessions(ctx context.Context, now, before time.Time, limit int, archive bool) (int64, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.ArchiveFinishedSessions(ctx, now, before, limit, archive)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) PruneSessionOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.PruneSessionOutbox(ctx, before, limit)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) EnsureHistoryPartitions(ctx context.Context, from time.Time, months int) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.EnsureHistoryPartitions(ctx, from, months))
+}
+
+func (r *boundedAuthUserRepository) DropHistoryPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.DropHistoryPartitions(ctx, before)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) RunExclusive(ctx context.Context, lockID int64, fn func(ctx context.Context) error) (bool, error) {
+	v, err := r.next.RunExclusive(ctx, lockID, fn)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) SaveWebAuthnCredential(ctx context.Context, cred *domain.WebAuthnCredential) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.SaveWebAuthnCredential(ctx, cred))
+}
+
+func (r *boundedAuthUserRepository) GetWebAuthnCredentials(ctx context.Context, tenantID, userID string) ([]domain.WebAuthnCredential, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.GetWebAuthnCredentials(ctx, tenantID, userID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) FindWebAuthnCredential(ctx context.Context, tenantID string, credentialID []byte) (*domain.WebAuthnCredential, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.FindWebAuthnCredential(ctx, tenantID, credentialID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) UpdateWebAuthnSignCount(ctx context.Context, credentialID []byte, signCount uint32, cloneWarning bool) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.UpdateWebAuthnSignCount(ctx, credentialID, signCount, cloneWarning))
+}
+
+func (r *boundedAuthUserRepository) SaveServiceClient(ctx context.Context, client *domain.ServiceClient) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.SaveServiceClient(ctx, client))
+}
+
+func (r *boundedAuthUserRepository) FindServiceClient(ctx context.Context, name string) (*domain.ServiceClient, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.FindServiceClient(ctx, name)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) ListServiceClients(ctx context.Context) ([]domain.ServiceClient, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.ListServiceClients(ctx)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) ServiceNamesByCertIdentity(ctx context.Context, identities []string) ([]string, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.ServiceNamesByCertIdentity(ctx, identities)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) SaveServiceClientKey(ctx context.Context, key *domain.ServiceClientKey) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.SaveServiceClientKey(ctx, key))
+}
+
+func (r *boundedAuthUserRepository) FindServiceClientKey(ctx context.Context, keyID string) (*domain.ServiceClientKey, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.FindServiceClientKey(ctx, keyID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) ListServiceClientKeys(ctx context.Context, serviceName string) ([]domain.ServiceClientKey, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.ListServiceClientKeys(ctx, serviceName)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) RevokeServiceClientKey(ctx context.Context, keyID string) (bool, error) {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	v, err := r.next.RevokeServiceClientKey(ctx, keyID)
+	return v, r.classify(err)
+}
+
+func (r *boundedAuthUserRepository) TouchServiceClientKey(ctx context.Context, keyID string, at time.Time) error {
+	ctx, cancel := r.bound(ctx)
+	defer cancel()
+	return r.classify(r.next.TouchServiceClientKey(ctx, keyID, at))
+}
@@ -0,0 +1,95 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/repository"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// hangingStore blocks ExistsRefreshToken until its context ends.
+type hangingStore struct {
+	repository.SessionStore
+}
+
+func (hangingStore) ExistsRefreshToken(ctx context.Context, _, _, _ string) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func TestSessionStoreTimeout(t *testing.T) {
+	store := repository.WithSessionStoreTimeout(hangingStore{}, "redis", 20*time.Millisecond)
+
+	_, err := store.ExistsRefreshToken(context.Background(), "default", "u1", "d1")
+	if !errors.Is(err, repository.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want timeout, got %v", err)
+	}
+	var depErr *repository.DependencyError
+	if !errors.As(err, &depErr) || depErr.Dependency != "redis" {
+		t.Fatalf("want redis DependencyError, got %v", err)
+	}
+}
+
+func TestSessionStoreCanceledIsNotDependencyError(t *testing.T) {
+	store := repository.WithSessionStoreTimeout(hangingStore{}, "redis", time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := store.ExistsRefreshToken(ctx, "default", "u1", "d1")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, repository.ErrTimeout) || errors.Is(err, repository.ErrUnavailable) {
+		t.Fatalf("want plain cancellation, got %v", err)
+	}
+}
+
+func TestSessionStoreUnavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// no retries, they would run into the deadline first
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	store := repository.WithSessionStoreTimeout(repository.NewRedisRepository(client, clock.Real{}), "redis", time.Second)
+	mr.Close()
+
+	_, err := store.ExistsRefreshToken(context.Background(), "default", "u1", "d1")
+	if !errors.Is(err, repository.ErrUnavailable) {
+		t.Fatalf("want unavailable, got %v", err)
+	}
+}
+
+// A Postgres that accepts connections and never answers is a timeout, not
+// a user that does not exist.
+func TestPostgresFindByProviderTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	pool, err := pgxpool.New(context.Background(), "postgres://u:p@"+ln.Addr().String()+"/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	repo := repository.WithAuthUserRepositoryTimeout(repository.NewPostgresAuthUserRepository(pool), "postgres", 50*time.Millisecond)
+
+	user, err := repo.FindByProvider(context.Background(), "default", "google", "g1")
+	if user != nil || !errors.Is(err, repository.ErrTimeout) {
+		t.Fatalf("want timeout, got %+v, %v", user, err)
+	}
+}
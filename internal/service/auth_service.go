@@ -3,61 +3,213 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
+	"central-auth/internal/audit"
+	"central-auth/internal/clock"
 	"central-auth/internal/domain"
+	"central-auth/internal/ids"
+	"central-auth/internal/policy"
 	"central-auth/internal/repository"
 	"central-auth/internal/token"
 
-	"github.com/google/uuid"
-)
-
-const (
-	AccessTokenTTL  = time.Minute * 15
-	RefreshTTLShort = time.Hour * 24 * 7
-	RefreshTTLLong  = time.Hour * 24 * 30
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type AuthService struct {
-	redisRepo    *repository.RedisRepository
-	authUserRepo repository.AuthUserRepository
+	sessionStore    repository.SessionStore
+	authUserRepo    repository.AuthUserRepository
+	auditor         audit.Recorder
+	devicePolicies  *policy.DevicePolicies
+	sessionPolicies *policy.SessionPolicies
+	tokenLifetimes  *policy.TokenLifetimes
+	tenants         policy.TenantPolicies
+	clock           clock.Clock
+	ids             ids.Generator
 }
 
 func NewAuthService(
-	redisRepo *repository.RedisRepository,
+	sessionStore repository.SessionStore,
 	authUserRepo repository.AuthUserRepository,
+	auditor audit.Recorder,
+	devicePolicies *policy.DevicePolicies,
+	sessionPolicies *policy.SessionPolicies,
+	tokenLifetimes *policy.TokenLifetimes,
+	tenants policy.TenantPolicies,
+	clk clock.Clock,
+	idGen ids.Generator,
 ) *AuthService {
-	return &AuthService{redisRepo: redisRepo, authUserRepo: authUserRepo}
+	return &AuthService{
+		sessionStore:    sessionStore,
+		authUserRepo:    authUserRepo,
+		auditor:         auditor,
+		devicePolicies:  devicePolicies,
+		sessionPolicies: sessionPolicies,
+		tokenLifetimes:  tokenLifetimes,
+		tenants:         tenants,
+		clock:           clk,
+		ids:             idGen,
+	}
 }
 
-// accessToken : 15min, refreshToken : 7 days, rememberMe : 30 days
-func (s *AuthService) Login(userID string, deviceID string, rememberMe bool, userAgent *string, ip *string) (string, string, error) {
-	log.Printf("[AUTH] Login start user=%s device=%s", userID, deviceID)
-	accessToken, err := token.Generate(userID, deviceID, AccessTokenTTL)
-	if err != nil {
-		return "", "", err
+// ErrInvalidRefreshToken: the session is gone, revoked or holds a newer
+// refresh token than the one presented.
+var ErrInvalidRefreshToken = errors.New("refresh token expired or revoked")
+
+// ErrReauthRequired: the session reached its absolute lifetime.
+var ErrReauthRequired = errors.New("session reached its maximum lifetime, re-authentication required")
+
+// ErrReplaceDeviceNotFound: replace_device_id does not name an active device.
+var ErrReplaceDeviceNotFound = repository.ErrReplaceDeviceNotFound
+
+// ErrInvalidAMR: the calling backend asserted an unknown authentication
+// method, or one only central-auth may set (hwk, user, mfa).
+var ErrInvalidAMR = errors.New("invalid amr")
+
+// ErrProviderNotAllowed: the tenant does not accept this login method.
+var ErrProviderNotAllowed = errors.New("login method not allowed for this tenant")
+
+// ErrDependencyTimeout and ErrDependencyUnavailable: Redis or the database
+// did not answer in time or could not be reached.
+var (
+	ErrDependencyTimeout     = repository.ErrTimeout
+	ErrDependencyUnavailable = repository.ErrUnavailable
+)
+
+// LoginOptions selects the tenant and the device policy of a login.
+type LoginOptions struct {
+	Tenant          string // tenant of the calling service, default when empty
+	Provider        string // login method, checked against the tenant's allowed providers
+	Service         string // calling service
+	Tier            string // user tier asserted by the calling service
+	ReplaceDeviceID string // device the user chose to log out (require_choice)
+}
+
+type LoginResult struct {
+	AccessToken    string
+	RefreshToken   string
+	EvictedDevices []string
+}
+
+// DeviceLimitError is returned by Login when the device policy refuses the
+// new device. Devices is filled for require_choice so the user can pick one.
+type DeviceLimitError struct {
+	Strategy   string
+	MaxDevices int64
+	Devices    []domain.LoginDeviceInfo
+}
+
+func (e *DeviceLimitError) Error() string {
+	return "device limit reached"
+}
+
+// Token TTLs follow the lifetime of the calling service and tenant, by
+// default accessToken : 15min, refreshToken : 7 days, rememberMe : 30 days
+func (s *AuthService) Login(
+	ctx context.Context,
+	userID string,
+	deviceID string,
+	rememberMe bool,
+	auth token.AuthInfo,
+	opts LoginOptions,
+	userAgent *string,
+	ip *string,
+) (*LoginResult, error) {
+	tenantID := tenantOrDefault(opts.Tenant)
+	log.Printf("[AUTH] Login start tenant=%s user=%s device=%s acr=%s", tenantID, userID, deviceID, auth.ACR)
+
+	tenant := s.tenants.Resolve(tenantID)
+	if !tenant.AllowsProvider(opts.Provider) {
+		log.Printf("[WARN] Provider %s not allowed tenant=%s", opts.Provider, tenantID)
+		return nil, ErrProviderNotAllowed
+	}
+
+	lifetime := s.lifetime(tenantID, opts.Service)
+	if rememberMe && !lifetime.AllowsRememberMe() {
+		log.Printf("[AUTH] Remember me ignored service=%s tenant=%s", opts.Service, tenantID)
 	}
+	refreshTTL := lifetime.SessionTTL(rememberMe)
+
+	// the absolute lifetime caps the refresh TTL, the idle timeout decides
+	// how long the session survives without a refresh
+	now := s.clock.Now()
+	sessionPolicy := s.sessionPolicies.Resolve(opts.Service)
+	if abs := sessionPolicy.AbsoluteLifetime.Std(); abs > 0 && abs < refreshTTL {
+		refreshTTL = abs
+	}
+	auth.SessionExpiresAt = now.Add(refreshTTL)
+	auth.Service = opts.Service
+	auth.Tenant = tenantID
+	idleTTL := idleWindow(sessionPolicy, refreshTTL)
 
-	refreshTTL := RefreshTTLShort
-	if rememberMe {
-		refreshTTL = RefreshTTLLong
+	accessToken, err := token.Generate(userID, deviceID, now, min(lifetime.AccessTTL.Std(), refreshTTL), auth)
+	if err != nil {
+		return nil, err
 	}
 
-	refreshToken, err := token.Generate(userID, deviceID, refreshTTL)
+	refreshToken, err := token.Generate(userID, deviceID, now, refreshTTL, auth)
 	if err != nil {
 		log.Printf("[ERROR] Generate refresh token failed: %+v", err)
-		return "", "", err
+		return nil, err
 	}
 
-	if err := s.redisRepo.SaveLogin(userID, deviceID, refreshToken, refreshTTL); err != nil {
+	devicePolicies := s.devicePolicies
+	if tenant.Devices != nil {
+		devicePolicies = tenant.Devices
+	}
+	devicePolicy := devicePolicies.Resolve(opts.Service, opts.Tier)
+	evicted, err := s.sessionStore.SaveLogin(ctx, tenantID, userID, deviceID, token.Hash(refreshToken), idleTTL, devicePolicy, opts.ReplaceDeviceID)
+	if err != nil {
+		var limitErr *repository.DeviceLimitError
+		if errors.As(err, &limitErr) {
+			log.Printf("[WARN] Device limit reached user=%s device=%s strategy=%s", userID, deviceID, devicePolicy.Strategy)
+			return nil, s.deviceLimitError(ctx, tenantID, userID, limitErr)
+		}
+		if errors.Is(err, repository.ErrReplaceDeviceNotFound) {
+			return nil, err
+		}
 		log.Printf("[ERROR] Redis SaveLogin failed: %+v", err)
-		return "", "", err
+		return nil, err
+	}
+
+	// Login is the one write that goes to redis first: the device limit is
+	// decided atomically by the SaveLogin script, and only after it the
+	// evicted devices and the new session are known. Writing postgres first
+	// would activate sessions the limit then refuses, and replace the
+	// device's previous session before the login is accepted. A failed
+	// postgres write below removes the redis session again, a crash in
+	// between leaves an orphan the reconciler evicts after ReconcileGrace.
+	// evicted devices must not stay active in postgres
+	for _, evictedID := range evicted {
+		if err := s.authUserRepo.RevokeDevice(ctx, tenantID, userID, evictedID); err != nil {
+			log.Printf("[ERROR] Postgres RevokeDevice (evicted) failed: %+v", err)
+			s.rollbackLogin(ctx, tenantID, userID, deviceID)
+			return nil, err
+		}
+		s.auditor.Record(audit.Event{
+			Time:          now,
+			Type:          audit.EventDeviceEvicted,
+			UserID:        userID,
+			DeviceID:      evictedID,
+			ActorDeviceID: deviceID,
+			IP:            deref(ip),
+			Details: map[string]any{
+				"strategy":    devicePolicy.Strategy,
+				"max_devices": devicePolicy.MaxDevices,
+				"service":     opts.Service,
+				"tier":        opts.Tier,
+				"tenant":      tenantID,
+			},
+		})
 	}
+	// the lua script already removed them from redis
+	s.completeOutbox(ctx, tenantID, userID, evicted)
 
-	// stored postgres
-	now := time.Now()
-	err = s.authUserRepo.SaveRefreshToken(context.Background(), &domain.RefreshToken{
+	// stored postgres, replaces the previous session of the device
+	err = s.authUserRepo.SaveRefreshToken(ctx, &domain.RefreshToken{
+		TenantID:   tenantID,
 		UserID:     userID,
 		DeviceID:   deviceID,
 		TokenHash:  token.Hash(refreshToken),
@@ -67,101 +219,176 @@ func (s *AuthService) Login(userID string, deviceID string, rememberMe bool, use
 		UserAgent:  userAgent,
 		IP:         ip,
 		Revoked:    false,
+		Service:    opts.Service,
 	})
 	if err != nil {
 		log.Printf("[ERROR] Postgres SaveRefreshToken failed: %+v", err)
-		return "", "", err
+		s.rollbackLogin(ctx, tenantID, userID, deviceID)
+		return nil, err
 	}
-	log.Printf("[AUTH] Login success user=%s device=%s", userID, deviceID)
-	return accessToken, refreshToken, nil
+	log.Printf("[AUTH] Login success user=%s device=%s evicted=%d", userID, deviceID, len(evicted))
+	return &LoginResult{
+		AccessToken:    accessToken,
+		RefreshToken:   refreshToken,
+		EvictedDevices: evicted,
+	}, nil
 }
 
 // OAuth Login
 func (s *AuthService) OAuthLogin(
+	ctx context.Context,
 	provider string,
 	providerID string,
 	email string,
 	deviceID string,
 	rememberMe bool,
+	opts LoginOptions,
 	userAgent *string,
 	ip *string,
-) (string, string, error) {
+) (*LoginResult, error) {
 
-	log.Printf("[AUTH] OAuthLogin start provider=%s providerID=%s device=%s",
-		provider, providerID, deviceID)
+	tenantID := tenantOrDefault(opts.Tenant)
+	log.Printf("[AUTH] OAuthLogin start tenant=%s provider=%s providerID=%s device=%s",
+		tenantID, provider, providerID, deviceID)
 
-	user, err := s.authUserRepo.FindByProvider(provider, providerID)
+	// refuse before creating a user the tenant would never let log in
+	opts.Tenant, opts.Provider = tenantID, provider
+	if !s.tenants.Resolve(tenantID).AllowsProvider(provider) {
+		return nil, ErrProviderNotAllowed
+	}
+
+	user, err := s.authUserRepo.FindByProvider(ctx, tenantID, provider, providerID)
 	if err != nil {
 		log.Printf("[ERROR] FindByProvider failed: %+v", err)
-		return "", "", err
+		return nil, err
 	}
 
 	if user == nil {
 		log.Printf("[AUTH] Creating new AuthUser for provider=%s id=%s", provider, providerID)
 		user = &domain.AuthUser{
-			UserID:     uuid.NewString(),
+			TenantID:   tenantID,
+			UserID:     s.ids.NewID(),
 			Provider:   provider,
 			ProviderID: providerID,
 			Email:      email,
 		}
-		if err := s.authUserRepo.Save(user); err != nil {
+		if err := s.authUserRepo.Save(ctx, user); err != nil {
 			log.Printf("[ERROR] Save AuthUser failed: %+v", err)
-			return "", "", err
+			return nil, err
 		}
 	}
 
-	refreshTTL := RefreshTTLShort
-	if rememberMe {
-		refreshTTL = RefreshTTLLong
+	auth := s.NewAuthInfo(token.AMRFederated)
+	return s.Login(ctx, user.UserID, deviceID, rememberMe, auth, opts, userAgent, ip)
+}
+
+// applyRevocation runs the redis side of a revocation already committed in
+// postgres. On failure the session_outbox rows stay pending and the outbox
+// worker retries, so the request itself still succeeds.
+func (s *AuthService) applyRevocation(ctx context.Context, tenantID, userID string, deviceIDs []string, redisOp func() error) {
+	if err := redisOp(); err != nil {
+		log.Printf("[WARN] Redis revocation failed tenant=%s user=%s, left to outbox worker: %+v", tenantID, userID, err)
+		return
 	}
+	s.completeOutbox(ctx, tenantID, userID, deviceIDs)
+}
 
-	accessToken, err := token.Generate(user.UserID, deviceID, AccessTokenTTL)
-	if err != nil {
-		log.Printf("[ERROR] Generate access token failed: %+v", err)
-		return "", "", err
+func (s *AuthService) completeOutbox(ctx context.Context, tenantID, userID string, deviceIDs []string) {
+	if err := s.authUserRepo.CompleteSessionOutbox(ctx, tenantID, userID, deviceIDs); err != nil {
+		// the worker applies them again, evicting twice is harmless
+		log.Printf("[ERROR] Postgres CompleteSessionOutbox failed: %+v", err)
 	}
+}
 
-	refreshToken, err := token.Generate(user.UserID, deviceID, refreshTTL)
-	if err != nil {
-		log.Printf("[ERROR] Generate refresh token failed: %+v", err)
-		return "", "", err
+// rollbackLogin drops the redis session of a login whose postgres write failed.
+func (s *AuthService) rollbackLogin(ctx context.Context, tenantID, userID, deviceID string) {
+	if err := s.sessionStore.LogoutDevice(ctx, tenantID, userID, deviceID); err != nil {
+		log.Printf("[ERROR] Redis rollback of login failed user=%s device=%s: %+v", userID, deviceID, err)
 	}
+}
 
-	// redis
-	if err := s.redisRepo.SaveLogin(
-		user.UserID,
-		deviceID,
-		refreshToken,
-		refreshTTL,
-	); err != nil {
-		log.Printf("[ERROR] Redis SaveLogin failed: %+v", err)
-		return "", "", err
+func (s *AuthService) deviceLimitError(ctx context.Context, tenantID, userID string, limitErr *repository.DeviceLimitError) error {
+	e := &DeviceLimitError{
+		Strategy:   limitErr.Policy.Strategy,
+		MaxDevices: limitErr.Policy.MaxDevices,
 	}
-	// postgres
-	now := time.Now()
-	err = s.authUserRepo.SaveRefreshToken(context.Background(), &domain.RefreshToken{
-		UserID:     user.UserID,
-		DeviceID:   deviceID,
-		TokenHash:  token.Hash(refreshToken),
-		IssuedAt:   now,
-		ExpiresAt:  now.Add(refreshTTL),
-		LastUsedAt: nil,
-		UserAgent:  userAgent,
-		IP:         ip,
-		Revoked:    false,
-	})
+	if limitErr.Policy.Strategy != policy.RequireChoice {
+		return e
+	}
+
+	// only the devices still live in redis are candidates
+	live := map[string]bool{}
+	for _, id := range limitErr.DeviceIDs {
+		live[id] = true
+	}
+	devices, err := s.authUserRepo.GetLoginDevices(ctx, tenantID, userID, SessionsMaxLimit, 0)
 	if err != nil {
-		log.Printf("[ERROR] Postgres SaveRefreshToken failed: %+v", err)
-		return "", "", err
+		log.Printf("[ERROR] Postgres GetLoginDevices failed: %+v", err)
+		return err
+	}
+	for _, d := range devices {
+		if live[d.DeviceID] && !d.Revoked {
+			e.Devices = append(e.Devices, d)
+		}
 	}
-	log.Printf("[AUTH] OAuthLogin success user=%s device=%s", user.UserID, deviceID)
-	return accessToken, refreshToken, nil
+	return e
+}
+
+// idleWindow is how long a session may stay unused given the time left
+// until its absolute end.
+func idleWindow(p policy.SessionPolicy, remaining time.Duration) time.Duration {
+	if idle := p.IdleTimeout.Std(); idle > 0 && idle < remaining {
+		return idle
+	}
+	return remaining
+}
+
+// Tenant returns the configuration of tenantID.
+func (s *AuthService) Tenant(tenantID string) policy.TenantPolicy {
+	return s.tenants.Resolve(tenantOrDefault(tenantID))
+}
+
+// NewAuthInfo builds the AuthInfo of an authentication that just happened,
+// auth_time is read from the service's clock.
+func (s *AuthService) NewAuthInfo(amr ...string) token.AuthInfo {
+	return token.NewAuthInfo(s.clock.Now(), amr...)
 }
 
-func (s *AuthService) Logout(accessToken string) error {
+// AssertedAuthInfo is NewAuthInfo for methods reported by the calling
+// backend (login and reauth requests), only token.ValidAMR ones are taken.
+func (s *AuthService) AssertedAuthInfo(amr []string) (token.AuthInfo, error) {
+	for _, m := range amr {
+		if !token.ValidAMR(m) {
+			return token.AuthInfo{}, fmt.Errorf("%w: %q", ErrInvalidAMR, m)
+		}
+	}
+	return s.NewAuthInfo(amr...), nil
+}
+
+// lifetime resolves the token TTLs of service, the tenant's overrides win
+// over the global ones.
+func (s *AuthService) lifetime(tenantID, service string) policy.TokenLifetime {
+	return s.tokenLifetimes.Resolve(service).Override(s.Tenant(tenantID).Lifetime(service))
+}
+
+func tenantOrDefault(tenantID string) string {
+	if tenantID == "" {
+		return domain.DefaultTenant
+	}
+	return tenantID
+}
+
+func deref(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+func (s *AuthService) Logout(ctx context.Context, tenantID string, accessToken string) error {
 	log.Printf("[AUTH] Logout start")
 
-	claims, err := token.Parse(accessToken)
+	claims, err := token.ParseAt(accessToken, tenantID, s.clock.Now())
 	if err != nil {
 		log.Printf("[ERROR] Token parse failed: %+v", err)
 		return err
@@ -172,29 +399,28 @@ func (s *AuthService) Logout(accessToken string) error {
 		return errors.New("missing claims")
 	}
 
-	// redis
-	if err := s.redisRepo.LogoutDevice(claims.UserID, claims.DeviceID); err != nil {
-		log.Printf("[ERROR] Redis LogoutDevice failed: %+v", err)
-		return err
-	}
-	// postgres
+	// postgres is the source of truth, redis follows
 	if err := s.authUserRepo.RevokeDevice(
-		context.Background(),
+		ctx,
+		claims.Tenant(),
 		claims.UserID,
 		claims.DeviceID,
 	); err != nil {
 		log.Printf("[ERROR] Postgres RevokeDevice failed: %+v", err)
 		return err
 	}
+	s.applyRevocation(ctx, claims.Tenant(), claims.UserID, []string{claims.DeviceID}, func() error {
+		return s.sessionStore.LogoutDevice(ctx, claims.Tenant(), claims.UserID, claims.DeviceID)
+	})
 
 	log.Printf("[AUTH] Logout success user=%s device=%s", claims.UserID, claims.DeviceID)
 	return nil
 }
 
-func (s *AuthService) LogoutAll(accessToken string) error {
+func (s *AuthService) LogoutAll(ctx context.Context, tenantID string, accessToken string) error {
 	log.Printf("[AUTH] LogoutAll start")
 
-	claims, err := token.Parse(accessToken)
+	claims, err := token.ParseAt(accessToken, tenantID, s.clock.Now())
 	if err != nil {
 		log.Printf("[ERROR] Token parse failed: %+v", err)
 		return err
@@ -205,53 +431,78 @@ func (s *AuthService) LogoutAll(accessToken string) error {
 		return errors.New("missing user_id")
 	}
 
-	// Redis
-	if err := s.redisRepo.LogoutAll(claims.UserID); err != nil {
-		log.Printf("[ERROR] Redis LogoutAll failed: %+v", err)
-		return err
-	}
 	// Postgres
-	if err := s.authUserRepo.RevokeAllDevices(
-		context.Background(),
-		claims.UserID,
-	); err != nil {
+	revoked, err := s.authUserRepo.RevokeAllDevices(ctx, claims.Tenant(), claims.UserID)
+	if err != nil {
 		log.Printf("[ERROR] Postgres RevokeAllDevices failed: %+v", err)
 		return err
 	}
+	// Redis
+	s.applyRevocation(ctx, claims.Tenant(), claims.UserID, revoked, func() error {
+		return s.sessionStore.LogoutAll(ctx, claims.Tenant(), claims.UserID)
+	})
 
 	log.Printf("[AUTH] LogoutAll success user=%s", claims.UserID)
 	return nil
 }
 
-func (s *AuthService) Refresh(refreshToken string) (string, error) {
+// Refresh issues a new access token and slides the idle timeout of the
+// session. The session policy and token lifetimes are those of the service
+// the session was created for (azp), whichever backend refreshes it.
+// tenantID is the tenant of the calling service.
+func (s *AuthService) Refresh(ctx context.Context, tenantID string, refreshToken string) (string, error) {
 	log.Printf("[AUTH] Refresh start")
 
-	claims, err := token.Parse(refreshToken)
+	now := s.clock.Now()
+	claims, err := token.ParseAt(refreshToken, tenantID, now)
 	if err != nil {
 		log.Printf("[ERROR] Token parse failed: %+v", err)
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return "", ErrReauthRequired
+		}
 		return "", err
 	}
 
+	tenantID = claims.Tenant()
 	userID := claims.UserID
 	deviceID := claims.DeviceID
+	service := claims.AuthorizedParty
 
-	exists, err := s.redisRepo.ExistsRefreshToken(userID, deviceID)
+	// the refresh token expires at the absolute end of the session
+	remaining := claims.ExpiresAt.Time.Sub(now)
+	if remaining <= 0 {
+		return "", ErrReauthRequired
+	}
+	idleTTL := idleWindow(s.sessionPolicies.Resolve(service), remaining)
+
+	valid, err := s.sessionStore.RefreshSession(ctx, tenantID, userID, deviceID, token.Hash(refreshToken), idleTTL)
 	if err != nil {
-		log.Printf("[ERROR] Redis ExistsRefreshToken failed: %+v", err)
+		log.Printf("[ERROR] Redis RefreshSession failed: %+v", err)
 		return "", err
 	}
-	if !exists {
+	if !valid {
 		log.Printf("[WARN] Refresh token not found user=%s device=%s", userID, deviceID)
-		return "", errors.New("refresh token expired or revoked")
+		return "", ErrInvalidRefreshToken
 	}
 
-	// postgres
-	if err := s.authUserRepo.UpdateLastUsedAt(context.Background(), userID, deviceID); err != nil {
+	// postgres decides, a session revoked there is dropped from redis too
+	active, err := s.authUserRepo.UpdateLastUsedAt(ctx, tenantID, userID, deviceID)
+	if err != nil {
 		log.Printf("[ERROR] Postgres UpdateLastUsedAt failed: %+v", err)
 		return "", err
 	}
+	if !active {
+		log.Printf("[WARN] Session revoked in Postgres user=%s device=%s", userID, deviceID)
+		if err := s.sessionStore.LogoutDevice(ctx, tenantID, userID, deviceID); err != nil {
+			log.Printf("[ERROR] Redis LogoutDevice failed: %+v", err)
+		}
+		return "", ErrInvalidRefreshToken
+	}
 
-	newAccessToken, err := token.Generate(userID, deviceID, AccessTokenTTL)
+	// keep auth_time/acr/amr of the original authentication
+	auth := claims.AuthInfo()
+	auth.SessionExpiresAt = claims.ExpiresAt.Time
+	newAccessToken, err := token.Generate(userID, deviceID, now, min(s.lifetime(tenantID, service).AccessTTL.Std(), remaining), auth)
 	if err != nil {
 		log.Printf("[ERROR] Generate new access token failed: %+v", err)
 		return "", err
@@ -261,8 +512,140 @@ func (s *AuthService) Refresh(refreshToken string) (string, error) {
 	return newAccessToken, nil
 }
 
-func (s *AuthService) ExistsSession(userID, deviceID string) (bool, error) {
-	exists, err := s.redisRepo.ExistsRefreshToken(userID, deviceID)
+// Reauthenticate upgrades the session of refreshToken after the user proved
+// their identity again. The device and its expiry stay the same, only
+// auth_time/acr/amr change and a new token pair is issued.
+func (s *AuthService) Reauthenticate(ctx context.Context, tenantID string, refreshToken string, auth token.AuthInfo) (string, string, error) {
+	log.Printf("[AUTH] Reauthenticate start")
+
+	now := s.clock.Now()
+	claims, err := token.ParseAt(refreshToken, tenantID, now)
+	if err != nil {
+		log.Printf("[ERROR] Token parse failed: %+v", err)
+		return "", "", err
+	}
+
+	tenantID = claims.Tenant()
+	userID := claims.UserID
+	deviceID := claims.DeviceID
+
+	remaining := claims.ExpiresAt.Time.Sub(now)
+	if remaining <= 0 {
+		return "", "", ErrReauthRequired
+	}
+	// reauth does not extend the absolute lifetime nor change the service
+	auth.SessionExpiresAt = claims.ExpiresAt.Time
+	auth.Service = claims.AuthorizedParty
+	auth.Tenant = tenantID
+
+	accessToken, err := token.Generate(userID, deviceID, now, min(s.lifetime(tenantID, auth.Service).AccessTTL.Std(), remaining), auth)
+	if err != nil {
+		log.Printf("[ERROR] Generate access token failed: %+v", err)
+		return "", "", err
+	}
+	newRefreshToken, err := token.Generate(userID, deviceID, now, remaining, auth)
+	if err != nil {
+		log.Printf("[ERROR] Generate refresh token failed: %+v", err)
+		return "", "", err
+	}
+
+	// both stores swap only from the presented token, a rotated-out or
+	// stolen older token of the session is refused. Postgres first, the
+	// reconciler copies its hash to redis.
+	oldHash, newHash := token.Hash(refreshToken), token.Hash(newRefreshToken)
+	swapped, err := s.authUserRepo.UpdateRefreshTokenHash(ctx, tenantID, userID, deviceID, oldHash, newHash)
+	if err != nil {
+		log.Printf("[ERROR] Postgres UpdateRefreshTokenHash failed: %+v", err)
+		return "", "", err
+	}
+	if !swapped {
+		log.Printf("[WARN] Reauth with a revoked or replaced refresh token user=%s device=%s", userID, deviceID)
+		return "", "", ErrInvalidRefreshToken
+	}
+	// redis
+	replaced, err := s.sessionStore.ReplaceRefreshToken(ctx, tenantID, userID, deviceID, oldHash, newHash)
+	if err != nil || !replaced {
+		if err != nil {
+			log.Printf("[ERROR] Redis ReplaceRefreshToken failed: %+v", err)
+		} else {
+			log.Printf("[WARN] Reauth session missing or replaced in Redis user=%s device=%s", userID, deviceID)
+			err = ErrInvalidRefreshToken
+		}
+		// undo only our own swap so both stores agree on the session
+		if _, rerr := s.authUserRepo.UpdateRefreshTokenHash(ctx, tenantID, userID, deviceID, newHash, oldHash); rerr != nil {
+			log.Printf("[ERROR] Postgres rollback of reauth failed: %+v", rerr)
+		}
+		return "", "", err
+	}
+
+	log.Printf("[AUTH] Reauthenticate success user=%s device=%s acr=%s", userID, deviceID, auth.ACR)
+	return accessToken, newRefreshToken, nil
+}
+
+// StepUpRequiredError is returned by CheckAuthLevel when the session's
+// authentication is too old or too weak for the requested operation.
+type StepUpRequiredError struct {
+	Reason      string
+	MaxAge      *time.Duration
+	RequiredACR string
+	AuthTime    time.Time
+	ACR         string
+}
+
+func (e *StepUpRequiredError) Error() string {
+	return "step up required: " + e.Reason
+}
+
+// VerifyToken validates an access token of tenantID as of the service clock.
+// It does not look at the session; see IdleExpiry.
+func (s *AuthService) VerifyToken(tenantID, accessToken string) (*token.Claims, error) {
+	return token.ParseAt(accessToken, tenantOrDefault(tenantID), s.clock.Now())
+}
+
+// CheckAuthLevel enforces max_age (seconds since auth_time) and a minimum acr.
+func (s *AuthService) CheckAuthLevel(claims *token.Claims, maxAge *time.Duration, requiredACR string) error {
+	auth := claims.AuthInfo()
+	stepUp := &StepUpRequiredError{
+		MaxAge:      maxAge,
+		RequiredACR: requiredACR,
+		AuthTime:    auth.AuthTime,
+		ACR:         auth.ACR,
+	}
+
+	if maxAge != nil {
+		if auth.AuthTime.IsZero() {
+			stepUp.Reason = "auth_time unknown"
+			return stepUp
+		}
+		if clock.Since(s.clock, auth.AuthTime) > *maxAge {
+			stepUp.Reason = "authentication too old"
+			return stepUp
+		}
+	}
+
+	if !token.ACRSatisfies(auth.ACR, requiredACR) {
+		stepUp.Reason = "insufficient acr"
+		return stepUp
+	}
+	return nil
+}
+
+// IdleExpiry returns when the session ends if it is not refreshed,
+// false when the session no longer exists.
+func (s *AuthService) IdleExpiry(ctx context.Context, tenantID, userID, deviceID string) (time.Time, bool, error) {
+	ttl, ok, err := s.sessionStore.SessionTTL(ctx, tenantID, userID, deviceID)
+	if err != nil {
+		log.Printf("[ERROR] IdleExpiry Redis check failed: %+v", err)
+		return time.Time{}, false, err
+	}
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	return s.clock.Now().Add(ttl), true, nil
+}
+
+func (s *AuthService) ExistsSession(ctx context.Context, tenantID, userID, deviceID string) (bool, error) {
+	exists, err := s.sessionStore.ExistsRefreshToken(ctx, tenantID, userID, deviceID)
 	if err != nil {
 		log.Printf("[ERROR] ExistsSession Redis check failed: %+v", err)
 	}
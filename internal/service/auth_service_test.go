@@ -0,0 +1,690 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"central-auth/internal/audit"
+	"central-auth/internal/clock"
+	"central-auth/internal/config"
+	"central-auth/internal/domain"
+	"central-auth/internal/ids"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+	"central-auth/internal/repository/redistest"
+	"central-auth/internal/service"
+	"central-auth/internal/token"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+var ctx = context.Background()
+
+const tenant = domain.DefaultTenant
+
+type recorder struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (r *recorder) Record(ev audit.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// failingRepo fails SaveRefreshToken, the postgres write of a login.
+type failingRepo struct {
+	repository.AuthUserRepository
+}
+
+func (failingRepo) SaveRefreshToken(context.Context, *domain.RefreshToken) error {
+	return errors.New("postgres down")
+}
+
+type env struct {
+	svc      *service.AuthService
+	repo     repository.AuthUserRepository
+	sessions repository.SessionStore
+	clock    *clock.Fake
+	mr       *miniredis.Miniredis
+	audit    *recorder
+}
+
+// advance moves the service clock and the Redis TTLs together.
+func (e *env) advance(d time.Duration) {
+	e.clock.Advance(d)
+	if e.mr != nil {
+		e.mr.FastForward(d)
+	}
+}
+
+func newEnv(t *testing.T, devices policy.DevicePolicy, sessions policy.SessionPolicy) *env {
+	client, mr := redistest.NewClient(t)
+	e := newEnvOn(t, devices, sessions, func(clk clock.Clock) repository.SessionStore {
+		return repository.NewRedisRepository(client, clk)
+	})
+	e.mr = mr
+	return e
+}
+
+// newMemoryEnv is newEnv on the in-memory session store, the fake clock
+// alone drives every TTL.
+func newMemoryEnv(t *testing.T, devices policy.DevicePolicy, sessions policy.SessionPolicy) *env {
+	return newEnvOn(t, devices, sessions, repository.NewMemorySessionStore)
+}
+
+func newEnvOn(t *testing.T, devices policy.DevicePolicy, sessions policy.SessionPolicy, newStore func(clock.Clock) repository.SessionStore) *env {
+	db, err := config.NewSQLiteConn(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	clk := clock.NewFake(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	repo, err := repository.NewSQLiteAuthUserRepository(db, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &env{
+		repo:     repo,
+		sessions: newStore(clk),
+		clock:    clk,
+		audit:    &recorder{},
+	}
+	e.svc = service.NewAuthService(e.sessions, repo, e.audit,
+		&policy.DevicePolicies{Default: devices},
+		&policy.SessionPolicies{Default: sessions},
+		policy.DefaultTokenLifetimes(),
+		nil,
+		clk,
+		&ids.Sequence{Prefix: "user"},
+	)
+	return e
+}
+
+func (e *env) login(t *testing.T, deviceID string) *service.LoginResult {
+	t.Helper()
+	res, err := e.svc.Login(ctx, "u1", deviceID, false, token.NewAuthInfo(e.clock.Now(), token.AMRExternal), service.LoginOptions{}, nil, nil)
+	if err != nil {
+		t.Fatalf("Login(%s): %v", deviceID, err)
+	}
+	return res
+}
+
+var fiveDevices = policy.DevicePolicy{MaxDevices: 5, Strategy: policy.EvictOldest}
+
+func TestLoginRefreshLogout(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res := e.login(t, "d1")
+
+	claims, err := token.ParseAt(res.AccessToken, tenant, e.clock.Now())
+	if err != nil || claims.UserID != "u1" || claims.DeviceID != "d1" {
+		t.Fatalf("access token = %+v, %v", claims, err)
+	}
+	if ttl, ok, _ := e.sessions.SessionTTL(ctx, tenant, "u1", "d1"); !ok || ttl < policy.DefaultRefreshTTL-time.Minute {
+		t.Fatalf("session ttl = %v, %v", ttl, ok)
+	}
+
+	access, err := e.svc.Refresh(ctx, tenant, res.RefreshToken)
+	if err != nil || access == "" {
+		t.Fatalf("Refresh = %q, %v", access, err)
+	}
+
+	if err := e.svc.Logout(ctx, tenant, res.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); err == nil {
+		t.Fatal("refresh after logout accepted")
+	}
+	devices, _ := e.repo.GetLoginDevices(ctx, tenant, "u1", 10, 0)
+	if len(devices) != 1 || !devices[0].Revoked {
+		t.Fatalf("postgres devices = %+v", devices)
+	}
+}
+
+func TestRememberMeTTL(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res, err := e.svc.Login(ctx, "u1", "d1", true, token.NewAuthInfo(e.clock.Now(), token.AMRExternal), service.LoginOptions{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, _, _ := e.sessions.SessionTTL(ctx, tenant, "u1", "d1"); ttl < policy.DefaultRememberMeTTL-time.Minute {
+		t.Fatalf("session ttl = %v, want %v", ttl, policy.DefaultRememberMeTTL)
+	}
+	// a short session would be gone by now
+	e.advance(policy.DefaultRefreshTTL + time.Hour)
+	if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	e.advance(policy.DefaultRememberMeTTL)
+	if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); !errors.Is(err, service.ErrReauthRequired) {
+		t.Fatalf("Refresh after 30 days = %v, want ErrReauthRequired", err)
+	}
+}
+
+func TestRefreshAfterIdleTimeout(t *testing.T) {
+	idle := policy.SessionPolicy{IdleTimeout: policy.Duration(time.Hour)}
+	e := newEnv(t, fiveDevices, idle)
+	res := e.login(t, "d1")
+
+	// every refresh slides the idle window
+	for i := 0; i < 3; i++ {
+		e.advance(45 * time.Minute)
+		if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); err != nil {
+			t.Fatalf("Refresh %d: %v", i, err)
+		}
+	}
+
+	e.advance(61 * time.Minute)
+	if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); err == nil {
+		t.Fatal("refresh after idle timeout accepted")
+	}
+}
+
+func TestLoginEvictsOldestDevice(t *testing.T) {
+	e := newEnv(t, policy.DevicePolicy{MaxDevices: 1, Strategy: policy.EvictOldest}, policy.SessionPolicy{})
+	first := e.login(t, "d1")
+	second := e.login(t, "d2")
+
+	if len(second.EvictedDevices) != 1 || second.EvictedDevices[0] != "d1" {
+		t.Fatalf("evicted = %v", second.EvictedDevices)
+	}
+	if _, err := e.svc.Refresh(ctx, tenant, first.RefreshToken); err == nil {
+		t.Fatal("evicted device refreshed")
+	}
+	if _, err := e.svc.Refresh(ctx, tenant, second.RefreshToken); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if len(e.audit.events) != 1 || e.audit.events[0].Type != audit.EventDeviceEvicted || e.audit.events[0].DeviceID != "d1" {
+		t.Fatalf("audit = %+v", e.audit.events)
+	}
+	if n, _ := e.repo.CountActiveDevices(ctx, tenant, "u1"); n != 1 {
+		t.Fatalf("active devices in postgres = %d", n)
+	}
+}
+
+func TestLoginRejectedAtLimit(t *testing.T) {
+	e := newEnv(t, policy.DevicePolicy{MaxDevices: 1, Strategy: policy.RequireChoice}, policy.SessionPolicy{})
+	e.login(t, "d1")
+
+	_, err := e.svc.Login(ctx, "u1", "d2", false, token.NewAuthInfo(e.clock.Now(), token.AMRExternal), service.LoginOptions{}, nil, nil)
+	var limitErr *service.DeviceLimitError
+	if !errors.As(err, &limitErr) || len(limitErr.Devices) != 1 || limitErr.Devices[0].DeviceID != "d1" {
+		t.Fatalf("Login = %v, want DeviceLimitError listing d1", err)
+	}
+
+	res, err := e.svc.Login(ctx, "u1", "d2", false, token.NewAuthInfo(e.clock.Now(), token.AMRExternal), service.LoginOptions{ReplaceDeviceID: "d1"}, nil, nil)
+	if err != nil || len(res.EvictedDevices) != 1 {
+		t.Fatalf("Login(replace d1) = %+v, %v", res, err)
+	}
+}
+
+func TestRefreshRevokedInPostgres(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res := e.login(t, "d1")
+
+	// revoked in postgres but redis missed it
+	if err := e.repo.RevokeDevice(ctx, tenant, "u1", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); err == nil {
+		t.Fatal("refresh of revoked session accepted")
+	}
+	if ok, _ := e.sessions.ExistsRefreshToken(ctx, tenant, "u1", "d1"); ok {
+		t.Fatal("revoked session left in redis")
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	first := e.login(t, "d1")
+	second := e.login(t, "d2")
+
+	if err := e.svc.LogoutAll(ctx, tenant, first.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range []*service.LoginResult{first, second} {
+		if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); err == nil {
+			t.Fatal("refresh after LogoutAll accepted")
+		}
+	}
+	if n, _ := e.repo.CountActiveDevices(ctx, tenant, "u1"); n != 0 {
+		t.Fatalf("active devices in postgres = %d", n)
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	e.login(t, "d1")
+	e.advance(time.Minute)
+	e.login(t, "d2")
+	e.advance(time.Minute)
+	current := e.login(t, "d3")
+
+	// d2 lost from redis, still active in postgres
+	if err := e.sessions.LogoutDevice(ctx, tenant, "u1", "d2"); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, active, hasMore, err := e.svc.ListSessions(ctx, tenant, current.AccessToken, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != 3 || !hasMore || len(sessions) != 2 {
+		t.Fatalf("ListSessions = %d sessions, active %d, more %v", len(sessions), active, hasMore)
+	}
+	d3, d2 := sessions[0], sessions[1]
+	if d3.DeviceID != "d3" || !d3.Current || !d3.Active || d3.LoginAt == nil || d3.IdleExpiresAt == nil {
+		t.Fatalf("d3 = %+v", d3)
+	}
+	if d2.DeviceID != "d2" || d2.Current || d2.Active || d2.LoginAt != nil || d2.IdleExpiresAt != nil {
+		t.Fatalf("d2 = %+v", d2)
+	}
+
+	sessions, _, hasMore, err = e.svc.ListSessions(ctx, tenant, current.AccessToken, 2, 2)
+	if err != nil || hasMore || len(sessions) != 1 {
+		t.Fatalf("second page = %+v, %v, %v", sessions, hasMore, err)
+	}
+	d1 := sessions[0]
+	idle, _, _ := e.svc.IdleExpiry(ctx, tenant, "u1", "d1")
+	if d1.DeviceID != "d1" || d1.Current || !d1.Active || d1.IdleExpiresAt == nil || !d1.IdleExpiresAt.Equal(idle) {
+		t.Fatalf("d1 = %+v, idle expiry %v", d1, idle)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	current := e.login(t, "d1")
+	e.login(t, "d2")
+
+	// lost from redis, still active in postgres: revoked and audited
+	if err := e.sessions.LogoutDevice(ctx, tenant, "u1", "d2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.svc.RevokeSession(ctx, tenant, current.AccessToken, "d2", ""); err != nil {
+		t.Fatalf("RevokeSession = %v", err)
+	}
+	if n, _ := e.repo.CountActiveDevices(ctx, tenant, "u1"); n != 1 {
+		t.Fatalf("active devices in postgres = %d, want 1", n)
+	}
+	if len(e.audit.events) != 1 || e.audit.events[0].Type != audit.EventSessionRevoked {
+		t.Fatalf("audit = %+v", e.audit.events)
+	}
+	if err := e.svc.RevokeSession(ctx, tenant, current.AccessToken, "d2", ""); !errors.Is(err, service.ErrSessionNotFound) {
+		t.Fatalf("RevokeSession(revoked) = %v, want ErrSessionNotFound", err)
+	}
+	if len(e.audit.events) != 1 {
+		t.Fatalf("audit = %+v", e.audit.events)
+	}
+
+	// a logged out access token lists nothing
+	if _, _, _, err := e.svc.ListSessions(ctx, tenant, current.AccessToken, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.svc.Logout(ctx, tenant, current.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := e.svc.ListSessions(ctx, tenant, current.AccessToken, 0, 0); err == nil {
+		t.Fatal("ListSessions accepted a logged out access token")
+	}
+}
+
+func TestLoginRollsBackOnPostgresFailure(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	svc := service.NewAuthService(e.sessions, failingRepo{e.repo}, e.audit,
+		&policy.DevicePolicies{Default: fiveDevices}, policy.DefaultSessionPolicies(), policy.DefaultTokenLifetimes(), nil, e.clock, &ids.Sequence{})
+
+	if _, err := svc.Login(ctx, "u1", "d1", false, token.NewAuthInfo(e.clock.Now(), token.AMRExternal), service.LoginOptions{}, nil, nil); err == nil {
+		t.Fatal("Login succeeded without postgres")
+	}
+	if ok, _ := e.sessions.ExistsRefreshToken(ctx, tenant, "u1", "d1"); ok {
+		t.Fatal("redis session left behind")
+	}
+}
+
+func TestAbsoluteLifetime(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{
+		IdleTimeout:      policy.Duration(time.Hour),
+		AbsoluteLifetime: policy.Duration(3 * time.Hour),
+	})
+	res := e.login(t, "d1")
+
+	// active all the time, still ends after three hours
+	for i := 0; i < 5; i++ {
+		e.advance(50 * time.Minute)
+		_, err := e.svc.Refresh(ctx, tenant, res.RefreshToken)
+		if i < 3 && err != nil {
+			t.Fatalf("Refresh %d: %v", i, err)
+		}
+		if i >= 3 && !errors.Is(err, service.ErrReauthRequired) {
+			t.Fatalf("Refresh %d = %v, want ErrReauthRequired", i, err)
+		}
+	}
+}
+
+func TestAccessTokenCappedByRemainingLifetime(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{AbsoluteLifetime: policy.Duration(time.Hour)})
+	res := e.login(t, "d1")
+
+	e.advance(55 * time.Minute)
+	access, err := e.svc.Refresh(ctx, tenant, res.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := token.ParseAt(access, tenant, e.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claims.ExpiresAt.Sub(e.clock.Now()); got > 5*time.Minute {
+		t.Fatalf("access token lives %v past the session", got)
+	}
+
+	e.advance(6 * time.Minute)
+	if _, err := token.ParseAt(access, tenant, e.clock.Now()); err == nil {
+		t.Fatal("access token outlived the session")
+	}
+}
+
+func TestReauthenticateRotatesRefreshToken(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res := e.login(t, "d1")
+	e.advance(time.Hour)
+
+	auth := token.NewAuthInfo(e.clock.Now(), token.AMRHardwareKey)
+	access, refresh, err := e.svc.Reauthenticate(ctx, tenant, res.RefreshToken, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.svc.Refresh(ctx, tenant, res.RefreshToken); err == nil {
+		t.Fatal("rotated refresh token accepted")
+	}
+	if _, err := e.svc.Refresh(ctx, tenant, refresh); err != nil {
+		t.Fatalf("Refresh with new token: %v", err)
+	}
+
+	// the rotation keeps the session's end and renews auth_time
+	before, _ := token.ParseAt(res.RefreshToken, tenant, e.clock.Now())
+	after, _ := token.ParseAt(refresh, tenant, e.clock.Now())
+	if !after.ExpiresAt.Equal(before.ExpiresAt.Time) {
+		t.Fatalf("expiry moved: %v -> %v", before.ExpiresAt, after.ExpiresAt)
+	}
+	claims, _ := token.ParseAt(access, tenant, e.clock.Now())
+	if !claims.AuthInfo().AuthTime.Equal(e.clock.Now()) {
+		t.Fatalf("auth_time = %v, want %v", claims.AuthInfo().AuthTime, e.clock.Now())
+	}
+
+	// the rotated-out token can not be upgraded, nor overwrite the new one
+	e.advance(time.Second)
+	if _, _, err := e.svc.Reauthenticate(ctx, tenant, res.RefreshToken, auth); !errors.Is(err, service.ErrInvalidRefreshToken) {
+		t.Fatalf("Reauthenticate with rotated token: %v", err)
+	}
+	if _, err := e.svc.Refresh(ctx, tenant, refresh); err != nil {
+		t.Fatalf("Refresh after refused reauth: %v", err)
+	}
+}
+
+func TestCheckAuthLevelMaxAge(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res := e.login(t, "d1")
+	claims, _ := token.ParseAt(res.AccessToken, tenant, e.clock.Now())
+	maxAge := 10 * time.Minute
+
+	if err := e.svc.CheckAuthLevel(claims, &maxAge, ""); err != nil {
+		t.Fatalf("fresh login: %v", err)
+	}
+	e.advance(11 * time.Minute)
+	var stepUp *service.StepUpRequiredError
+	if err := e.svc.CheckAuthLevel(claims, &maxAge, ""); !errors.As(err, &stepUp) {
+		t.Fatalf("CheckAuthLevel = %v, want step up", err)
+	}
+}
+
+func TestVerifyTokenExpires(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res := e.login(t, "d1")
+
+	claims, err := e.svc.VerifyToken(tenant, res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.advance(claims.ExpiresAt.Sub(e.clock.Now()) + time.Second)
+	if _, err := e.svc.VerifyToken(tenant, res.AccessToken); err == nil {
+		t.Fatal("VerifyToken accepted an expired access token")
+	}
+	// the session itself outlives the access token
+	if _, ok, _ := e.svc.IdleExpiry(ctx, tenant, "u1", "d1"); !ok {
+		t.Fatal("session ended with the access token")
+	}
+}
+
+func TestAssertedAMR(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+
+	// methods a backend may not assert are refused
+	for _, amr := range [][]string{
+		{"a", "b"},
+		{token.AMRMFA},
+		{token.AMRHardwareKey, token.AMRUserVerification},
+		{token.AMRPassword, token.AMRHardwareKey},
+	} {
+		if _, err := e.svc.AssertedAuthInfo(amr); !errors.Is(err, service.ErrInvalidAMR) {
+			t.Errorf("AssertedAuthInfo(%v) = %v, want ErrInvalidAMR", amr, err)
+		}
+	}
+
+	auth, err := e.svc.AssertedAuthInfo([]string{token.AMRPassword, token.AMROTP})
+	if err != nil || auth.ACR != token.ACRMultiFactor || !auth.AuthTime.Equal(e.clock.Now()) {
+		t.Fatalf("AssertedAuthInfo(pwd, otp) = %+v, %v", auth, err)
+	}
+
+	// unknown methods never count as a factor, mfa alone is no second one
+	for _, amr := range [][]string{{"a", "b"}, {token.AMRMFA}, {token.AMRPassword, "x"}} {
+		if got := token.NewAuthInfo(e.clock.Now(), amr...); got.ACR != token.ACRSingleFactor {
+			t.Errorf("NewAuthInfo(%v).ACR = %s, want %s", amr, got.ACR, token.ACRSingleFactor)
+		}
+	}
+}
+
+func TestOAuthLoginCreatesUser(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res, err := e.svc.OAuthLogin(ctx, "google", "g-1", "a@example.com", "d1", false, service.LoginOptions{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, _ := token.ParseAt(res.AccessToken, tenant, e.clock.Now())
+	if claims.UserID != "user-1" {
+		t.Fatalf("user id = %q, want user-1", claims.UserID)
+	}
+
+	// known provider id, same user
+	res, _ = e.svc.OAuthLogin(ctx, "google", "g-1", "a@example.com", "d2", false, service.LoginOptions{}, nil, nil)
+	if claims, _ := token.ParseAt(res.AccessToken, tenant, e.clock.Now()); claims.UserID != "user-1" {
+		t.Fatalf("second login user id = %q", claims.UserID)
+	}
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	svc := service.NewAuthService(e.sessions, e.repo, e.audit,
+		&policy.DevicePolicies{Default: fiveDevices}, policy.DefaultSessionPolicies(), policy.DefaultTokenLifetimes(),
+		policy.TenantPolicies{"acme": {}}, e.clock, &ids.Sequence{})
+	auth := token.NewAuthInfo(e.clock.Now(), token.AMRExternal)
+
+	// the same user id and device in two tenants are two sessions
+	own, err := svc.Login(ctx, "u1", "d1", false, auth, service.LoginOptions{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, err := svc.Login(ctx, "u1", "d1", false, auth, service.LoginOptions{Tenant: "acme"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both sign with the global secret, tid tells them apart
+	if _, err := svc.Refresh(ctx, tenant, acme.RefreshToken); !errors.Is(err, token.ErrTenantMismatch) {
+		t.Fatalf("Refresh in default tenant = %v, want ErrTenantMismatch", err)
+	}
+	if err := svc.LogoutAll(ctx, "acme", own.AccessToken); !errors.Is(err, token.ErrTenantMismatch) {
+		t.Fatalf("LogoutAll in acme = %v, want ErrTenantMismatch", err)
+	}
+
+	if err := svc.LogoutAll(ctx, "acme", acme.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Refresh(ctx, tenant, own.RefreshToken); err != nil {
+		t.Fatalf("default tenant session ended by acme logout: %v", err)
+	}
+	if n, _ := e.repo.CountActiveDevices(ctx, tenant, "u1"); n != 1 {
+		t.Fatalf("active default tenant devices = %d, want 1", n)
+	}
+}
+
+func TestTenantPolicy(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	svc := service.NewAuthService(e.sessions, e.repo, e.audit,
+		&policy.DevicePolicies{Default: fiveDevices}, policy.DefaultSessionPolicies(), policy.DefaultTokenLifetimes(),
+		policy.TenantPolicies{"bank": {
+			TokenLifetime:    policy.TokenLifetime{AccessTTL: policy.Duration(5 * time.Minute)},
+			Devices:          &policy.DevicePolicies{Default: policy.DevicePolicy{MaxDevices: 1, Strategy: policy.Reject}},
+			AllowedProviders: []string{policy.ProviderSMS},
+		}}, e.clock, &ids.Sequence{})
+	auth := token.NewAuthInfo(e.clock.Now(), token.AMRSMS)
+
+	if _, err := svc.Login(ctx, "u1", "d1", false, auth, service.LoginOptions{Tenant: "bank", Provider: policy.ProviderService}, nil, nil); !errors.Is(err, service.ErrProviderNotAllowed) {
+		t.Fatalf("Login with service provider = %v, want ErrProviderNotAllowed", err)
+	}
+
+	opts := service.LoginOptions{Tenant: "bank", Provider: policy.ProviderSMS}
+	res, err := svc.Login(ctx, "u1", "d1", false, auth, opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := token.ParseAt(res.AccessToken, "bank", e.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claims.ExpiresAt.Sub(e.clock.Now()); got != 5*time.Minute {
+		t.Fatalf("access token ttl = %v, want 5m", got)
+	}
+
+	var limitErr *service.DeviceLimitError
+	if _, err := svc.Login(ctx, "u1", "d2", false, auth, opts, nil, nil); !errors.As(err, &limitErr) {
+		t.Fatalf("second device = %v, want DeviceLimitError", err)
+	}
+}
+
+func TestTenantSigningKey(t *testing.T) {
+	token.TenantSecrets = map[string][]byte{"acme": []byte("acme-signing-key-of-at-least-32-bytes")}
+	t.Cleanup(func() { token.TenantSecrets = map[string][]byte{} })
+
+	now := time.Now()
+	acme, err := token.Generate("u1", "d1", now, time.Minute, token.AuthInfo{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.ParseAt(acme, "acme", now); err != nil {
+		t.Fatalf("ParseAt(acme) = %v", err)
+	}
+	if _, err := token.ParseAt(acme, tenant, now); err == nil {
+		t.Fatal("acme token verified with the global secret")
+	}
+
+	// a token of the default tenant does not verify with the acme key
+	own, _ := token.Generate("u1", "d1", now, time.Minute, token.AuthInfo{})
+	if _, err := token.ParseAt(own, "acme", now); err == nil {
+		t.Fatal("default tenant token verified in acme")
+	}
+}
+
+func TestServiceTokenLifetimes(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	noRememberMe := false
+	lifetimes := policy.DefaultTokenLifetimes()
+	lifetimes.Services = map[string]policy.TokenLifetime{
+		"banking": {AccessTTL: policy.Duration(5 * time.Minute), RefreshTTL: policy.Duration(12 * time.Hour), RememberMe: &noRememberMe},
+		"media":   {RememberMeTTL: policy.Duration(90 * 24 * time.Hour)},
+	}
+	tenants := policy.TenantPolicies{"acme": {
+		ServiceLifetimes: map[string]policy.TokenLifetime{"banking": {AccessTTL: policy.Duration(2 * time.Minute)}},
+	}}
+	if err := lifetimes.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenants.ValidateLifetimes(lifetimes); err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewAuthService(e.sessions, e.repo, e.audit,
+		&policy.DevicePolicies{Default: fiveDevices}, policy.DefaultSessionPolicies(), lifetimes,
+		tenants, e.clock, &ids.Sequence{})
+
+	cases := []struct {
+		opts       service.LoginOptions
+		device     string
+		access     time.Duration
+		sessionTTL time.Duration
+	}{
+		// remember me is ignored
+		{service.LoginOptions{Service: "banking"}, "d1", 5 * time.Minute, 12 * time.Hour},
+		{service.LoginOptions{Service: "media"}, "d2", policy.DefaultAccessTTL, 90 * 24 * time.Hour},
+		{service.LoginOptions{Service: "shop"}, "d3", policy.DefaultAccessTTL, policy.DefaultRememberMeTTL},
+		{service.LoginOptions{Service: "banking", Tenant: "acme"}, "d4", 2 * time.Minute, 12 * time.Hour},
+	}
+	for _, c := range cases {
+		res, err := svc.Login(ctx, "u1", c.device, true, token.NewAuthInfo(e.clock.Now(), token.AMRExternal), c.opts, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		access, _ := token.ParseAt(res.AccessToken, c.opts.Tenant, e.clock.Now())
+		refresh, _ := token.ParseAt(res.RefreshToken, c.opts.Tenant, e.clock.Now())
+		if got := access.ExpiresAt.Sub(e.clock.Now()); got != c.access {
+			t.Errorf("%s/%s access ttl = %v, want %v", c.opts.Tenant, c.opts.Service, got, c.access)
+		}
+		if got := refresh.ExpiresAt.Sub(e.clock.Now()); got != c.sessionTTL {
+			t.Errorf("%s/%s refresh ttl = %v, want %v", c.opts.Tenant, c.opts.Service, got, c.sessionTTL)
+		}
+		tenantID := c.opts.Tenant
+		if tenantID == "" {
+			tenantID = tenant
+		}
+		if ttl, _, _ := e.sessions.SessionTTL(ctx, tenantID, "u1", c.device); ttl < c.sessionTTL-time.Minute || ttl > c.sessionTTL {
+			t.Errorf("%s/%s redis ttl = %v, want %v", c.opts.Tenant, c.opts.Service, ttl, c.sessionTTL)
+		}
+
+		// refreshed access tokens keep the ttl of the session's service,
+		// whichever backend refreshes them
+		fresh, err := svc.Refresh(ctx, c.opts.Tenant, res.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims, _ := token.ParseAt(fresh, c.opts.Tenant, e.clock.Now()); claims.ExpiresAt.Sub(e.clock.Now()) != c.access {
+			t.Errorf("%s/%s refreshed access ttl = %v, want %v", c.opts.Tenant, c.opts.Service, claims.ExpiresAt.Sub(e.clock.Now()), c.access)
+		}
+	}
+}
+
+func TestTokenLifetimesValidate(t *testing.T) {
+	bad := []policy.TokenLifetime{
+		{AccessTTL: policy.Duration(-time.Minute)},
+		{AccessTTL: policy.Duration(48 * time.Hour), RefreshTTL: policy.Duration(24 * time.Hour)},
+		{RememberMeTTL: policy.Duration(time.Hour)},
+	}
+	for _, l := range bad {
+		lifetimes := policy.DefaultTokenLifetimes()
+		lifetimes.Services = map[string]policy.TokenLifetime{"shop": l}
+		if err := lifetimes.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted", l)
+		}
+		// the same override on a tenant
+		tenants := policy.TenantPolicies{"acme": {TokenLifetime: l}}
+		if err := tenants.ValidateLifetimes(policy.DefaultTokenLifetimes()); err == nil {
+			t.Errorf("ValidateLifetimes(%+v) accepted", l)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+
+	"central-auth/internal/audit"
+	"central-auth/internal/domain"
+	"central-auth/internal/token"
+)
+
+const (
+	SessionsDefaultLimit = 20
+	SessionsMaxLimit     = 100
+)
+
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrCannotRevokeCurrent = errors.New("use /auth/logout to end the current session")
+)
+
+// ListSessions returns one page of the token owner's devices, newest first,
+// merged with the live Redis state, and the number of active devices.
+func (s *AuthService) ListSessions(ctx context.Context, tenantID string, accessToken string, limit int, offset int) ([]domain.SessionInfo, int, bool, error) {
+	claims, err := s.activeClaims(ctx, tenantID, accessToken)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if limit <= 0 {
+		limit = SessionsDefaultLimit
+	}
+	if limit > SessionsMaxLimit {
+		limit = SessionsMaxLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	// one extra row tells us whether there is a next page
+	devices, err := s.authUserRepo.GetLoginDevices(ctx, claims.Tenant(), claims.UserID, limit+1, offset)
+	if err != nil {
+		log.Printf("[ERROR] Postgres GetLoginDevices failed: %+v", err)
+		return nil, 0, false, err
+	}
+	hasMore := len(devices) > limit
+	if hasMore {
+		devices = devices[:limit]
+	}
+
+	activeCount, err := s.authUserRepo.CountActiveDevices(ctx, claims.Tenant(), claims.UserID)
+	if err != nil {
+		log.Printf("[ERROR] Postgres CountActiveDevices failed: %+v", err)
+		return nil, 0, false, err
+	}
+
+	live, err := s.sessionStore.GetDevices(ctx, claims.Tenant(), claims.UserID)
+	if err != nil {
+		log.Printf("[ERROR] Redis GetDevices failed: %+v", err)
+		return nil, 0, false, err
+	}
+
+	// the device set can outlive a single refresh key
+	liveIDs := make([]string, 0, len(devices))
+	for _, d := range devices {
+		if _, ok := live[d.DeviceID]; ok {
+			liveIDs = append(liveIDs, d.DeviceID)
+		}
+	}
+	ttls, err := s.sessionStore.SessionTTLs(ctx, claims.Tenant(), claims.UserID, liveIDs)
+	if err != nil {
+		log.Printf("[ERROR] Redis SessionTTLs failed: %+v", err)
+		return nil, 0, false, err
+	}
+
+	sessions := make([]domain.SessionInfo, 0, len(devices))
+	for _, d := range devices {
+		info := domain.SessionInfo{
+			LoginDeviceInfo: d,
+			Current:         d.DeviceID == claims.DeviceID,
+		}
+
+		if loginAt, ok := live[d.DeviceID]; ok {
+			t := loginAt
+			info.LoginAt = &t
+			ttl, exists := ttls[d.DeviceID]
+			info.Active = exists && !d.Revoked && d.ExpiresAt.After(s.clock.Now())
+			if info.Active {
+				idle := s.clock.Now().Add(ttl)
+				info.IdleExpiresAt = &idle
+			}
+		}
+		sessions = append(sessions, info)
+	}
+
+	log.Printf("[AUTH] ListSessions user=%s count=%d", claims.UserID, len(sessions))
+	return sessions, activeCount, hasMore, nil
+}
+
+// RevokeSession ends one of the token owner's other sessions.
+// Postgres goes first, the redis eviction is retried by the outbox worker
+// if it fails here.
+func (s *AuthService) RevokeSession(ctx context.Context, tenantID string, accessToken string, deviceID string, ip string) error {
+	claims, err := s.activeClaims(ctx, tenantID, accessToken)
+	if err != nil {
+		return err
+	}
+	if deviceID == claims.DeviceID {
+		return ErrCannotRevokeCurrent
+	}
+
+	log.Printf("[AUTH] RevokeSession start user=%s device=%s by=%s", claims.UserID, deviceID, claims.DeviceID)
+
+	// a session active in either store is revoked, one in neither is not
+	// touched so a 404 never hides a revocation
+	live, err := s.sessionStore.GetDevices(ctx, claims.Tenant(), claims.UserID)
+	if err != nil {
+		log.Printf("[ERROR] Redis GetDevices failed: %+v", err)
+		return err
+	}
+	_, active := live[deviceID]
+	if !active {
+		ids, err := s.authUserRepo.ActiveDeviceIDs(ctx, claims.Tenant(), claims.UserID)
+		if err != nil {
+			log.Printf("[ERROR] Postgres ActiveDeviceIDs failed: %+v", err)
+			return err
+		}
+		active = slices.Contains(ids, deviceID)
+	}
+	if !active {
+		return ErrSessionNotFound
+	}
+
+	// postgres
+	if err := s.authUserRepo.RevokeDevice(ctx, claims.Tenant(), claims.UserID, deviceID); err != nil {
+		log.Printf("[ERROR] Postgres RevokeDevice failed: %+v", err)
+		return err
+	}
+	// redis
+	s.applyRevocation(ctx, claims.Tenant(), claims.UserID, []string{deviceID}, func() error {
+		return s.sessionStore.LogoutDevice(ctx, claims.Tenant(), claims.UserID, deviceID)
+	})
+
+	s.auditor.Record(audit.Event{
+		Time:          s.clock.Now(),
+		Type:          audit.EventSessionRevoked,
+		UserID:        claims.UserID,
+		DeviceID:      deviceID,
+		ActorDeviceID: claims.DeviceID,
+		IP:            ip,
+	})
+	log.Printf("[AUTH] RevokeSession success user=%s device=%s", claims.UserID, deviceID)
+	return nil
+}
+
+// RevokeOtherSessions logs out every device of the user except the one
+// holding accessToken and returns how many sessions were ended.
+func (s *AuthService) RevokeOtherSessions(ctx context.Context, tenantID string, accessToken string, ip string) (int, error) {
+	claims, err := s.activeClaims(ctx, tenantID, accessToken)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[AUTH] RevokeOtherSessions start user=%s keep=%s", claims.UserID, claims.DeviceID)
+
+	// postgres
+	revoked, err := s.authUserRepo.RevokeOtherDevices(ctx, claims.Tenant(), claims.UserID, claims.DeviceID)
+	if err != nil {
+		log.Printf("[ERROR] Postgres RevokeOtherDevices failed: %+v", err)
+		return 0, err
+	}
+	// redis
+	var removed []string
+	s.applyRevocation(ctx, claims.Tenant(), claims.UserID, revoked, func() error {
+		var err error
+		removed, err = s.sessionStore.LogoutOtherDevices(ctx, claims.Tenant(), claims.UserID, claims.DeviceID)
+		return err
+	})
+
+	devices := mergeDeviceIDs(removed, revoked)
+	s.auditor.Record(audit.Event{
+		Time:          s.clock.Now(),
+		Type:          audit.EventOtherSessionsRevoked,
+		UserID:        claims.UserID,
+		ActorDeviceID: claims.DeviceID,
+		IP:            ip,
+		Details:       map[string]any{"device_ids": devices},
+	})
+	log.Printf("[AUTH] RevokeOtherSessions success user=%s count=%d", claims.UserID, len(devices))
+	return len(devices), nil
+}
+
+// activeClaims parses accessToken of tenantID and makes sure its session is
+// still alive.
+func (s *AuthService) activeClaims(ctx context.Context, tenantID string, accessToken string) (*token.Claims, error) {
+	claims, err := token.ParseAt(accessToken, tenantID, s.clock.Now())
+	if err != nil {
+		log.Printf("[ERROR] Token parse failed: %+v", err)
+		return nil, err
+	}
+	if claims.UserID == "" || claims.DeviceID == "" {
+		return nil, errors.New("missing claims")
+	}
+
+	exists, err := s.ExistsSession(ctx, claims.Tenant(), claims.UserID, claims.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("session expired")
+	}
+	return claims, nil
+}
+
+func mergeDeviceIDs(lists ...[]string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, list := range lists {
+		for _, id := range list {
+			if !seen[id] {
+				seen[id] = true
+				out = append(out, id)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"time"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+	"central-auth/internal/sms"
+	"central-auth/internal/token"
+)
+
+const (
+	OTPCodeLength   = 6
+	OTPTTL          = time.Minute * 5
+	OTPMaxAttempts  = 5
+	OTPSendCooldown = time.Second * 30
+	OTPSendWindow   = time.Hour
+	OTPMaxPerWindow = 5
+)
+
+// OTP purposes
+const (
+	OTPPurposeLogin  = "login"  // primary login by phone number
+	OTPPurposeMFA    = "mfa"    // second factor for a known user
+	OTPPurposeEnroll = "enroll" // verify and attach a phone number to the user
+)
+
+var (
+	ErrInvalidPhone        = errors.New("phone number must be in E.164 format")
+	ErrOTPRateLimited      = errors.New("too many codes sent to this number, try again later")
+	ErrOTPNotFound         = errors.New("code expired or not found")
+	ErrOTPInvalid          = errors.New("invalid code")
+	ErrOTPTooManyAttempts  = errors.New("too many attempts, request a new code")
+	ErrOTPNoPhone          = errors.New("user has no verified phone number")
+	ErrOTPUnsupportedUsage = errors.New("unsupported otp purpose")
+	ErrOTPDeviceRequired   = errors.New("device_id is required")
+)
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+type OTPService struct {
+	sessionStore repository.SessionStore
+	authUserRepo repository.AuthUserRepository
+	authService  *AuthService
+	sender       sms.Sender
+}
+
+func NewOTPService(
+	sessionStore repository.SessionStore,
+	authUserRepo repository.AuthUserRepository,
+	authService *AuthService,
+	sender sms.Sender,
+) *OTPService {
+	return &OTPService{
+		sessionStore: sessionStore,
+		authUserRepo: authUserRepo,
+		authService:  authService,
+		sender:       sender,
+	}
+}
+
+// SendSMS creates a challenge of tenantID and texts the code.
+//   - login: phone is required
+//   - mfa: userID is required, the code goes to the user's stored number
+//   - enroll: accessToken and phone are required
+//
+// Returns the challenge id and the masked destination number.
+func (s *OTPService) SendSMS(ctx context.Context, tenantID, purpose, phone, userID, accessToken string) (string, string, error) {
+	tenantID = tenantOrDefault(tenantID)
+	switch purpose {
+	case OTPPurposeLogin:
+		// no text for a login the tenant refuses anyway
+		if !s.authService.Tenant(tenantID).AllowsProvider(policy.ProviderSMS) {
+			return "", "", ErrProviderNotAllowed
+		}
+		userID = ""
+	case OTPPurposeMFA:
+		user, err := s.authUserRepo.FindByUserID(ctx, tenantID, userID)
+		if err != nil {
+			log.Printf("[ERROR] FindByUserID failed: %+v", err)
+			return "", "", err
+		}
+		if user == nil || user.PhoneNumber == nil {
+			return "", "", ErrOTPNoPhone
+		}
+		phone = *user.PhoneNumber
+	case OTPPurposeEnroll:
+		claims, err := s.authService.activeClaims(ctx, tenantID, accessToken)
+		if err != nil {
+			return "", "", err
+		}
+		userID = claims.UserID
+	default:
+		return "", "", ErrOTPUnsupportedUsage
+	}
+
+	if !e164.MatchString(phone) {
+		return "", "", ErrInvalidPhone
+	}
+
+	allowed, err := s.sessionStore.AllowOTPSend(ctx, phone, OTPSendCooldown, OTPSendWindow, OTPMaxPerWindow)
+	if err != nil {
+		log.Printf("[ERROR] Redis AllowOTPSend failed: %+v", err)
+		return "", "", err
+	}
+	if !allowed {
+		log.Printf("[WARN] OTP rate limited phone=%s", maskPhone(phone))
+		return "", "", ErrOTPRateLimited
+	}
+
+	code, err := generateOTPCode()
+	if err != nil {
+		return "", "", err
+	}
+
+	otpID := s.authService.ids.NewID()
+	if err := s.sessionStore.SaveOTPChallenge(ctx, &domain.OTPChallenge{
+		ID:       otpID,
+		TenantID: tenantID,
+		Purpose:  purpose,
+		Phone:    phone,
+		UserID:   userID,
+		CodeHash: hashOTP(otpID, code),
+	}, OTPTTL); err != nil {
+		log.Printf("[ERROR] Redis SaveOTPChallenge failed: %+v", err)
+		return "", "", err
+	}
+
+	message := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.",
+		code, int(OTPTTL.Minutes()))
+	if err := s.sender.Send(ctx, phone, message); err != nil {
+		log.Printf("[ERROR] SMS send failed: %+v", err)
+		_ = s.sessionStore.DeleteOTPChallenge(ctx, otpID)
+		return "", "", err
+	}
+
+	log.Printf("[AUTH] OTP sent purpose=%s phone=%s", purpose, maskPhone(phone))
+	return otpID, maskPhone(phone), nil
+}
+
+// VerifySMS checks the code of a challenge created for opts.Tenant. login
+// and mfa end in AuthService.Login and return a token pair; enroll stores
+// the phone number and returns nil.
+func (s *OTPService) VerifySMS(
+	ctx context.Context,
+	otpID string,
+	code string,
+	deviceID string,
+	rememberMe bool,
+	opts LoginOptions,
+	userAgent *string,
+	ip *string,
+) (*LoginResult, error) {
+
+	opts.Tenant = tenantOrDefault(opts.Tenant)
+	ch, err := s.checkCode(ctx, opts.Tenant, otpID, code, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ch.Purpose {
+	case OTPPurposeEnroll:
+		if err := s.authUserRepo.SetPhoneNumber(ctx, opts.Tenant, ch.UserID, ch.Phone); err != nil {
+			log.Printf("[ERROR] Postgres SetPhoneNumber failed: %+v", err)
+			return nil, err
+		}
+		log.Printf("[AUTH] Phone enrolled user=%s phone=%s", ch.UserID, maskPhone(ch.Phone))
+		return nil, nil
+
+	case OTPPurposeMFA:
+		auth := s.authService.NewAuthInfo(token.AMRExternal, token.AMRSMS)
+		opts.Provider = policy.ProviderSMS
+		return s.authService.Login(ctx, ch.UserID, deviceID, rememberMe, auth, opts, userAgent, ip)
+
+	case OTPPurposeLogin:
+		user, err := s.authUserRepo.FindByPhone(ctx, opts.Tenant, ch.Phone)
+		if err != nil {
+			log.Printf("[ERROR] FindByPhone failed: %+v", err)
+			return nil, err
+		}
+		if user == nil {
+			log.Printf("[AUTH] Creating new AuthUser for phone=%s", maskPhone(ch.Phone))
+			phone := ch.Phone
+			user = &domain.AuthUser{
+				TenantID:    opts.Tenant,
+				UserID:      s.authService.ids.NewID(),
+				Provider:    policy.ProviderSMS,
+				ProviderID:  phone,
+				PhoneNumber: &phone,
+			}
+			if err := s.authUserRepo.Save(ctx, user); err != nil {
+				log.Printf("[ERROR] Save AuthUser failed: %+v", err)
+				return nil, err
+			}
+		}
+		auth := s.authService.NewAuthInfo(token.AMRSMS)
+		opts.Provider = policy.ProviderSMS
+		return s.authService.Login(ctx, user.UserID, deviceID, rememberMe, auth, opts, userAgent, ip)
+	}
+
+	return nil, ErrOTPUnsupportedUsage
+}
+
+// checkCode enforces the attempt limit and consumes the challenge on success.
+// Challenges of other tenants are reported as not found. Purposes that log
+// in need deviceID, without it the code is refused before an attempt counts.
+func (s *OTPService) checkCode(ctx context.Context, tenantID, otpID, code, deviceID string) (*domain.OTPChallenge, error) {
+	ch, err := s.sessionStore.GetOTPChallenge(ctx, otpID)
+	if err != nil {
+		log.Printf("[ERROR] Redis GetOTPChallenge failed: %+v", err)
+		return nil, err
+	}
+	if ch == nil || tenantOrDefault(ch.TenantID) != tenantID {
+		return nil, ErrOTPNotFound
+	}
+	if ch.Purpose != OTPPurposeEnroll && deviceID == "" {
+		return nil, ErrOTPDeviceRequired
+	}
+
+	attempts, err := s.sessionStore.IncrOTPAttempts(ctx, otpID)
+	if errors.Is(err, repository.ErrOTPChallengeNotFound) {
+		return nil, ErrOTPNotFound
+	}
+	if err != nil {
+		log.Printf("[ERROR] Redis IncrOTPAttempts failed: %+v", err)
+		return nil, err
+	}
+	if attempts > OTPMaxAttempts {
+		_ = s.sessionStore.DeleteOTPChallenge(ctx, otpID)
+		log.Printf("[WARN] OTP too many attempts phone=%s", maskPhone(ch.Phone))
+		return nil, ErrOTPTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashOTP(otpID, code)), []byte(ch.CodeHash)) != 1 {
+		log.Printf("[WARN] OTP invalid code phone=%s attempt=%d", maskPhone(ch.Phone), attempts)
+		return nil, ErrOTPInvalid
+	}
+
+	if err := s.sessionStore.DeleteOTPChallenge(ctx, otpID); err != nil {
+		log.Printf("[ERROR] Redis DeleteOTPChallenge failed: %+v", err)
+		return nil, err
+	}
+	return ch, nil
+}
+
+func generateOTPCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < OTPCodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", OTPCodeLength, n), nil
+}
+
+// codes are salted with their challenge id so equal codes never share a hash
+func hashOTP(otpID, code string) string {
+	return token.Hash(otpID + ":" + code)
+}
+
+func maskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return "****"
+	}
+	return phone[:3] + "****" + phone[len(phone)-2:]
+}
@@ -0,0 +1,259 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+
+	"central-auth/internal/policy"
+	"central-auth/internal/service"
+	"central-auth/internal/token"
+)
+
+const phone = "+15550001111"
+
+// smsOutbox keeps the texts instead of sending them.
+type smsOutbox struct {
+	mu   sync.Mutex
+	sent map[string][]string // phone -> messages
+}
+
+func (o *smsOutbox) Send(_ context.Context, phone, message string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent[phone] = append(o.sent[phone], message)
+	return nil
+}
+
+var otpCode = regexp.MustCompile(`\b[0-9]{6}\b`)
+
+// code returns the code of the last text to phone.
+func (o *smsOutbox) code(t *testing.T, phone string) string {
+	t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	msgs := o.sent[phone]
+	if len(msgs) == 0 {
+		t.Fatalf("no text to %s", phone)
+	}
+	code := otpCode.FindString(msgs[len(msgs)-1])
+	if code == "" {
+		t.Fatalf("no code in %q", msgs[len(msgs)-1])
+	}
+	return code
+}
+
+func newOTPEnv(t *testing.T) (*env, *service.OTPService, *smsOutbox) {
+	e := newMemoryEnv(t, fiveDevices, policy.SessionPolicy{})
+	outbox := &smsOutbox{sent: map[string][]string{}}
+	return e, service.NewOTPService(e.sessions, e.repo, e.svc, outbox), outbox
+}
+
+// wrongCode differs from code in every digit.
+func wrongCode(code string) string {
+	b := []byte(code)
+	for i := range b {
+		b[i] = '0' + (b[i]-'0'+1)%10
+	}
+	return string(b)
+}
+
+func TestSMSLogin(t *testing.T) {
+	e, otp, outbox := newOTPEnv(t)
+
+	otpID, masked, err := otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, phone, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masked == phone {
+		t.Fatalf("destination not masked: %s", masked)
+	}
+	code := outbox.code(t, phone)
+
+	res, err := otp.VerifySMS(ctx, otpID, code, "d1", false, service.LoginOptions{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := token.ParseAt(res.AccessToken, tenant, e.clock.Now())
+	if err != nil || claims.ACR != token.ACRSingleFactor || !slices.Equal(claims.AMR, []string{token.AMRSMS}) {
+		t.Fatalf("claims = %+v, %v", claims, err)
+	}
+	user, _ := e.repo.FindByPhone(ctx, tenant, phone)
+	if user == nil || user.UserID != claims.UserID || user.Provider != policy.ProviderSMS {
+		t.Fatalf("user = %+v", user)
+	}
+
+	// a code is used once
+	if _, err := otp.VerifySMS(ctx, otpID, code, "d1", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrOTPNotFound) {
+		t.Fatalf("second VerifySMS = %v, want ErrOTPNotFound", err)
+	}
+
+	// the next login finds the same user
+	e.advance(service.OTPSendCooldown)
+	otpID, _, err = otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, phone, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = otp.VerifySMS(ctx, otpID, outbox.code(t, phone), "d2", false, service.LoginOptions{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := token.ParseAt(res.AccessToken, tenant, e.clock.Now()); again.UserID != claims.UserID {
+		t.Fatalf("second login as %s, want %s", again.UserID, claims.UserID)
+	}
+}
+
+func TestSMSVerifyWithoutDeviceKeepsCode(t *testing.T) {
+	_, otp, outbox := newOTPEnv(t)
+	otpID, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, phone, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := outbox.code(t, phone)
+
+	for i := 0; i < service.OTPMaxAttempts+1; i++ {
+		if _, err := otp.VerifySMS(ctx, otpID, code, "", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrOTPDeviceRequired) {
+			t.Fatalf("VerifySMS without device = %v, want ErrOTPDeviceRequired", err)
+		}
+	}
+	if _, err := otp.VerifySMS(ctx, otpID, code, "d1", false, service.LoginOptions{}, nil, nil); err != nil {
+		t.Fatalf("VerifySMS after missing device: %v", err)
+	}
+}
+
+func TestSMSAttemptLimit(t *testing.T) {
+	_, otp, outbox := newOTPEnv(t)
+	otpID, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, phone, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := outbox.code(t, phone)
+
+	for i := 0; i < service.OTPMaxAttempts; i++ {
+		if _, err := otp.VerifySMS(ctx, otpID, wrongCode(code), "d1", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrOTPInvalid) {
+			t.Fatalf("attempt %d = %v, want ErrOTPInvalid", i+1, err)
+		}
+	}
+	// over the limit even the right code fails, and the challenge is gone
+	if _, err := otp.VerifySMS(ctx, otpID, code, "d1", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrOTPTooManyAttempts) {
+		t.Fatalf("attempt over the limit = %v, want ErrOTPTooManyAttempts", err)
+	}
+	if _, err := otp.VerifySMS(ctx, otpID, code, "d1", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrOTPNotFound) {
+		t.Fatalf("after the limit = %v, want ErrOTPNotFound", err)
+	}
+}
+
+func TestSMSCodeExpires(t *testing.T) {
+	e, otp, outbox := newOTPEnv(t)
+	otpID, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, phone, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.advance(service.OTPTTL)
+	if _, err := otp.VerifySMS(ctx, otpID, outbox.code(t, phone), "d1", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrOTPNotFound) {
+		t.Fatalf("expired code = %v, want ErrOTPNotFound", err)
+	}
+}
+
+func TestSMSSendLimits(t *testing.T) {
+	e, otp, _ := newOTPEnv(t)
+	send := func() error {
+		_, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, phone, "", "")
+		return err
+	}
+
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(); !errors.Is(err, service.ErrOTPRateLimited) {
+		t.Fatalf("send in cooldown = %v, want ErrOTPRateLimited", err)
+	}
+	// other numbers have their own limits
+	if _, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, "+15550002222", "", ""); err != nil {
+		t.Fatalf("other number: %v", err)
+	}
+
+	for i := 1; i < service.OTPMaxPerWindow; i++ {
+		e.advance(service.OTPSendCooldown)
+		if err := send(); err != nil {
+			t.Fatalf("send %d: %v", i+1, err)
+		}
+	}
+	e.advance(service.OTPSendCooldown)
+	if err := send(); !errors.Is(err, service.ErrOTPRateLimited) {
+		t.Fatalf("send over the window = %v, want ErrOTPRateLimited", err)
+	}
+	e.advance(service.OTPSendWindow)
+	if err := send(); err != nil {
+		t.Fatalf("send in the next window: %v", err)
+	}
+
+	if _, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeLogin, "5550001111", "", ""); !errors.Is(err, service.ErrInvalidPhone) {
+		t.Fatalf("send to non E.164 = %v, want ErrInvalidPhone", err)
+	}
+}
+
+func TestSMSChallengeTenantScoped(t *testing.T) {
+	e, otp, outbox := newOTPEnv(t)
+	otpID, _, err := otp.SendSMS(ctx, "acme", service.OTPPurposeLogin, phone, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := outbox.code(t, phone)
+
+	if _, err := otp.VerifySMS(ctx, otpID, code, "d1", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrOTPNotFound) {
+		t.Fatalf("VerifySMS in another tenant = %v, want ErrOTPNotFound", err)
+	}
+	res, err := otp.VerifySMS(ctx, otpID, code, "d1", false, service.LoginOptions{Tenant: "acme"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := token.ParseAt(res.AccessToken, "acme", e.clock.Now()); err != nil || claims.Tenant() != "acme" {
+		t.Fatalf("acme token = %+v, %v", claims, err)
+	}
+}
+
+func TestSMSEnrollAndMFA(t *testing.T) {
+	e, otp, outbox := newOTPEnv(t)
+	res := e.login(t, "d1")
+
+	if _, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeMFA, "", "u1", ""); !errors.Is(err, service.ErrOTPNoPhone) {
+		t.Fatalf("mfa without phone = %v, want ErrOTPNoPhone", err)
+	}
+
+	otpID, _, err := otp.SendSMS(ctx, tenant, service.OTPPurposeEnroll, phone, "", res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// enroll issues no tokens and needs no device
+	enrolled, err := otp.VerifySMS(ctx, otpID, outbox.code(t, phone), "", false, service.LoginOptions{}, nil, nil)
+	if err != nil || enrolled != nil {
+		t.Fatalf("VerifySMS(enroll) = %+v, %v", enrolled, err)
+	}
+	if user, _ := e.repo.FindByUserID(ctx, tenant, "u1"); user == nil || user.PhoneNumber == nil || *user.PhoneNumber != phone {
+		t.Fatalf("user = %+v", user)
+	}
+
+	// the mfa code goes to the enrolled number, whatever the request says
+	e.advance(service.OTPSendCooldown)
+	otpID, _, err = otp.SendSMS(ctx, tenant, service.OTPPurposeMFA, "+15559999999", "u1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, err := otp.VerifySMS(ctx, otpID, outbox.code(t, phone), "d2", false, service.LoginOptions{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := token.ParseAt(mfa.AccessToken, tenant, e.clock.Now())
+	if err != nil || claims.UserID != "u1" || claims.ACR != token.ACRMultiFactor {
+		t.Fatalf("mfa claims = %+v, %v", claims, err)
+	}
+
+	if _, _, err := otp.SendSMS(ctx, tenant, "recover", phone, "", ""); !errors.Is(err, service.ErrOTPUnsupportedUsage) {
+		t.Fatalf("unknown purpose = %v, want ErrOTPUnsupportedUsage", err)
+	}
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/domain"
+	"central-auth/internal/ids"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+	"central-auth/internal/token"
+	"central-auth/pkg/servicesign"
+)
+
+var (
+	ErrInvalidServiceKey         = errors.New("invalid service key")
+	ErrServiceIPNotAllowed       = errors.New("service not allowed from this address")
+	ErrServiceEndpointNotAllowed = errors.New("service not allowed to call this endpoint")
+	ErrServiceClientNotFound     = errors.New("service client not found")
+	ErrServiceKeyNotFound        = errors.New("service key not found or already revoked")
+	ErrInvalidServiceCertificate = errors.New("client certificate not mapped to a service")
+	ErrInvalidServiceSignature   = errors.New("invalid service request signature")
+	ErrReplayedServiceRequest    = errors.New("service request nonce already used")
+)
+
+var (
+	serviceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	noncePattern       = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+)
+
+// ServiceClientService manages the backends allowed to call the API and
+// authenticates their requests. An API key is "<key_id>.<secret>", only the
+// sha256 of the secret is stored, and its servicesign key sealed with the
+// server key.
+type ServiceClientService struct {
+	authUserRepo repository.AuthUserRepository
+	sessionStore repository.SessionStore // nonces of signed requests
+	sealer       *token.Sealer           // signing keys at rest
+	clock        clock.Clock
+	ids          ids.Generator
+}
+
+// NewServiceClientService takes the session store for the replay check of
+// signed requests; it may be nil when they are not verified (the CLI).
+func NewServiceClientService(repo repository.AuthUserRepository, sessionStore repository.SessionStore, sealer *token.Sealer, clk clock.Clock, idGen ids.Generator) *ServiceClientService {
+	return &ServiceClientService{
+		authUserRepo: repo,
+		sessionStore: sessionStore,
+		sealer:       sealer,
+		clock:        clk,
+		ids:          idGen,
+	}
+}
+
+// Register creates the service client or replaces the settings of an
+// existing one. Its keys are kept.
+func (s *ServiceClientService) Register(ctx context.Context, client *domain.ServiceClient) error {
+	if !serviceNamePattern.MatchString(client.Name) {
+		return fmt.Errorf("invalid service name %q: lower case letters, digits, - and _", client.Name)
+	}
+	if client.TenantID == "" {
+		client.TenantID = domain.DefaultTenant
+	}
+	if !policy.ValidTenantID(client.TenantID) {
+		return fmt.Errorf("invalid tenant %q: lower case letters, digits, - and _", client.TenantID)
+	}
+	for _, e := range client.AllowedEndpoints {
+		if _, _, err := policy.ParseEndpoint(e); err != nil {
+			return err
+		}
+	}
+	for _, ip := range client.AllowedIPs {
+		if _, err := parseIPPattern(ip); err != nil {
+			return err
+		}
+	}
+	for _, id := range client.CertIdentities {
+		kind, value, _ := strings.Cut(id, ":")
+		if !slices.Contains(certIdentityKinds, kind) || value == "" {
+			return fmt.Errorf("invalid certificate identity %q: want cn:, dns:, uri: or email: and a value", id)
+		}
+	}
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = s.clock.Now()
+	}
+	if err := s.authUserRepo.SaveServiceClient(ctx, client); err != nil {
+		log.Printf("[ERROR] SaveServiceClient failed: %+v", err)
+		return err
+	}
+	log.Printf("[AUTH] Service client registered name=%s tenant=%s disabled=%t", client.Name, client.TenantID, client.Disabled)
+	return nil
+}
+
+// IssueKey adds an API key to serviceName, valid for ttl (0 never expires).
+// Older keys stay valid until revoked, so callers can switch over without
+// downtime. The returned key is not stored and can not be shown again.
+func (s *ServiceClientService) IssueKey(ctx context.Context, serviceName string, ttl time.Duration) (string, *domain.ServiceClientKey, error) {
+	client, err := s.authUserRepo.FindServiceClient(ctx, serviceName)
+	if err != nil {
+		return "", nil, err
+	}
+	if client == nil {
+		return "", nil, ErrServiceClientNotFound
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+	signingKey, err := s.sealer.Seal(servicesign.SigningKey(secret))
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := s.clock.Now()
+	key := &domain.ServiceClientKey{
+		KeyID:       s.ids.NewID(),
+		ServiceName: serviceName,
+		KeyHash:     token.Hash(secret),
+		SigningKey:  signingKey,
+		CreatedAt:   now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+	if err := s.authUserRepo.SaveServiceClientKey(ctx, key); err != nil {
+		log.Printf("[ERROR] SaveServiceClientKey failed: %+v", err)
+		return "", nil, err
+	}
+	log.Printf("[AUTH] Service key issued service=%s key=%s", serviceName, key.KeyID)
+	return key.KeyID + "." + secret, key, nil
+}
+
+// RevokeKey makes the key unusable at once.
+func (s *ServiceClientService) RevokeKey(ctx context.Context, keyID string) error {
+	ok, err := s.authUserRepo.RevokeServiceClientKey(ctx, keyID)
+	if err != nil {
+		log.Printf("[ERROR] RevokeServiceClientKey failed: %+v", err)
+		return err
+	}
+	if !ok {
+		return ErrServiceKeyNotFound
+	}
+	log.Printf("[AUTH] Service key revoked key=%s", keyID)
+	return nil
+}
+
+func (s *ServiceClientService) List(ctx context.Context) ([]domain.ServiceClient, error) {
+	return s.authUserRepo.ListServiceClients(ctx)
+}
+
+func (s *ServiceClientService) Keys(ctx context.Context, serviceName string) ([]domain.ServiceClientKey, error) {
+	return s.authUserRepo.ListServiceClientKeys(ctx, serviceName)
+}
+
+// Authenticate resolves the service client of an API key and checks it may
+// call method path (the route pattern) from ip.
+func (s *ServiceClientService) Authenticate(ctx context.Context, apiKey, ip, method, path string) (*domain.ServiceClient, error) {
+	keyID, secret, ok := strings.Cut(apiKey, ".")
+	if !ok || keyID == "" || secret == "" {
+		return nil, ErrInvalidServiceKey
+	}
+
+	key, err := s.authUserRepo.FindServiceClientKey(ctx, keyID)
+	if err != nil {
+		log.Printf("[ERROR] FindServiceClientKey failed: %+v", err)
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrInvalidServiceKey
+	}
+	now := s.clock.Now()
+	if subtle.ConstantTimeCompare([]byte(token.Hash(secret)), []byte(key.KeyHash)) != 1 ||
+		key.Revoked || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		log.Printf("[WARN] Service key rejected key=%s", keyID)
+		return nil, ErrInvalidServiceKey
+	}
+
+	client, err := s.authUserRepo.FindServiceClient(ctx, key.ServiceName)
+	if err != nil {
+		log.Printf("[ERROR] FindServiceClient failed: %+v", err)
+		return nil, err
+	}
+	if client == nil || client.Disabled {
+		log.Printf("[WARN] Service client missing or disabled service=%s", key.ServiceName)
+		return nil, ErrInvalidServiceKey
+	}
+	if err := s.Authorize(client, ip, method, path); err != nil {
+		return nil, err
+	}
+
+	// only bookkeeping for rotations, the request goes on without it
+	if err := s.authUserRepo.TouchServiceClientKey(ctx, keyID, now); err != nil {
+		log.Printf("[WARN] TouchServiceClientKey failed: %+v", err)
+	}
+	return client, nil
+}
+
+// SignedRequest is what a request signed with servicesign carries.
+type SignedRequest struct {
+	KeyID     string
+	Timestamp string // unix seconds
+	Nonce     string
+	Signature string
+	Method    string
+	URI       string // path and raw query
+	BodyHash  string
+}
+
+// AuthenticateSignature verifies a signed request and checks its service
+// client may call method path (the route pattern) from ip. The timestamp may
+// be off by maxSkew; a nonce is accepted once within that window, after the
+// signature verified so forged requests can not burn nonces.
+func (s *ServiceClientService) AuthenticateSignature(ctx context.Context, req *SignedRequest, maxSkew time.Duration, ip, method, path string) (*domain.ServiceClient, error) {
+	ts, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil || req.KeyID == "" || !noncePattern.MatchString(req.Nonce) {
+		return nil, ErrInvalidServiceSignature
+	}
+	now := s.clock.Now()
+	if skew := now.Sub(time.Unix(ts, 0)).Abs(); skew > maxSkew {
+		log.Printf("[WARN] Signed request outside clock skew key=%s skew=%s", req.KeyID, skew)
+		return nil, ErrInvalidServiceSignature
+	}
+
+	key, err := s.authUserRepo.FindServiceClientKey(ctx, req.KeyID)
+	if err != nil {
+		log.Printf("[ERROR] FindServiceClientKey failed: %+v", err)
+		return nil, err
+	}
+	if key == nil || key.Revoked || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidServiceSignature
+	}
+	if key.SigningKey == nil {
+		log.Printf("[WARN] Service key without signing key, reissue it to sign requests key=%s", req.KeyID)
+		return nil, ErrInvalidServiceSignature
+	}
+	// never the stored hash, a copy of the database must not sign requests
+	signingKey, err := s.sealer.Open(key.SigningKey)
+	if err != nil {
+		log.Printf("[ERROR] Signing key can not be opened, was the server key changed? key=%s", req.KeyID)
+		return nil, ErrInvalidServiceSignature
+	}
+	expected := servicesign.Signature(signingKey, servicesign.StringToSign(req.Method, req.URI, req.Timestamp, req.Nonce, req.BodyHash))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(req.Signature))) {
+		log.Printf("[WARN] Service request signature rejected key=%s", req.KeyID)
+		return nil, ErrInvalidServiceSignature
+	}
+
+	client, err := s.authUserRepo.FindServiceClient(ctx, key.ServiceName)
+	if err != nil {
+		log.Printf("[ERROR] FindServiceClient failed: %+v", err)
+		return nil, err
+	}
+	if client == nil || client.Disabled {
+		log.Printf("[WARN] Service client missing or disabled service=%s", key.ServiceName)
+		return nil, ErrInvalidServiceSignature
+	}
+	if err := s.Authorize(client, ip, method, path); err != nil {
+		return nil, err
+	}
+
+	// a nonce older than the skew window fails the timestamp check anyway
+	fresh, err := s.sessionStore.ClaimNonce(ctx, client.Name, req.Nonce, 2*maxSkew)
+	if err != nil {
+		log.Printf("[ERROR] ClaimNonce failed: %+v", err)
+		return nil, err
+	}
+	if !fresh {
+		log.Printf("[WARN] Replayed service request service=%s nonce=%s", client.Name, req.Nonce)
+		return nil, ErrReplayedServiceRequest
+	}
+
+	if err := s.authUserRepo.TouchServiceClientKey(ctx, key.KeyID, now); err != nil {
+		log.Printf("[WARN] TouchServiceClientKey failed: %+v", err)
+	}
+	return client, nil
+}
+
+// AuthenticateCertificate resolves the service client of a TLS client
+// certificate the server already verified against the client CA bundle, and
+// checks it may call method path from ip. The certificate must map to
+// exactly one registered service.
+func (s *ServiceClientService) AuthenticateCertificate(ctx context.Context, cert *x509.Certificate, ip, method, path string) (*domain.ServiceClient, error) {
+	identities := CertIdentities(cert)
+	names, err := s.authUserRepo.ServiceNamesByCertIdentity(ctx, identities)
+	if err != nil {
+		log.Printf("[ERROR] ServiceNamesByCertIdentity failed: %+v", err)
+		return nil, err
+	}
+	if len(names) != 1 {
+		log.Printf("[WARN] Client certificate rejected subject=%q services=%v", cert.Subject.String(), names)
+		return nil, ErrInvalidServiceCertificate
+	}
+
+	client, err := s.authUserRepo.FindServiceClient(ctx, names[0])
+	if err != nil {
+		log.Printf("[ERROR] FindServiceClient failed: %+v", err)
+		return nil, err
+	}
+	if client == nil || client.Disabled {
+		log.Printf("[WARN] Service client missing or disabled service=%s", names[0])
+		return nil, ErrInvalidServiceCertificate
+	}
+	if err := s.Authorize(client, ip, method, path); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+var certIdentityKinds = []string{"cn", "dns", "uri", "email"}
+
+// CertIdentities lists the names a certificate can be registered under:
+// the subject CN and every DNS, URI and email SAN.
+func CertIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, "cn:"+cert.Subject.CommonName)
+	}
+	for _, dns := range cert.DNSNames {
+		ids = append(ids, "dns:"+dns)
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, "uri:"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		ids = append(ids, "email:"+email)
+	}
+	return ids
+}
+
+// Authorize checks the IP allowlist and the allowed endpoints of client.
+func (s *ServiceClientService) Authorize(client *domain.ServiceClient, ip, method, path string) error {
+	if len(client.AllowedIPs) > 0 && !ipAllowed(client.AllowedIPs, ip) {
+		log.Printf("[WARN] Service call from disallowed address service=%s ip=%s", client.Name, ip)
+		return ErrServiceIPNotAllowed
+	}
+	if len(client.AllowedEndpoints) > 0 && !endpointAllowed(client.AllowedEndpoints, method, path) {
+		log.Printf("[WARN] Service call to disallowed endpoint service=%s %s %s", client.Name, method, path)
+		return ErrServiceEndpointNotAllowed
+	}
+	return nil
+}
+
+// parseIPPattern accepts an address or a CIDR.
+func parseIPPattern(p string) (netip.Prefix, error) {
+	if strings.Contains(p, "/") {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid allowed ip %q: %w", p, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(p)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid allowed ip %q: %w", p, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func ipAllowed(patterns []string, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range patterns {
+		if prefix, err := parseIPPattern(p); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func endpointAllowed(patterns []string, method, path string) bool {
+	return slices.ContainsFunc(patterns, func(e string) bool {
+		return policy.MatchEndpoint(e, method, path)
+	})
+}
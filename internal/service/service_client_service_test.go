@@ -0,0 +1,329 @@
+package service_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/ids"
+	"central-auth/internal/policy"
+	"central-auth/internal/service"
+	"central-auth/internal/token"
+	"central-auth/pkg/servicesign"
+)
+
+func newServiceClients(t *testing.T) (*service.ServiceClientService, *env) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	sealer, err := token.NewSealer([]byte("service-key-seal-secret-for-tests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.NewServiceClientService(e.repo, e.sessions, sealer, e.clock, &ids.Sequence{Prefix: "key"}), e
+}
+
+func TestServiceKeyRotation(t *testing.T) {
+	clients, e := newServiceClients(t)
+	if err := clients.Register(ctx, &domain.ServiceClient{Name: "shop"}); err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey, _, err := clients.IssueKey(ctx, "shop", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, issued, err := clients.IssueKey(ctx, "shop", time.Hour)
+	if err != nil || !strings.HasPrefix(newKey, "key-2.") || issued.KeyHash == "" {
+		t.Fatalf("IssueKey = %q, %+v, %v", newKey, issued, err)
+	}
+
+	// both keys work until the old one is revoked
+	for _, k := range []string{oldKey, newKey} {
+		client, err := clients.Authenticate(ctx, k, "10.0.0.1", "POST", "/auth/login")
+		if err != nil || client.Name != "shop" {
+			t.Fatalf("Authenticate = %+v, %v", client, err)
+		}
+	}
+	if err := clients.RevokeKey(ctx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients.Authenticate(ctx, oldKey, "10.0.0.1", "POST", "/auth/login"); !errors.Is(err, service.ErrInvalidServiceKey) {
+		t.Fatalf("revoked key: %v", err)
+	}
+	if err := clients.RevokeKey(ctx, "key-1"); !errors.Is(err, service.ErrServiceKeyNotFound) {
+		t.Fatalf("revoke twice: %v", err)
+	}
+
+	e.clock.Advance(time.Hour)
+	if _, err := clients.Authenticate(ctx, newKey, "10.0.0.1", "POST", "/auth/login"); !errors.Is(err, service.ErrInvalidServiceKey) {
+		t.Fatalf("expired key: %v", err)
+	}
+}
+
+func TestServiceKeyRejected(t *testing.T) {
+	clients, _ := newServiceClients(t)
+	if err := clients.Register(ctx, &domain.ServiceClient{Name: "shop"}); err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := clients.IssueKey(ctx, "shop", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, _, _ := strings.Cut(key, ".")
+
+	for _, k := range []string{"", "no-dot", keyID + ".wrong", "key-9." + strings.Repeat("a", 43)} {
+		if _, err := clients.Authenticate(ctx, k, "10.0.0.1", "GET", "/auth/sessions"); !errors.Is(err, service.ErrInvalidServiceKey) {
+			t.Fatalf("Authenticate(%q) = %v", k, err)
+		}
+	}
+
+	// a disabled service loses all of its keys
+	if err := clients.Register(ctx, &domain.ServiceClient{Name: "shop", Disabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients.Authenticate(ctx, key, "10.0.0.1", "GET", "/auth/sessions"); !errors.Is(err, service.ErrInvalidServiceKey) {
+		t.Fatalf("disabled service: %v", err)
+	}
+}
+
+func TestServiceClientAllowlists(t *testing.T) {
+	clients, _ := newServiceClients(t)
+	err := clients.Register(ctx, &domain.ServiceClient{
+		Name:             "shop",
+		AllowedEndpoints: []string{"POST /auth/login", "/auth/sessions*"},
+		AllowedIPs:       []string{"10.0.0.0/8", "192.168.1.5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := clients.IssueKey(ctx, "shop", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip, method, path string
+		want             error
+	}{
+		{"10.1.2.3", "POST", "/auth/login", nil},
+		{"::ffff:192.168.1.5", "DELETE", "/auth/sessions/:device_id", nil},
+		{"192.168.1.6", "POST", "/auth/login", service.ErrServiceIPNotAllowed},
+		{"10.1.2.3", "GET", "/auth/login", service.ErrServiceEndpointNotAllowed},
+		{"10.1.2.3", "POST", "/auth/logout", service.ErrServiceEndpointNotAllowed},
+	}
+	for _, c := range cases {
+		_, err := clients.Authenticate(ctx, key, c.ip, c.method, c.path)
+		if !errors.Is(err, c.want) || (c.want == nil && err != nil) {
+			t.Errorf("%s %s from %s: got %v, want %v", c.method, c.path, c.ip, err, c.want)
+		}
+	}
+
+	for _, bad := range []*domain.ServiceClient{
+		{Name: "Shop"},
+		{Name: "shop", AllowedIPs: []string{"10.0.0.0/33"}},
+		{Name: "shop", AllowedEndpoints: []string{"auth/login"}},
+	} {
+		if err := clients.Register(ctx, bad); err == nil {
+			t.Errorf("Register(%+v) accepted", bad)
+		}
+	}
+	if _, _, err := clients.IssueKey(ctx, "missing", 0); !errors.Is(err, service.ErrServiceClientNotFound) {
+		t.Fatalf("IssueKey(missing) = %v", err)
+	}
+}
+
+func TestLoginTokensCarryService(t *testing.T) {
+	e := newEnv(t, fiveDevices, policy.SessionPolicy{})
+	res, err := e.svc.Login(ctx, "u1", "d1", false, token.NewAuthInfo(e.clock.Now()), service.LoginOptions{Service: "shop"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := e.svc.Refresh(ctx, tenant, res.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range []string{res.AccessToken, res.RefreshToken, access} {
+		claims, err := token.ParseAt(tok, tenant, e.clock.Now())
+		if err != nil || claims.AuthorizedParty != "shop" {
+			t.Fatalf("azp = %+v, %v", claims, err)
+		}
+	}
+}
+
+func TestServiceCertificateAuth(t *testing.T) {
+	clients, _ := newServiceClients(t)
+	err := clients.Register(ctx, &domain.ServiceClient{
+		Name:             "shop",
+		AllowedEndpoints: []string{"POST /auth/login"},
+		CertIdentities:   []string{"uri:spiffe://example.org/shop", "dns:shop.internal"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := clients.Register(ctx, &domain.ServiceClient{Name: "admin", CertIdentities: []string{"cn:admin"}}); err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://example.org/shop")
+
+	cases := []struct {
+		name string
+		cert *x509.Certificate
+		path string
+		want error
+	}{
+		{"uri san", &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}, URIs: []*url.URL{spiffe}}, "/auth/login", nil},
+		{"dns san", &x509.Certificate{DNSNames: []string{"shop.internal"}}, "/auth/login", nil},
+		{"endpoint", &x509.Certificate{DNSNames: []string{"shop.internal"}}, "/auth/logout", service.ErrServiceEndpointNotAllowed},
+		{"unmapped", &x509.Certificate{Subject: pkix.Name{CommonName: "shop"}}, "/auth/login", service.ErrInvalidServiceCertificate},
+		// a certificate naming two services is refused rather than guessed
+		{"ambiguous", &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}, DNSNames: []string{"shop.internal"}}, "/auth/login", service.ErrInvalidServiceCertificate},
+	}
+	for _, c := range cases {
+		client, err := clients.AuthenticateCertificate(ctx, c.cert, "10.0.0.1", "POST", c.path)
+		if !errors.Is(err, c.want) || (c.want == nil && (err != nil || client.Name != "shop")) {
+			t.Errorf("%s: got %+v, %v, want %v", c.name, client, err, c.want)
+		}
+	}
+
+	if err := clients.Register(ctx, &domain.ServiceClient{Name: "admin", CertIdentities: []string{"cn:admin"}, Disabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients.AuthenticateCertificate(ctx, &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}, "10.0.0.1", "GET", "/auth/sessions"); !errors.Is(err, service.ErrInvalidServiceCertificate) {
+		t.Fatalf("disabled service: %v", err)
+	}
+	if err := clients.Register(ctx, &domain.ServiceClient{Name: "shop", CertIdentities: []string{"subject:shop"}}); err == nil {
+		t.Fatal("Register accepted an unknown identity kind")
+	}
+}
+
+func TestServiceAuthPolicy(t *testing.T) {
+	p := &policy.ServiceAuthPolicy{
+		Default:      []string{policy.ServiceAuthAPIKey},
+		MaxClockSkew: policy.Duration(time.Minute),
+		Routes: []policy.ServiceAuthRoute{
+			{Endpoint: "/auth/sessions*", Methods: []string{policy.ServiceAuthClientCert}},
+			{Endpoint: "POST /auth/login", Methods: []string{policy.ServiceAuthClientCert, policy.ServiceAuthAPIKey}},
+		},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ method, path, want string }{
+		{"DELETE", "/auth/sessions/:device_id", "[mtls]"},
+		{"POST", "/auth/login", "[mtls api_key]"},
+		{"GET", "/auth/login", "[api_key]"},
+	} {
+		if got := fmt.Sprint(p.Resolve(c.method, c.path)); got != c.want {
+			t.Errorf("Resolve(%s %s) = %s, want %s", c.method, c.path, got, c.want)
+		}
+	}
+	if !p.Uses(policy.ServiceAuthClientCert) || policy.DefaultServiceAuthPolicy().Uses(policy.ServiceAuthClientCert) {
+		t.Fatal("Uses")
+	}
+
+	bad := &policy.ServiceAuthPolicy{Default: []string{"password"}, MaxClockSkew: policy.Duration(time.Minute)}
+	if err := bad.Validate(); err == nil {
+		t.Fatal("Validate accepted an unknown method")
+	}
+}
+
+func TestServiceSignedRequests(t *testing.T) {
+	clients, e := newServiceClients(t)
+	if err := clients.Register(ctx, &domain.ServiceClient{Name: "shop"}); err != nil {
+		t.Fatal(err)
+	}
+	apiKey, issued, err := clients.IssueKey(ctx, "shop", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret, _ := strings.Cut(apiKey, ".")
+	signer, err := servicesign.NewSigner(apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.Now = e.clock.Now
+
+	const skew = time.Minute
+	sign := func(body string) *service.SignedRequest {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/auth/login?lang=en", strings.NewReader(body))
+		if err := signer.Sign(r); err != nil {
+			t.Fatal(err)
+		}
+		sent, _ := io.ReadAll(r.Body)
+		return &service.SignedRequest{
+			KeyID:     r.Header.Get(servicesign.HeaderKeyID),
+			Timestamp: r.Header.Get(servicesign.HeaderTimestamp),
+			Nonce:     r.Header.Get(servicesign.HeaderNonce),
+			Signature: r.Header.Get(servicesign.HeaderSignature),
+			Method:    r.Method,
+			URI:       r.URL.RequestURI(),
+			BodyHash:  servicesign.BodyHash(sent),
+		}
+	}
+	verify := func(req *service.SignedRequest) error {
+		_, err := clients.AuthenticateSignature(ctx, req, skew, "10.0.0.1", "POST", "/auth/login")
+		return err
+	}
+
+	req := sign(`{"user_id":"u1"}`)
+	if err := verify(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(req); !errors.Is(err, service.ErrReplayedServiceRequest) {
+		t.Fatalf("replay: %v", err)
+	}
+
+	tampered := sign(`{"user_id":"u1"}`)
+	tampered.BodyHash = servicesign.BodyHash([]byte(`{"user_id":"u2"}`))
+	if err := verify(tampered); !errors.Is(err, service.ErrInvalidServiceSignature) {
+		t.Fatalf("tampered body: %v", err)
+	}
+	// a rejected signature does not use up the nonce
+	tampered.BodyHash = servicesign.BodyHash([]byte(`{"user_id":"u1"}`))
+	if err := verify(tampered); err != nil {
+		t.Fatalf("nonce burnt by forged request: %v", err)
+	}
+
+	old := sign("")
+	e.advance(skew + time.Second)
+	if err := verify(old); !errors.Is(err, service.ErrInvalidServiceSignature) {
+		t.Fatalf("outside skew: %v", err)
+	}
+	// clocks may be off both ways
+	signer.Now = func() time.Time { return e.clock.Now().Add(skew - time.Second) }
+	if err := verify(sign("")); err != nil {
+		t.Fatalf("client clock ahead: %v", err)
+	}
+
+	// the key_hash readable from the database does not sign requests
+	forged := sign("")
+	forged.Nonce = strings.Repeat("f", 32)
+	forged.Signature = servicesign.Signature([]byte(issued.KeyHash), servicesign.StringToSign(forged.Method, forged.URI, forged.Timestamp, forged.Nonce, forged.BodyHash))
+	if err := verify(forged); !errors.Is(err, service.ErrInvalidServiceSignature) {
+		t.Fatalf("signed with key_hash: %v", err)
+	}
+	stored, err := e.repo.FindServiceClientKey(ctx, issued.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored.SigningKey, servicesign.SigningKey(secret)) {
+		t.Fatal("signing key stored in the clear")
+	}
+
+	keyID, _, _ := strings.Cut(apiKey, ".")
+	if err := clients.RevokeKey(ctx, keyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(sign("")); !errors.Is(err, service.ErrInvalidServiceSignature) {
+		t.Fatalf("revoked key: %v", err)
+	}
+}
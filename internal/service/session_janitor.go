@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+)
+
+// JanitorLockID is the postgres advisory lock that elects the replica
+// running the janitor.
+const JanitorLockID int64 = 0x6a616e69746f72 // "janitor"
+
+// janitor metrics, served on /debug/vars
+var janitorMetrics = expvar.NewMap("session_janitor")
+
+// SessionJanitor moves expired and revoked sessions out of refresh_tokens,
+// in batches, and keeps the session_history partitions in shape.
+type SessionJanitor struct {
+	authUserRepo repository.AuthUserRepository
+	policy       policy.RetentionPolicy
+	clock        clock.Clock
+}
+
+func NewSessionJanitor(
+	authUserRepo repository.AuthUserRepository,
+	p policy.RetentionPolicy,
+	clk clock.Clock,
+) *SessionJanitor {
+	return &SessionJanitor{
+		authUserRepo: authUserRepo,
+		policy:       p,
+		clock:        clk,
+	}
+}
+
+// Run cleans up once at startup and then every policy interval until ctx is
+// done. Replicas that lose the advisory lock skip the round.
+func (j *SessionJanitor) Run(ctx context.Context) {
+	log.Printf("[JANITOR] Started mode=%s interval=%s grace=%s history_retention=%s",
+		j.policy.Mode, j.policy.Interval, j.policy.Grace, j.policy.HistoryRetention)
+	ticker := time.NewTicker(j.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		ran, err := j.authUserRepo.RunExclusive(ctx, JanitorLockID, j.cleanup)
+		if err != nil {
+			janitorMetrics.Add("errors", 1)
+			log.Printf("[ERROR] Session janitor failed: %+v", err)
+		} else if !ran {
+			log.Printf("[JANITOR] Another replica holds the lock, skipping")
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("[JANITOR] Stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *SessionJanitor) cleanup(ctx context.Context) error {
+	start := time.Now() // only times the run
+	now := j.clock.Now()
+	archive := j.policy.Mode == policy.RetentionArchive
+
+	// this month and the next, so the default partition stays empty. Needed
+	// in delete mode too, re-logins archive the replaced session. A failure
+	// only leaves rows in the default partition, the next run moves them.
+	if err := j.authUserRepo.EnsureHistoryPartitions(ctx, now, 2); err != nil {
+		janitorMetrics.Add("errors", 1)
+		log.Printf("[ERROR] Session history partitions: %+v", err)
+	}
+
+	before := now.Add(-j.policy.Grace)
+	var moved int64
+	for {
+		n, err := j.authUserRepo.ArchiveFinishedSessions(ctx, now, before, j.policy.BatchSize, archive)
+		if err != nil {
+			return err
+		}
+		moved += n
+		if n < int64(j.policy.BatchSize) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	var pruned int64
+	for {
+		n, err := j.authUserRepo.PruneSessionOutbox(ctx, before, j.policy.BatchSize)
+		if err != nil {
+			return err
+		}
+		pruned += n
+		if n < int64(j.policy.BatchSize) {
+			break
+		}
+	}
+
+	var dropped []string
+	if j.policy.HistoryRetention > 0 {
+		var err error
+		dropped, err = j.authUserRepo.DropHistoryPartitions(ctx, now.Add(-j.policy.HistoryRetention))
+		if err != nil {
+			janitorMetrics.Add("errors", 1)
+			log.Printf("[ERROR] Dropping session history partitions: %+v", err)
+		}
+	}
+
+	janitorMetrics.Add("runs", 1)
+	janitorMetrics.Add("sessions_removed", moved)
+	janitorMetrics.Add("outbox_pruned", pruned)
+	janitorMetrics.Add("partitions_dropped", int64(len(dropped)))
+	last := new(expvar.Int)
+	last.Set(now.Unix())
+	janitorMetrics.Set("last_run_unix", last)
+
+	log.Printf("[JANITOR] Done mode=%s sessions=%d outbox=%d dropped=%v took=%s",
+		j.policy.Mode, moved, pruned, dropped, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/repository"
+)
+
+const (
+	OutboxPollInterval = time.Second * 5
+	OutboxBatchSize    = 100
+	OutboxLease        = time.Minute
+	OutboxMaxBackoff   = time.Minute * 5
+)
+
+// SessionOutboxWorker applies pending session_outbox rows to Redis.
+// Postgres is the source of truth: a row means the device was revoked there
+// and its Redis session has to go. Evicting is idempotent, so a row applied
+// twice (lease expired, crash before completion) does no harm.
+type SessionOutboxWorker struct {
+	sessionStore repository.SessionStore
+	authUserRepo repository.AuthUserRepository
+	clock        clock.Clock
+}
+
+func NewSessionOutboxWorker(
+	sessionStore repository.SessionStore,
+	authUserRepo repository.AuthUserRepository,
+	clk clock.Clock,
+) *SessionOutboxWorker {
+	return &SessionOutboxWorker{
+		sessionStore: sessionStore,
+		authUserRepo: authUserRepo,
+		clock:        clk,
+	}
+}
+
+// Run polls the outbox until ctx is done.
+func (w *SessionOutboxWorker) Run(ctx context.Context) {
+	log.Printf("[OUTBOX] Worker started interval=%s", OutboxPollInterval)
+	ticker := time.NewTicker(OutboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// drain full batches right away
+		for w.processBatch(ctx) == OutboxBatchSize {
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("[OUTBOX] Worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processBatch returns how many events it claimed.
+func (w *SessionOutboxWorker) processBatch(ctx context.Context) int {
+	events, err := w.authUserRepo.ClaimSessionOutbox(ctx, OutboxBatchSize, OutboxLease)
+	if err != nil {
+		log.Printf("[ERROR] Postgres ClaimSessionOutbox failed: %+v", err)
+		return 0
+	}
+
+	for _, ev := range events {
+		// the device logged in again since the revocation
+		if !ev.Revoked {
+			log.Printf("[OUTBOX] Skip id=%d tenant=%s user=%s device=%s, session active again", ev.ID, ev.TenantID, ev.UserID, ev.DeviceID)
+		} else if err := w.sessionStore.LogoutDevice(ctx, ev.TenantID, ev.UserID, ev.DeviceID); err != nil {
+			retryAt := w.clock.Now().Add(outboxBackoff(ev.Attempts + 1))
+			log.Printf("[WARN] Outbox apply failed id=%d attempt=%d retry_at=%s: %+v",
+				ev.ID, ev.Attempts+1, retryAt.Format(time.RFC3339), err)
+			if err := w.authUserRepo.FailSessionOutbox(ctx, ev.ID, err.Error(), retryAt); err != nil {
+				log.Printf("[ERROR] Postgres FailSessionOutbox failed: %+v", err)
+			}
+			continue
+		}
+
+		if err := w.authUserRepo.CompleteSessionOutbox(ctx, ev.TenantID, ev.UserID, []string{ev.DeviceID}); err != nil {
+			log.Printf("[ERROR] Postgres CompleteSessionOutbox failed: %+v", err)
+		}
+	}
+	return len(events)
+}
+
+// outboxBackoff doubles from one second up to OutboxMaxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < OutboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, OutboxMaxBackoff)
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"central-auth/internal/clock"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+)
+
+const (
+	ReconcileInterval = time.Minute * 10
+	ReconcileBatch    = 500
+	// logins write redis before postgres (see AuthService.Login), younger
+	// sessions are left alone
+	ReconcileGrace = time.Minute
+)
+
+// ReconcilerLockID is the postgres advisory lock that elects the replica
+// running the reconciler.
+const ReconcilerLockID int64 = 0x7265636f6e63696c // "reconcil"
+
+// reconciler metrics, served on /debug/vars
+var reconcileMetrics = expvar.NewMap("session_reconciler")
+
+// ReconcileStats counts what one reconciliation pass found and fixed.
+type ReconcileStats struct {
+	Checked    int // active sessions in postgres
+	Restored   int // missing in redis, written back
+	HashFixed  int // redis held another token hash than postgres
+	IndexFixed int // refresh key present but device missing in auth:devices
+	IdleExpire int // active in postgres but past the idle timeout, not restored
+	Orphans    int // in redis without an active postgres session, evicted
+}
+
+// SessionReconciler keeps the Redis session projection in line with
+// refresh_tokens. It rebuilds sessions lost in a Redis wipe and evicts
+// Redis sessions Postgres does not know as active.
+type SessionReconciler struct {
+	sessionStore    repository.SessionStore
+	authUserRepo    repository.AuthUserRepository
+	sessionPolicies *policy.SessionPolicies
+	clock           clock.Clock
+}
+
+func NewSessionReconciler(
+	sessionStore repository.SessionStore,
+	authUserRepo repository.AuthUserRepository,
+	sessionPolicies *policy.SessionPolicies,
+	clk clock.Clock,
+) *SessionReconciler {
+	return &SessionReconciler{
+		sessionStore:    sessionStore,
+		authUserRepo:    authUserRepo,
+		sessionPolicies: sessionPolicies,
+		clock:           clk,
+	}
+}
+
+// Run reconciles once at startup and then every ReconcileInterval until ctx
+// is done. Replicas that lose the advisory lock skip the round, one full
+// pass per interval is enough and parallel passes race on evictions.
+func (r *SessionReconciler) Run(ctx context.Context) {
+	log.Printf("[RECONCILE] Started interval=%s", ReconcileInterval)
+	ticker := time.NewTicker(ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		ran, err := r.authUserRepo.RunExclusive(ctx, ReconcilerLockID, func(ctx context.Context) error {
+			_, err := r.Reconcile(ctx)
+			return err
+		})
+		if err != nil {
+			log.Printf("[ERROR] Session reconcile failed: %+v", err)
+		} else if !ran {
+			log.Printf("[RECONCILE] Another replica holds the lock, skipping")
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("[RECONCILE] Stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile runs one full pass in both directions.
+func (r *SessionReconciler) Reconcile(ctx context.Context) (ReconcileStats, error) {
+	start := time.Now()
+	var stats ReconcileStats
+
+	err := r.restoreFromPostgres(ctx, &stats)
+	if err == nil {
+		err = r.evictOrphans(ctx, &stats)
+	}
+
+	reconcileMetrics.Add("runs", 1)
+	reconcileMetrics.Add("checked", int64(stats.Checked))
+	reconcileMetrics.Add("restored", int64(stats.Restored))
+	reconcileMetrics.Add("hash_fixed", int64(stats.HashFixed))
+	reconcileMetrics.Add("index_fixed", int64(stats.IndexFixed))
+	reconcileMetrics.Add("idle_expired", int64(stats.IdleExpire))
+	reconcileMetrics.Add("orphans_evicted", int64(stats.Orphans))
+	if err != nil {
+		reconcileMetrics.Add("errors", 1)
+	}
+	last := new(expvar.Int)
+	last.Set(start.Unix())
+	reconcileMetrics.Set("last_run_unix", last)
+	took := new(expvar.Int)
+	took.Set(time.Since(start).Milliseconds())
+	reconcileMetrics.Set("last_duration_ms", took)
+
+	log.Printf("[RECONCILE] Done checked=%d restored=%d hash_fixed=%d index_fixed=%d idle_expired=%d orphans=%d took=%s",
+		stats.Checked, stats.Restored, stats.HashFixed, stats.IndexFixed, stats.IdleExpire, stats.Orphans,
+		time.Since(start).Round(time.Millisecond))
+	return stats, err
+}
+
+// restoreFromPostgres writes every active postgres session into redis.
+func (r *SessionReconciler) restoreFromPostgres(ctx context.Context, stats *ReconcileStats) error {
+	var afterTenant, afterUser, afterDevice string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, err := r.authUserRepo.ListActiveSessions(ctx, afterTenant, afterUser, afterDevice, ReconcileBatch)
+		if err != nil {
+			return err
+		}
+
+		now := r.clock.Now()
+		for _, t := range page {
+			stats.Checked++
+
+			lastUsed := t.IssuedAt
+			if t.LastUsedAt != nil && t.LastUsedAt.After(lastUsed) {
+				lastUsed = *t.LastUsedAt
+			}
+			// the idle window counts from the last refresh
+			ttl := t.ExpiresAt.Sub(now)
+			if idle := r.sessionPolicies.Resolve(t.Service).IdleTimeout.Std(); idle > 0 {
+				ttl = min(ttl, lastUsed.Add(idle).Sub(now))
+			}
+			if ttl <= 0 {
+				stats.IdleExpire++
+				continue
+			}
+
+			res, err := r.sessionStore.RestoreSession(ctx, t.TenantID, t.UserID, t.DeviceID, t.TokenHash, t.IssuedAt, lastUsed, ttl, ReconcileGrace)
+			if err != nil {
+				return err
+			}
+			switch res {
+			case repository.RestoreCreated:
+				stats.Restored++
+			case repository.RestoreHashFixed:
+				stats.HashFixed++
+			case repository.RestoreIndexFixed:
+				stats.IndexFixed++
+			}
+		}
+
+		if len(page) < ReconcileBatch {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterTenant, afterUser, afterDevice = last.TenantID, last.UserID, last.DeviceID
+	}
+}
+
+// evictOrphans removes redis sessions postgres has no active row for.
+func (r *SessionReconciler) evictOrphans(ctx context.Context, stats *ReconcileStats) error {
+	return r.sessionStore.EachSession(ctx, ReconcileBatch, func(keys []repository.SessionKey) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		byUser := map[sessionOwner][]string{}
+		for _, k := range keys {
+			owner := sessionOwner{k.TenantID, k.UserID}
+			byUser[owner] = append(byUser[owner], k.DeviceID)
+		}
+		for owner, deviceIDs := range byUser {
+			if err := r.evictUserOrphans(ctx, owner, deviceIDs, stats); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sessionOwner is a user within its tenant.
+type sessionOwner struct {
+	tenantID string
+	userID   string
+}
+
+func (r *SessionReconciler) evictUserOrphans(ctx context.Context, owner sessionOwner, deviceIDs []string, stats *ReconcileStats) error {
+	active, err := r.authUserRepo.ActiveDeviceIDs(ctx, owner.tenantID, owner.userID)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, id := range active {
+		known[id] = true
+	}
+	logins, err := r.sessionStore.GetDevices(ctx, owner.tenantID, owner.userID)
+	if err != nil {
+		return err
+	}
+
+	graceStart := r.clock.Now().Add(-ReconcileGrace)
+	for _, deviceID := range deviceIDs {
+		if known[deviceID] {
+			continue
+		}
+		// postgres may not have seen this login yet
+		if loginAt, ok := logins[deviceID]; ok && !loginAt.Before(graceStart) {
+			continue
+		}
+		if err := r.sessionStore.LogoutDevice(ctx, owner.tenantID, owner.userID, deviceID); err != nil {
+			return err
+		}
+		stats.Orphans++
+		log.Printf("[RECONCILE] Evicted orphan session tenant=%s user=%s device=%s", owner.tenantID, owner.userID, deviceID)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"central-auth/internal/domain"
+	"central-auth/internal/policy"
+	"central-auth/internal/repository"
+	"central-auth/internal/token"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const WebAuthnCeremonyTTL = time.Minute * 5
+
+const (
+	webauthnRegistration = "registration"
+	webauthnLogin        = "login"
+)
+
+var (
+	ErrWebAuthnSessionNotFound = errors.New("webauthn session expired or not found")
+	ErrWebAuthnNoCredentials   = errors.New("no webauthn credentials registered")
+	ErrWebAuthnCloneWarning    = errors.New("authenticator sign count regressed, possible cloned key")
+)
+
+// ceremony is what we keep in Redis between begin and finish.
+type webauthnCeremony struct {
+	Purpose  string               `json:"purpose"`
+	TenantID string               `json:"tenant_id,omitempty"`
+	UserID   string               `json:"user_id,omitempty"`
+	Session  webauthn.SessionData `json:"session"`
+}
+
+// webauthnUser adapts a user and its stored credentials to webauthn.User.
+// user_id (max 64 chars) is used directly as the user handle.
+type webauthnUser struct {
+	userID      string
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte                         { return []byte(u.userID) }
+func (u *webauthnUser) WebAuthnName() string                       { return u.userID }
+func (u *webauthnUser) WebAuthnDisplayName() string                { return u.userID }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+type WebAuthnService struct {
+	webAuthn     *webauthn.WebAuthn
+	sessionStore repository.SessionStore
+	authUserRepo repository.AuthUserRepository
+	authService  *AuthService
+}
+
+func NewWebAuthnService(
+	webAuthn *webauthn.WebAuthn,
+	sessionStore repository.SessionStore,
+	authUserRepo repository.AuthUserRepository,
+	authService *AuthService,
+) *WebAuthnService {
+	return &WebAuthnService{
+		webAuthn:     webAuthn,
+		sessionStore: sessionStore,
+		authUserRepo: authUserRepo,
+		authService:  authService,
+	}
+}
+
+// BeginRegistration starts a passkey registration for the user owning the
+// access token. Returns the ceremony id and the options for navigator.credentials.create().
+func (s *WebAuthnService) BeginRegistration(ctx context.Context, tenantID string, accessToken string) (string, *protocol.CredentialCreation, error) {
+	tenantID = tenantOrDefault(tenantID)
+	userID, err := s.sessionUser(ctx, tenantID, accessToken)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Printf("[AUTH] WebAuthn BeginRegistration tenant=%s user=%s", tenantID, userID)
+
+	user, err := s.loadUser(ctx, tenantID, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	creation, session, err := s.webAuthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		log.Printf("[ERROR] WebAuthn BeginRegistration failed: %+v", err)
+		return "", nil, err
+	}
+
+	sessionID, err := s.saveCeremony(ctx, webauthnRegistration, tenantID, userID, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, creation, nil
+}
+
+// FinishRegistration verifies the attestation response and stores the new credential.
+func (s *WebAuthnService) FinishRegistration(ctx context.Context, tenantID string, accessToken string, sessionID string, credential []byte) error {
+	tenantID = tenantOrDefault(tenantID)
+	userID, err := s.sessionUser(ctx, tenantID, accessToken)
+	if err != nil {
+		return err
+	}
+
+	ceremony, err := s.takeCeremony(ctx, tenantID, sessionID, webauthnRegistration)
+	if err != nil {
+		return err
+	}
+	if ceremony.UserID != userID {
+		log.Printf("[WARN] WebAuthn registration user mismatch token=%s ceremony=%s", userID, ceremony.UserID)
+		return ErrWebAuthnSessionNotFound
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(credential)
+	if err != nil {
+		log.Printf("[ERROR] WebAuthn parse attestation failed: %+v", err)
+		return err
+	}
+
+	user, err := s.loadUser(ctx, tenantID, userID)
+	if err != nil {
+		return err
+	}
+
+	cred, err := s.webAuthn.CreateCredential(user, ceremony.Session, parsed)
+	if err != nil {
+		log.Printf("[ERROR] WebAuthn CreateCredential failed: %+v", err)
+		return err
+	}
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+
+	err = s.authUserRepo.SaveWebAuthnCredential(ctx, &domain.WebAuthnCredential{
+		TenantID:        tenantID,
+		UserID:          userID,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Transports:      transports,
+		Flags:           uint8(cred.Flags.ProtocolValue()),
+		CreatedAt:       s.authService.clock.Now(),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Postgres SaveWebAuthnCredential failed: %+v", err)
+		return err
+	}
+
+	log.Printf("[AUTH] WebAuthn registration success user=%s", userID)
+	return nil
+}
+
+// BeginLogin starts an assertion ceremony. With an empty userID it is a
+// passwordless (discoverable passkey) login; with a userID the caller has
+// already checked a first factor and the passkey is used as MFA.
+func (s *WebAuthnService) BeginLogin(ctx context.Context, tenantID string, userID string) (string, *protocol.CredentialAssertion, error) {
+	tenantID = tenantOrDefault(tenantID)
+	log.Printf("[AUTH] WebAuthn BeginLogin tenant=%s user=%s", tenantID, userID)
+
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		err       error
+	)
+
+	if userID == "" {
+		assertion, session, err = s.webAuthn.BeginDiscoverableLogin(
+			webauthn.WithUserVerification(protocol.VerificationRequired),
+		)
+	} else {
+		user, lerr := s.loadUser(ctx, tenantID, userID)
+		if lerr != nil {
+			return "", nil, lerr
+		}
+		if len(user.credentials) == 0 {
+			return "", nil, ErrWebAuthnNoCredentials
+		}
+		assertion, session, err = s.webAuthn.BeginLogin(user)
+	}
+	if err != nil {
+		log.Printf("[ERROR] WebAuthn BeginLogin failed: %+v", err)
+		return "", nil, err
+	}
+
+	sessionID, err := s.saveCeremony(ctx, webauthnLogin, tenantID, userID, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, assertion, nil
+}
+
+// FinishLogin verifies the assertion and creates a regular session through AuthService.Login,
+// so device limits and Redis sessions apply exactly as for any other login.
+func (s *WebAuthnService) FinishLogin(
+	ctx context.Context,
+	sessionID string,
+	credential []byte,
+	deviceID string,
+	rememberMe bool,
+	opts LoginOptions,
+	userAgent *string,
+	ip *string,
+) (*LoginResult, error) {
+
+	opts.Tenant = tenantOrDefault(opts.Tenant)
+	opts.Provider = policy.ProviderWebAuthn
+	userID, auth, err := s.verifyAssertion(ctx, opts.Tenant, sessionID, credential)
+	if err != nil {
+		return nil, err
+	}
+	return s.authService.Login(ctx, userID, deviceID, rememberMe, auth, opts, userAgent, ip)
+}
+
+// FinishReauth verifies an assertion (started with BeginLogin(user_id)) and
+// upgrades the session of refreshToken instead of creating a new one.
+func (s *WebAuthnService) FinishReauth(ctx context.Context, tenantID string, refreshToken string, sessionID string, credential []byte) (string, string, error) {
+	claims, err := token.ParseAt(refreshToken, tenantID, s.authService.clock.Now())
+	if err != nil {
+		log.Printf("[ERROR] Token parse failed: %+v", err)
+		return "", "", err
+	}
+
+	userID, auth, err := s.verifyAssertion(ctx, claims.Tenant(), sessionID, credential)
+	if err != nil {
+		return "", "", err
+	}
+	if userID != claims.UserID {
+		log.Printf("[WARN] WebAuthn reauth user mismatch token=%s assertion=%s", claims.UserID, userID)
+		return "", "", errors.New("credential does not belong to session user")
+	}
+	return s.authService.Reauthenticate(ctx, claims.Tenant(), refreshToken, auth)
+}
+
+// verifyAssertion checks an assertion against its ceremony and returns the
+// authenticated user and the resulting auth info. Only credentials of
+// tenantID's users are accepted.
+func (s *WebAuthnService) verifyAssertion(ctx context.Context, tenantID string, sessionID string, credential []byte) (string, token.AuthInfo, error) {
+	ceremony, err := s.takeCeremony(ctx, tenantID, sessionID, webauthnLogin)
+	if err != nil {
+		return "", token.AuthInfo{}, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(credential)
+	if err != nil {
+		log.Printf("[ERROR] WebAuthn parse assertion failed: %+v", err)
+		return "", token.AuthInfo{}, err
+	}
+
+	var (
+		user *webauthnUser
+		cred *webauthn.Credential
+	)
+
+	if ceremony.UserID == "" {
+		handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+			u, err := s.loadUser(ctx, tenantID, string(userHandle))
+			if err != nil {
+				return nil, err
+			}
+			user = u
+			return u, nil
+		}
+		cred, err = s.webAuthn.ValidateDiscoverableLogin(handler, ceremony.Session, parsed)
+	} else {
+		user, err = s.loadUser(ctx, tenantID, ceremony.UserID)
+		if err != nil {
+			return "", token.AuthInfo{}, err
+		}
+		cred, err = s.webAuthn.ValidateLogin(user, ceremony.Session, parsed)
+	}
+	if err != nil {
+		log.Printf("[WARN] WebAuthn assertion rejected: %+v", err)
+		return "", token.AuthInfo{}, err
+	}
+
+	if err := s.authUserRepo.UpdateWebAuthnSignCount(
+		ctx,
+		cred.ID,
+		cred.Authenticator.SignCount,
+		cred.Authenticator.CloneWarning,
+	); err != nil {
+		log.Printf("[ERROR] Postgres UpdateWebAuthnSignCount failed: %+v", err)
+		return "", token.AuthInfo{}, err
+	}
+	if cred.Authenticator.CloneWarning {
+		log.Printf("[WARN] WebAuthn clone warning user=%s", user.userID)
+		return "", token.AuthInfo{}, ErrWebAuthnCloneWarning
+	}
+
+	amr := []string{token.AMRHardwareKey}
+	if cred.Flags.UserVerified {
+		amr = append(amr, token.AMRUserVerification)
+	}
+	if ceremony.UserID != "" {
+		// second factor after the caller's own first factor
+		amr = append(amr, token.AMRExternal)
+	}
+
+	log.Printf("[AUTH] WebAuthn assertion success user=%s", user.userID)
+	return user.userID, s.authService.NewAuthInfo(amr...), nil
+}
+
+func (s *WebAuthnService) sessionUser(ctx context.Context, tenantID string, accessToken string) (string, error) {
+	claims, err := s.authService.activeClaims(ctx, tenantID, accessToken)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+func (s *WebAuthnService) loadUser(ctx context.Context, tenantID, userID string) (*webauthnUser, error) {
+	stored, err := s.authUserRepo.GetWebAuthnCredentials(ctx, tenantID, userID)
+	if err != nil {
+		log.Printf("[ERROR] Postgres GetWebAuthnCredentials failed: %+v", err)
+		return nil, err
+	}
+
+	user := &webauthnUser{userID: userID}
+	for _, c := range stored {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		user.credentials = append(user.credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(c.Flags)),
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       c.AAGUID,
+				SignCount:    c.SignCount,
+				CloneWarning: c.CloneWarning,
+			},
+		})
+	}
+	return user, nil
+}
+
+func (s *WebAuthnService) saveCeremony(ctx context.Context, purpose, tenantID, userID string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(webauthnCeremony{
+		Purpose:  purpose,
+		TenantID: tenantID,
+		UserID:   userID,
+		Session:  *session,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sessionID := s.authService.ids.NewID()
+	if err := s.sessionStore.SaveWebAuthnSession(ctx, sessionID, data, WebAuthnCeremonyTTL); err != nil {
+		log.Printf("[ERROR] Redis SaveWebAuthnSession failed: %+v", err)
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// takeCeremony consumes the ceremony; one begun for another tenant or
+// purpose is reported as not found.
+func (s *WebAuthnService) takeCeremony(ctx context.Context, tenantID, sessionID, purpose string) (*webauthnCeremony, error) {
+	data, err := s.sessionStore.TakeWebAuthnSession(ctx, sessionID)
+	if err != nil {
+		log.Printf("[ERROR] Redis TakeWebAuthnSession failed: %+v", err)
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+
+	var ceremony webauthnCeremony
+	if err := json.Unmarshal(data, &ceremony); err != nil {
+		return nil, err
+	}
+	if ceremony.Purpose != purpose || tenantOrDefault(ceremony.TenantID) != tenantID {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+	return &ceremony, nil
+}
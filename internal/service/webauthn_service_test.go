@@ -0,0 +1,334 @@
+package service_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"central-auth/internal/policy"
+	"central-auth/internal/service"
+	"central-auth/internal/token"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	rpID     = "auth.example"
+	rpOrigin = "https://auth.example"
+)
+
+// authenticator is a software passkey: one ES256 credential, "none"
+// attestation.
+type authenticator struct {
+	t          *testing.T
+	credID     []byte
+	key        *ecdsa.PrivateKey
+	userHandle []byte
+	signCount  uint32
+	// user verification on assertions
+	verified bool
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &authenticator{t: t, credID: credID, key: key, verified: true}
+}
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+func (a *authenticator) clientData(typ string, challenge protocol.URLEncodedBase64) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge.String(),
+		"origin":    rpOrigin,
+	})
+	return data
+}
+
+func (a *authenticator) authData(flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	data := append(rpHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// register answers navigator.credentials.create().
+func (a *authenticator) register(creation *protocol.CredentialCreation) []byte {
+	a.userHandle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+
+	pub, err := a.key.PublicKey.ECDH()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	point := pub.Bytes() // 0x04 || x || y
+	coseKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: point[1:33],
+		YCoord: point[33:],
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	attested := make([]byte, 16) // zero aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credID)))
+	attested = append(attested, a.credID...)
+	attested = append(attested, coseKey...)
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData
+	object, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flags, attested),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", creation.Response.Challenge)),
+			"attestationObject": b64(object),
+		},
+	})
+	return data
+}
+
+// assert answers navigator.credentials.get().
+func (a *authenticator) assert(assertion *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	flags := protocol.FlagUserPresent
+	if a.verified {
+		flags |= protocol.FlagUserVerified
+	}
+	authData := a.authData(flags, nil)
+	clientData := a.clientData("webauthn.get", assertion.Response.Challenge)
+
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return data
+}
+
+type webauthnEnv struct {
+	*env
+	webauthn *service.WebAuthnService
+	key      *authenticator
+	// access token of u1 on d1
+	accessToken  string
+	refreshToken string
+}
+
+// newWebAuthnEnv logs u1 in on d1 and registers a passkey for it.
+func newWebAuthnEnv(t *testing.T) *webauthnEnv {
+	e := newMemoryEnv(t, fiveDevices, policy.SessionPolicy{})
+	wa, err := webauthn.New(&webauthn.Config{RPID: rpID, RPDisplayName: "central-auth", RPOrigins: []string{rpOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &webauthnEnv{
+		env:      e,
+		webauthn: service.NewWebAuthnService(wa, e.sessions, e.repo, e.svc),
+		key:      newAuthenticator(t),
+	}
+	res := e.login(t, "d1")
+	w.accessToken, w.refreshToken = res.AccessToken, res.RefreshToken
+
+	sessionID, creation, err := w.webauthn.BeginRegistration(ctx, tenant, w.accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.webauthn.FinishRegistration(ctx, tenant, w.accessToken, sessionID, w.key.register(creation)); err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return w
+}
+
+// passkeyLogin runs a login ceremony, userID empty for a discoverable one.
+func (w *webauthnEnv) passkeyLogin(t *testing.T, userID, deviceID string) (*service.LoginResult, error) {
+	t.Helper()
+	sessionID, assertion, err := w.webauthn.BeginLogin(ctx, tenant, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.webauthn.FinishLogin(ctx, sessionID, w.key.assert(assertion), deviceID, false, service.LoginOptions{}, nil, nil)
+}
+
+func (w *webauthnEnv) claims(t *testing.T, accessToken string) *token.Claims {
+	t.Helper()
+	claims, err := token.ParseAt(accessToken, tenant, w.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	w := newWebAuthnEnv(t)
+
+	creds, _ := w.repo.GetWebAuthnCredentials(ctx, tenant, "u1")
+	if len(creds) != 1 || !slices.Equal(creds[0].CredentialID, w.key.credID) {
+		t.Fatalf("credentials = %+v", creds)
+	}
+
+	// a ceremony is finished once
+	sessionID, creation, err := w.webauthn.BeginRegistration(ctx, tenant, w.accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newAuthenticator(t)
+	credential := other.register(creation)
+	if err := w.webauthn.FinishRegistration(ctx, tenant, w.accessToken, sessionID, credential); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.webauthn.FinishRegistration(ctx, tenant, w.accessToken, sessionID, credential); !errors.Is(err, service.ErrWebAuthnSessionNotFound) {
+		t.Fatalf("second FinishRegistration = %v, want ErrWebAuthnSessionNotFound", err)
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	w := newWebAuthnEnv(t)
+
+	// discoverable with user verification: phishing resistant
+	res, err := w.passkeyLogin(t, "", "d2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := w.claims(t, res.AccessToken)
+	if claims.UserID != "u1" || claims.ACR != token.ACRPhishingResistant || !slices.Equal(claims.AMR, []string{token.AMRHardwareKey, token.AMRUserVerification}) {
+		t.Fatalf("passkey login claims = %+v", claims)
+	}
+
+	// second factor after the backend's own, without user verification
+	w.key.verified = false
+	res, err = w.passkeyLogin(t, "u1", "d3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims := w.claims(t, res.AccessToken); claims.ACR != token.ACRMultiFactor || slices.Contains(claims.AMR, token.AMRUserVerification) {
+		t.Fatalf("passkey mfa claims = %+v", claims)
+	}
+
+	// the same assertion can not be replayed
+	w.key.verified = true
+	sessionID, assertion, err := w.webauthn.BeginLogin(ctx, tenant, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := w.key.assert(assertion)
+	if _, err := w.webauthn.FinishLogin(ctx, sessionID, credential, "d4", false, service.LoginOptions{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.webauthn.FinishLogin(ctx, sessionID, credential, "d4", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrWebAuthnSessionNotFound) {
+		t.Fatalf("replayed FinishLogin = %v, want ErrWebAuthnSessionNotFound", err)
+	}
+}
+
+func TestWebAuthnCeremonyScoped(t *testing.T) {
+	w := newWebAuthnEnv(t)
+
+	// begun for acme, finished in the default tenant
+	sessionID, assertion, err := w.webauthn.BeginLogin(ctx, "acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.webauthn.FinishLogin(ctx, sessionID, w.key.assert(assertion), "d2", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrWebAuthnSessionNotFound) {
+		t.Fatalf("FinishLogin in another tenant = %v, want ErrWebAuthnSessionNotFound", err)
+	}
+
+	// a registration ceremony does not finish a login
+	sessionID, _, err = w.webauthn.BeginRegistration(ctx, tenant, w.accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, assertion, _ = w.webauthn.BeginLogin(ctx, tenant, "")
+	if _, err := w.webauthn.FinishLogin(ctx, sessionID, w.key.assert(assertion), "d2", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrWebAuthnSessionNotFound) {
+		t.Fatalf("FinishLogin with a registration ceremony = %v, want ErrWebAuthnSessionNotFound", err)
+	}
+
+	// ceremonies expire
+	sessionID, assertion, _ = w.webauthn.BeginLogin(ctx, tenant, "")
+	w.advance(service.WebAuthnCeremonyTTL)
+	if _, err := w.webauthn.FinishLogin(ctx, sessionID, w.key.assert(assertion), "d2", false, service.LoginOptions{}, nil, nil); !errors.Is(err, service.ErrWebAuthnSessionNotFound) {
+		t.Fatalf("FinishLogin after expiry = %v, want ErrWebAuthnSessionNotFound", err)
+	}
+}
+
+func TestWebAuthnCloneWarning(t *testing.T) {
+	w := newWebAuthnEnv(t)
+	w.key.signCount = 10
+	if _, err := w.passkeyLogin(t, "", "d2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy of the key still counts from an older value
+	w.key.signCount = 5
+	if _, err := w.passkeyLogin(t, "", "d3"); !errors.Is(err, service.ErrWebAuthnCloneWarning) {
+		t.Fatalf("FinishLogin with regressed counter = %v, want ErrWebAuthnCloneWarning", err)
+	}
+	if ok, _ := w.sessions.ExistsRefreshToken(ctx, tenant, "u1", "d3"); ok {
+		t.Fatal("session created despite the clone warning")
+	}
+	creds, _ := w.repo.GetWebAuthnCredentials(ctx, tenant, "u1")
+	if len(creds) != 1 || !creds[0].CloneWarning {
+		t.Fatalf("credential = %+v, want clone warning stored", creds)
+	}
+}
+
+func TestWebAuthnReauth(t *testing.T) {
+	w := newWebAuthnEnv(t)
+	w.advance(time.Minute)
+
+	sessionID, assertion, err := w.webauthn.BeginLogin(ctx, tenant, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, refresh, err := w.webauthn.FinishReauth(ctx, tenant, w.refreshToken, sessionID, w.key.assert(assertion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := w.claims(t, access)
+	if claims.DeviceID != "d1" || claims.ACR != token.ACRPhishingResistant || !claims.AuthInfo().AuthTime.Equal(w.clock.Now()) {
+		t.Fatalf("reauth claims = %+v", claims)
+	}
+	if _, err := w.svc.Refresh(ctx, tenant, refresh); err != nil {
+		t.Fatalf("Refresh after reauth: %v", err)
+	}
+}
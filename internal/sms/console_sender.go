@@ -0,0 +1,39 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConsoleSender writes messages to a writer instead of sending them.
+// Meant for local development, never use it in production.
+type ConsoleSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewConsoleSender(w io.Writer) *ConsoleSender {
+	return &ConsoleSender{w: w}
+}
+
+// NewFileSender appends messages to the file at path.
+func NewFileSender(path string) (*ConsoleSender, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &ConsoleSender{w: f}, nil
+}
+
+func (s *ConsoleSender) Send(ctx context.Context, phone string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintf(s.w, "[SMS] %s to=%s message=%q\n",
+		time.Now().Format(time.RFC3339), phone, message)
+	return err
+}
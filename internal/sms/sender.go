@@ -0,0 +1,8 @@
+package sms
+
+import "context"
+
+// Sender delivers a text message to a phone number (E.164).
+type Sender interface {
+	Send(ctx context.Context, phone string, message string) error
+}
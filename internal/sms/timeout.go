@@ -0,0 +1,23 @@
+package sms
+
+import (
+	"context"
+	"time"
+)
+
+// WithTimeout bounds every Send of next by timeout, or the caller's
+// deadline when that is earlier.
+func WithTimeout(next Sender, timeout time.Duration) Sender {
+	return &timeoutSender{next: next, timeout: timeout}
+}
+
+type timeoutSender struct {
+	next    Sender
+	timeout time.Duration
+}
+
+func (s *timeoutSender) Send(ctx context.Context, phone string, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Send(ctx, phone, message)
+}
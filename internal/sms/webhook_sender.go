@@ -0,0 +1,57 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookSender POSTs {"to": ..., "message": ...} to an SMS gateway.
+type WebhookSender struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewWebhookSender(url string, token string) *WebhookSender {
+	return &WebhookSender{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type webhookPayload struct {
+	To      string `json:"to"`
+	Message string `json:"message"`
+}
+
+func (s *WebhookSender) Send(ctx context.Context, phone string, message string) error {
+	body, err := json.Marshal(webhookPayload{To: phone, Message: message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
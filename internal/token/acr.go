@@ -0,0 +1,105 @@
+package token
+
+import (
+	"slices"
+	"time"
+)
+
+// Authentication methods (RFC 8176 values where one exists).
+const (
+	AMRExternal         = "ext" // user authenticated by the calling backend
+	AMRFederated        = "fed" // OAuth / OIDC provider (Google)
+	AMRPassword         = "pwd"
+	AMROTP              = "otp"
+	AMRSMS              = "sms"
+	AMRHardwareKey      = "hwk" // WebAuthn / passkey
+	AMRUserVerification = "user"
+	AMRMFA              = "mfa"
+)
+
+// Authentication context classes, weakest first.
+const (
+	ACRSingleFactor      = "aal1"
+	ACRMultiFactor       = "aal2"
+	ACRPhishingResistant = "aal3"
+)
+
+var acrOrder = []string{ACRSingleFactor, ACRMultiFactor, ACRPhishingResistant}
+
+// factors are the methods that count towards the acr, anything else is
+// ignored.
+var factors = []string{AMRExternal, AMRFederated, AMRPassword, AMROTP, AMRSMS, AMRHardwareKey}
+
+// asserted are the methods a calling backend may report for a login or
+// reauth. hwk and user only come from a WebAuthn assertion, mfa is derived
+// by NewAuthInfo.
+var asserted = []string{AMRExternal, AMRFederated, AMRPassword, AMROTP, AMRSMS}
+
+// AuthInfo describes the authentication event behind a session
+// (auth_time / acr / amr claims), when that session must end (session_exp),
+// the calling service it was created through (azp) and its tenant (tid).
+type AuthInfo struct {
+	AuthTime         time.Time
+	ACR              string
+	AMR              []string
+	SessionExpiresAt time.Time
+	Service          string
+	Tenant           string
+}
+
+// NewAuthInfo builds AuthInfo for an authentication that just happened with the given methods.
+func NewAuthInfo(authTime time.Time, amr ...string) AuthInfo {
+	methods := make([]string, 0, len(amr)+1)
+	for _, m := range amr {
+		if m != "" && !slices.Contains(methods, m) {
+			methods = append(methods, m)
+		}
+	}
+	if len(methods) == 0 {
+		methods = append(methods, AMRExternal)
+	}
+
+	acr := ACRSingleFactor
+	n := 0
+	for _, m := range methods {
+		if slices.Contains(factors, m) {
+			n++
+		}
+	}
+	if n >= 2 {
+		acr = ACRMultiFactor
+		if !slices.Contains(methods, AMRMFA) {
+			methods = append(methods, AMRMFA)
+		}
+	}
+	// a user-verified hardware key is phishing resistant on its own
+	if slices.Contains(methods, AMRHardwareKey) && slices.Contains(methods, AMRUserVerification) {
+		acr = ACRPhishingResistant
+	}
+
+	return AuthInfo{AuthTime: authTime, ACR: acr, AMR: methods}
+}
+
+// ACRSatisfies reports whether acr is at least as strong as required.
+// An empty requirement is always satisfied, an unknown acr never is.
+func ACRSatisfies(acr, required string) bool {
+	if required == "" {
+		return true
+	}
+	have := slices.Index(acrOrder, acr)
+	want := slices.Index(acrOrder, required)
+	if want < 0 {
+		return false
+	}
+	return have >= want
+}
+
+// ValidAMR reports whether a calling backend may assert the method amr.
+func ValidAMR(amr string) bool {
+	return slices.Contains(asserted, amr)
+}
+
+// ValidACR reports whether acr is one of the known context classes.
+func ValidACR(acr string) bool {
+	return slices.Contains(acrOrder, acr)
+}
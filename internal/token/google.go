@@ -13,8 +13,8 @@ type GoogleClaims struct {
 	Name    string
 }
 
-func VerifyGoogleIDToken(idToken string, clientID string) (*GoogleClaims, error) {
-	payload, err := idtoken.Validate(context.Background(), idToken, clientID)
+func VerifyGoogleIDToken(ctx context.Context, idToken string, clientID string) (*GoogleClaims, error) {
+	payload, err := idtoken.Validate(ctx, idToken, clientID)
 	if err != nil {
 		return nil, err
 	}
@@ -4,32 +4,80 @@ import (
 	"time"
 	"errors"
 
+	"central-auth/internal/domain"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Secret signs the tokens of tenants without their own key. main sets it from
+// jwt.secret, the configuration refuses this placeholder.
 var Secret = []byte("CHANGE_THIS_SECRET")
 
+// TenantSecrets holds the signing keys of tenants that have their own,
+// the others sign with Secret. Set at startup.
+var TenantSecrets = map[string][]byte{}
+
+// ErrTenantMismatch: the token was issued for another tenant.
+var ErrTenantMismatch = errors.New("token belongs to another tenant")
+
+func secretFor(tenantID string) []byte {
+	if key, ok := TenantSecrets[tenantID]; ok {
+		return key
+	}
+	return Secret
+}
+
 type Claims struct {
 	UserID string `json:"user_id"`
 	DeviceID string `json:"device_id"`
+	AuthTime int64 `json:"auth_time,omitempty"`
+	ACR string `json:"acr,omitempty"`
+	AMR []string `json:"amr,omitempty"`
+	// absolute end of the session, re-authentication is required after it
+	SessionExp int64 `json:"session_exp,omitempty"`
+	// calling service the session was created through
+	AuthorizedParty string `json:"azp,omitempty"`
+	// tenant of the user, absent in tokens of the default tenant
+	TenantID string `json:"tid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func Generate(userID string, deviceID string, ttl time.Duration) (string, error) {
+// Generate signs a token issued at now that expires ttl later.
+func Generate(userID string, deviceID string, now time.Time, ttl time.Duration, auth AuthInfo) (string, error) {
+	var authTime, sessionExp int64
+	if !auth.AuthTime.IsZero() {
+		authTime = auth.AuthTime.Unix()
+	}
+	if !auth.SessionExpiresAt.IsZero() {
+		sessionExp = auth.SessionExpiresAt.Unix()
+	}
+
 	claims := Claims{
 		UserID: userID,
 		DeviceID: deviceID,
+		AuthTime: authTime,
+		ACR: auth.ACR,
+		AMR: auth.AMR,
+		SessionExp: sessionExp,
+		AuthorizedParty: auth.Service,
+		TenantID: tenantClaim(auth.Tenant),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
-			IssuedAt: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt: jwt.NewNumericDate(now),
 		},
 	}
-
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString(Secret)
+	return t.SignedString(secretFor(tenantOrDefault(auth.Tenant)))
 }
 
-func Parse(tokenStr string) (*Claims, error) {
+func Parse(tokenStr string, tenantID string) (*Claims, error) {
+	return ParseAt(tokenStr, tenantID, time.Now())
+}
+
+// ParseAt validates the token as of now with the key of tenantID and
+// rejects tokens of any other tenant.
+func ParseAt(tokenStr string, tenantID string, now time.Time) (*Claims, error) {
+	tenantID = tenantOrDefault(tenantID)
 	token, err := jwt.ParseWithClaims(
 		tokenStr,
 		&Claims{},
@@ -37,8 +85,9 @@ func Parse(tokenStr string) (*Claims, error) {
 			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, errors.New("unexpected signing method")
 			}
-			return Secret, nil
+			return secretFor(tenantID), nil
 		},
+		jwt.WithTimeFunc(func() time.Time { return now }),
 	)
 	if err != nil {
 		return nil, err
@@ -48,5 +97,42 @@ func Parse(tokenStr string) (*Claims, error) {
 	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	// tenants sharing the global secret are told apart by tid
+	if claims.Tenant() != tenantID {
+		return nil, ErrTenantMismatch
+	}
 	return claims, nil
-}
\ No newline at end of file
+}
+
+// Tenant returns the tenant the token was issued for.
+func (c *Claims) Tenant() string {
+	return tenantOrDefault(c.TenantID)
+}
+
+func tenantOrDefault(tenantID string) string {
+	if tenantID == "" {
+		return domain.DefaultTenant
+	}
+	return tenantID
+}
+
+// tenantClaim leaves tid out of default tenant tokens, they stay as they
+// were before tenants.
+func tenantClaim(tenantID string) string {
+	if tenantID == domain.DefaultTenant {
+		return ""
+	}
+	return tenantID
+}
+
+// AuthInfo returns how and when the session behind this token was authenticated.
+func (c *Claims) AuthInfo() AuthInfo {
+	info := AuthInfo{ACR: c.ACR, AMR: c.AMR, Service: c.AuthorizedParty, Tenant: c.Tenant()}
+	if c.AuthTime > 0 {
+		info.AuthTime = time.Unix(c.AuthTime, 0)
+	}
+	if c.SessionExp > 0 {
+		info.SessionExpiresAt = time.Unix(c.SessionExp, 0)
+	}
+	return info
+}
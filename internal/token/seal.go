@@ -0,0 +1,60 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// sealKeyInfo labels the HKDF derivation of the AES key.
+const sealKeyInfo = "central-auth sealed secrets v1"
+
+var ErrSealedSecret = errors.New("sealed secret can not be opened")
+
+// Sealer encrypts secrets the server has to read back, stored as
+// nonce || AES-256-GCM ciphertext. The key never goes into the database.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+func NewSealer(secret []byte) (*Sealer, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("sealer: empty secret")
+	}
+	key, err := hkdf.Key(sha256.New, secret, nil, sealKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+func (s *Sealer) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *Sealer) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, ErrSealedSecret
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrSealedSecret
+	}
+	return plaintext, nil
+}